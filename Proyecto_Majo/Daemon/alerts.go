@@ -0,0 +1,607 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Métricas sobre las que se pueden definir reglas.
+const (
+	alertHostMemory     = "host_memory_percent"   // UsedKB / TotalKB
+	alertContainerRSS   = "container_rss_kb"      // una serie por contenedor
+	alertContainerCPU   = "container_cpu_percent" // una serie por contenedor
+	alertKillRate       = "kill_rate"             // contenedores eliminados dentro de window
+	alertProcReadErrors = "proc_read_errors"      // iteraciones sin muestra dentro de window
+)
+
+// Estados de una alerta en alerts y en las notificaciones.
+const (
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+)
+
+// AlertRules es el archivo de reglas; ver alerts.example.json.
+type AlertRules struct {
+	Rules []AlertRule `json:"rules"`
+}
+
+// AlertRule dispara cuando la métrica cumple la comparación durante For.
+type AlertRule struct {
+	Name      string       `json:"name"`
+	Metric    string       `json:"metric"`
+	Op        string       `json:"op"` // >, >=, < o <=; por defecto >
+	Threshold float64      `json:"threshold"`
+	For       jsonDuration `json:"for"`
+	Window    jsonDuration `json:"window"` // solo kill_rate y proc_read_errors; por defecto 5m
+	Severity  string       `json:"severity"`
+	Webhook   string       `json:"webhook,omitempty"` // reemplaza alert-webhook para esta regla
+}
+
+// jsonDuration acepta duraciones de Go ("30s", "5m") en JSON.
+type jsonDuration time.Duration
+
+func (d *jsonDuration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("se esperaba una duración como \"30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = jsonDuration(parsed)
+	return nil
+}
+
+// LoadAlertRules lee y valida las reglas desde un archivo JSON.
+func LoadAlertRules(path string) (*AlertRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules AlertRules
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("error parseando reglas %s: %w", path, err)
+	}
+
+	if err := rules.validate(); err != nil {
+		return nil, fmt.Errorf("reglas inválidas %s: %w", path, err)
+	}
+	return &rules, nil
+}
+
+// DefaultAlertRules vigila el host y el propio daemon; las reglas por
+// contenedor dependen de la carga y se definen en el archivo.
+func DefaultAlertRules() *AlertRules {
+	rules := &AlertRules{Rules: []AlertRule{
+		{Name: "host-memory-high", Metric: alertHostMemory, Threshold: 90, For: jsonDuration(time.Minute), Severity: "warning"},
+		{Name: "kill-rate-high", Metric: alertKillRate, Threshold: 10, Window: jsonDuration(10 * time.Minute), Severity: "warning"},
+		{Name: "proc-read-failing", Metric: alertProcReadErrors, Op: ">=", Threshold: 3, Window: jsonDuration(5 * time.Minute), Severity: "critical"},
+	}}
+	rules.validate()
+	return rules
+}
+
+func (r *AlertRules) validate() error {
+	seen := make(map[string]bool)
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		if rule.Name == "" {
+			return fmt.Errorf("rules[%d]: falta el nombre", i)
+		}
+		if seen[rule.Name] {
+			return fmt.Errorf("regla %q duplicada", rule.Name)
+		}
+		seen[rule.Name] = true
+
+		switch rule.Metric {
+		case alertHostMemory, alertContainerRSS, alertContainerCPU, alertKillRate, alertProcReadErrors:
+		default:
+			return fmt.Errorf("regla %q: métrica desconocida %q", rule.Name, rule.Metric)
+		}
+
+		if rule.Op == "" {
+			rule.Op = ">"
+		}
+		if _, err := compareAlert(rule.Op, 0, 0); err != nil {
+			return fmt.Errorf("regla %q: %w", rule.Name, err)
+		}
+		if rule.For < 0 || rule.Window < 0 {
+			return fmt.Errorf("regla %q: for y window no pueden ser negativos", rule.Name)
+		}
+		if rule.Window == 0 {
+			rule.Window = jsonDuration(5 * time.Minute)
+		}
+		if rule.Severity == "" {
+			rule.Severity = "warning"
+		}
+	}
+	return nil
+}
+
+func compareAlert(op string, value, threshold float64) (bool, error) {
+	switch op {
+	case ">":
+		return value > threshold, nil
+	case ">=":
+		return value >= threshold, nil
+	case "<":
+		return value < threshold, nil
+	case "<=":
+		return value <= threshold, nil
+	}
+	return false, fmt.Errorf("operador desconocido %q", op)
+}
+
+// alertInstance es una regla evaluada sobre una serie (el host o un contenedor).
+type alertInstance struct {
+	id           int64 // fila en alerts; 0 mientras está pendiente
+	rule         string
+	key          string
+	labels       map[string]string
+	pendingSince time.Time
+	firing       bool
+	startedAt    time.Time
+	value        float64
+}
+
+// alertSample es el valor de una serie en esta iteración.
+type alertSample struct {
+	labels map[string]string
+	value  float64
+}
+
+// alertNotification es un envío pendiente al webhook.
+type alertNotification struct {
+	alertID int64
+	url     string
+	retries int
+	timeout time.Duration
+	payload alertPayload
+}
+
+// alertPayload es el cuerpo JSON que recibe el webhook.
+type alertPayload struct {
+	Status     string            `json:"status"`
+	Rule       string            `json:"rule"`
+	Severity   string            `json:"severity"`
+	Metric     string            `json:"metric"`
+	Labels     map[string]string `json:"labels"`
+	Value      float64           `json:"value"`
+	Op         string            `json:"op"`
+	Threshold  float64           `json:"threshold"`
+	StartedAt  time.Time         `json:"started_at"`
+	ResolvedAt *time.Time        `json:"resolved_at,omitempty"`
+	Summary    string            `json:"summary"`
+}
+
+// alertManager evalúa las reglas desde el loop principal y entrega las
+// notificaciones en otra goroutine para no bloquear las iteraciones.
+type alertManager struct {
+	rules        *AlertRules
+	active       map[string]*alertInstance // regla + "|" + serie
+	readFailures []time.Time
+
+	// delivering indica que la goroutine de entrega está corriendo; sin ella
+	// nadie vacía la cola y las alertas solo se registran
+	delivering bool
+	queue      chan alertNotification
+	stop       chan struct{}
+	wg         sync.WaitGroup
+}
+
+func newAlertManager() *alertManager {
+	return &alertManager{
+		active: make(map[string]*alertInstance),
+		queue:  make(chan alertNotification, 100),
+		stop:   make(chan struct{}),
+	}
+}
+
+func loadAlertRules(config *DaemonConfig) (*AlertRules, error) {
+	if config.AlertRulesPath == "" {
+		return DefaultAlertRules(), nil
+	}
+
+	rules, err := LoadAlertRules(config.AlertRulesPath)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Reglas de alerta cargadas desde %s", config.AlertRulesPath)
+	return rules, nil
+}
+
+// startAlerts recupera las alertas activas de una ejecución anterior, para no
+// volver a notificarlas, e inicia la entrega de notificaciones.
+func (d *Daemon) startAlerts(ctx context.Context) error {
+	if err := d.loadActiveAlerts(ctx); err != nil {
+		return err
+	}
+
+	d.alerts.wg.Add(1)
+	go func() {
+		defer d.alerts.wg.Done()
+		for {
+			select {
+			case n := <-d.alerts.queue:
+				d.deliverAlert(ctx, n)
+			case <-d.alerts.stop:
+				// Se entregan las que ya estaban encoladas antes de terminar
+				for {
+					select {
+					case n := <-d.alerts.queue:
+						d.deliverAlert(ctx, n)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+	d.alerts.delivering = true
+
+	d.onShutdown("alertas", func(shutdownCtx context.Context) error {
+		close(d.alerts.stop)
+		done := make(chan struct{})
+		go func() {
+			d.alerts.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-shutdownCtx.Done():
+			return fmt.Errorf("notificaciones pendientes sin entregar: %w", shutdownCtx.Err())
+		}
+	})
+	return nil
+}
+
+// noteReadFailure registra una iteración que no pudo leer la muestra.
+func (m *alertManager) noteReadFailure(at time.Time) {
+	m.readFailures = append(m.readFailures, at)
+}
+
+// evaluateAlerts evalúa todas las reglas al final de la iteración. system y
+// containers son nil si la muestra no se pudo leer; las reglas que dependen
+// de ellos conservan su estado.
+func (d *Daemon) evaluateAlerts(ctx context.Context, system *SystemInfo, containers []Container) {
+	now := time.Now()
+	m := d.alerts
+	m.pruneReadFailures(now)
+
+	rules := make(map[string]*AlertRule, len(m.rules.Rules))
+	for i := range m.rules.Rules {
+		rule := &m.rules.Rules[i]
+		rules[rule.Name] = rule
+
+		samples, ok := d.alertSamples(ctx, rule, now, system, containers)
+		if !ok {
+			continue
+		}
+
+		for key, sample := range samples {
+			id := rule.Name + "|" + key
+			inst := m.active[id]
+			matched, _ := compareAlert(rule.Op, sample.value, rule.Threshold)
+
+			if !matched {
+				if inst != nil {
+					inst.value = sample.value
+					d.resolveAlert(ctx, rule, inst, now)
+					delete(m.active, id)
+				}
+				continue
+			}
+
+			if inst == nil {
+				inst = &alertInstance{rule: rule.Name, key: key, labels: sample.labels, pendingSince: now}
+				m.active[id] = inst
+			}
+			inst.value = sample.value
+			if !inst.firing && now.Sub(inst.pendingSince) >= time.Duration(rule.For) {
+				d.fireAlert(ctx, rule, inst, now)
+			}
+		}
+
+		// Las series que desaparecieron (p. ej. el contenedor terminó) se resuelven
+		for id, inst := range m.active {
+			if inst.rule != rule.Name {
+				continue
+			}
+			if _, ok := samples[inst.key]; !ok {
+				d.resolveAlert(ctx, rule, inst, now)
+				delete(m.active, id)
+			}
+		}
+	}
+
+	// Las alertas de reglas que ya no existen tras una recarga se resuelven
+	for id, inst := range m.active {
+		if _, ok := rules[inst.rule]; !ok {
+			d.resolveAlert(ctx, &AlertRule{Name: inst.rule}, inst, now)
+			delete(m.active, id)
+		}
+	}
+}
+
+// alertSamples calcula las series de la regla; false si no hay datos en esta iteración.
+func (d *Daemon) alertSamples(ctx context.Context, rule *AlertRule, now time.Time, system *SystemInfo, containers []Container) (map[string]alertSample, bool) {
+	window := time.Duration(rule.Window)
+
+	switch rule.Metric {
+	case alertHostMemory:
+		if system == nil || system.Memory.TotalKB == 0 {
+			return nil, false
+		}
+		percent := float64(system.Memory.UsedKB) * 100 / float64(system.Memory.TotalKB)
+		return map[string]alertSample{"host": {labels: map[string]string{"host": system.System.Hostname}, value: percent}}, true
+
+	case alertContainerRSS, alertContainerCPU:
+		if containers == nil {
+			return nil, false
+		}
+		samples := make(map[string]alertSample)
+		for key, load := range aggregateLoad(containers) {
+			value := load.rssKB
+			if rule.Metric == alertContainerCPU {
+				value = load.cpu
+			}
+			labels := map[string]string{"container": load.container.Name}
+			if load.container.ContainerID != "" {
+				labels["container_id"] = load.container.ContainerID
+			}
+			samples[key] = alertSample{labels: labels, value: value}
+		}
+		return samples, true
+
+	case alertKillRate:
+		var kills int64
+		err := d.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM container_actions WHERE action = 'KILLED' AND timestamp >= ?`,
+			now.Add(-window).UTC().Format(sqliteTimeFormat)).Scan(&kills)
+		if err != nil {
+			d.recordError("Error calculando kill rate para alertas: %v", err)
+			return nil, false
+		}
+		return map[string]alertSample{"daemon": {labels: map[string]string{}, value: float64(kills)}}, true
+
+	case alertProcReadErrors:
+		count := 0
+		for _, at := range d.alerts.readFailures {
+			if now.Sub(at) <= window {
+				count++
+			}
+		}
+		return map[string]alertSample{"daemon": {labels: map[string]string{}, value: float64(count)}}, true
+	}
+	return nil, false
+}
+
+// pruneReadFailures conserva solo las fallas dentro de la ventana más larga de las reglas.
+func (m *alertManager) pruneReadFailures(now time.Time) {
+	var longest time.Duration
+	for _, rule := range m.rules.Rules {
+		if rule.Metric == alertProcReadErrors && time.Duration(rule.Window) > longest {
+			longest = time.Duration(rule.Window)
+		}
+	}
+
+	kept := m.readFailures[:0]
+	for _, at := range m.readFailures {
+		if now.Sub(at) <= longest {
+			kept = append(kept, at)
+		}
+	}
+	m.readFailures = kept
+}
+
+func alertSummary(rule *AlertRule, inst *alertInstance) string {
+	var parts []string
+	for k, v := range inst.labels {
+		parts = append(parts, k+"="+v)
+	}
+	sort.Strings(parts)
+	summary := fmt.Sprintf("%s: %s %.1f %s %g", rule.Name, rule.Metric, inst.value, rule.Op, rule.Threshold)
+	if len(parts) > 0 {
+		summary += " (" + strings.Join(parts, ", ") + ")"
+	}
+	return summary
+}
+
+func (d *Daemon) fireAlert(ctx context.Context, rule *AlertRule, inst *alertInstance, now time.Time) {
+	inst.firing = true
+	inst.startedAt = now
+	summary := alertSummary(rule, inst)
+	log.Printf("Alerta activa: %s", summary)
+
+	labels, _ := json.Marshal(inst.labels)
+	result, err := d.db.ExecContext(ctx, `INSERT INTO alerts
+		(rule, series, labels, severity, metric, value, threshold, started_at, summary)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rule.Name, inst.key, string(labels), rule.Severity, rule.Metric, inst.value, rule.Threshold,
+		now.UTC().Format(sqliteTimeFormat), summary)
+	if err != nil {
+		d.recordError("Error registrando alerta %s: %v", rule.Name, err)
+	} else {
+		inst.id, _ = result.LastInsertId()
+	}
+
+	d.notifyAlert(rule, inst, alertFiring, nil, summary)
+}
+
+func (d *Daemon) resolveAlert(ctx context.Context, rule *AlertRule, inst *alertInstance, now time.Time) {
+	// Una alerta pendiente que no llegó a disparar no se notifica
+	if !inst.firing {
+		return
+	}
+
+	summary := alertSummary(rule, inst)
+	log.Printf("Alerta resuelta: %s", summary)
+
+	if inst.id != 0 {
+		_, err := d.db.ExecContext(ctx, `UPDATE alerts SET resolved_at = ?, resolved_value = ? WHERE id = ?`,
+			now.UTC().Format(sqliteTimeFormat), inst.value, inst.id)
+		if err != nil {
+			d.recordError("Error resolviendo alerta %s: %v", rule.Name, err)
+		}
+	}
+
+	d.notifyAlert(rule, inst, alertResolved, &now, summary)
+}
+
+// notifyAlert encola el envío al webhook de la regla o al de la configuración.
+// Si la entrega no arrancó la alerta queda solo en la tabla alerts.
+func (d *Daemon) notifyAlert(rule *AlertRule, inst *alertInstance, status string, resolvedAt *time.Time, summary string) {
+	url := rule.Webhook
+	if url == "" {
+		url = d.config.AlertWebhook
+	}
+	if url == "" || !d.alerts.delivering {
+		return
+	}
+
+	n := alertNotification{
+		alertID: inst.id,
+		url:     url,
+		retries: d.config.AlertRetries,
+		timeout: d.config.AlertTimeout,
+		payload: alertPayload{
+			Status:     status,
+			Rule:       rule.Name,
+			Severity:   rule.Severity,
+			Metric:     rule.Metric,
+			Labels:     inst.labels,
+			Value:      inst.value,
+			Op:         rule.Op,
+			Threshold:  rule.Threshold,
+			StartedAt:  inst.startedAt.UTC(),
+			ResolvedAt: resolvedAt,
+			Summary:    summary,
+		},
+	}
+
+	select {
+	case d.alerts.queue <- n:
+	default:
+		d.recordError("Cola de notificaciones llena, se descarta %s de %s", status, rule.Name)
+	}
+}
+
+// deliverAlert envía la notificación con reintentos y backoff exponencial, y
+// guarda el resultado en alert_notifications.
+func (d *Daemon) deliverAlert(ctx context.Context, n alertNotification) {
+	body, err := json.Marshal(n.payload)
+	if err != nil {
+		log.Printf("Error serializando notificación de %s: %v", n.payload.Rule, err)
+		return
+	}
+
+	client := &http.Client{Timeout: n.timeout}
+	backoff := time.Second
+	attempts, code := 0, 0
+	for attempts <= n.retries {
+		attempts++
+		code, err = postAlert(ctx, client, n.url, body)
+		if err == nil {
+			break
+		}
+		if attempts > n.retries {
+			break
+		}
+
+		log.Printf("Error enviando alerta %s (intento %d): %v", n.payload.Rule, attempts, err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		backoff *= 2
+	}
+
+	var errText sql.NullString
+	if err != nil {
+		errText = sql.NullString{String: err.Error(), Valid: true}
+		log.Printf("Alerta %s (%s) no entregada tras %d intentos: %v", n.payload.Rule, n.payload.Status, attempts, err)
+	}
+
+	var alertID sql.NullInt64
+	if n.alertID != 0 {
+		alertID = sql.NullInt64{Int64: n.alertID, Valid: true}
+	}
+	_, dbErr := d.db.Exec(`INSERT INTO alert_notifications (alert_id, status, url, attempts, response_code, error, delivered)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, alertID, n.payload.Status, n.url, attempts, code, errText, err == nil)
+	if dbErr != nil {
+		log.Printf("Error registrando notificación de %s: %v", n.payload.Rule, dbErr)
+	}
+}
+
+func postAlert(ctx context.Context, client *http.Client, url string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("el webhook respondió %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// loadActiveAlerts recupera las alertas sin resolver como ya notificadas.
+func (d *Daemon) loadActiveAlerts(ctx context.Context) error {
+	rows, err := d.db.QueryContext(ctx, `SELECT id, rule, series, COALESCE(labels, '{}'), started_at, COALESCE(value, 0)
+		FROM alerts WHERE resolved_at IS NULL`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		inst := &alertInstance{firing: true}
+		var labels, startedAt string
+		if err := rows.Scan(&inst.id, &inst.rule, &inst.key, &labels, &startedAt, &inst.value); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(labels), &inst.labels); err != nil {
+			log.Printf("Etiquetas inválidas en la alerta %d: %v", inst.id, err)
+		}
+		inst.startedAt, _ = parseSQLiteTime(startedAt)
+		inst.pendingSince = inst.startedAt
+		d.alerts.active[inst.rule+"|"+inst.key] = inst
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(d.alerts.active) > 0 {
+		keys := make([]string, 0, len(d.alerts.active))
+		for key := range d.alerts.active {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		log.Printf("Alertas activas de la ejecución anterior: %s", strings.Join(keys, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// alertReceiver es un webhook local que responde con los códigos de statuses
+// en orden (200 cuando se acaban) y guarda los payloads recibidos.
+type alertReceiver struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	received []alertPayload
+	times    []time.Time
+}
+
+func newAlertReceiver(t *testing.T, statuses ...int) *alertReceiver {
+	r := &alertReceiver{statuses: statuses}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var payload alertPayload
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+			t.Errorf("payload inválido: %v", err)
+		}
+
+		r.mu.Lock()
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		r.received = append(r.received, payload)
+		r.times = append(r.times, time.Now())
+		r.mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *alertReceiver) payloads() []alertPayload {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]alertPayload(nil), r.received...)
+}
+
+// newAlertTestDaemon crea un daemon con base temporal, la regla dada y la
+// entrega de alertas iniciada contra url.
+func newAlertTestDaemon(t *testing.T, url string, rule AlertRule) *Daemon {
+	t.Helper()
+
+	d := newTestDaemon(t)
+	d.config.AlertWebhook = url
+	d.config.AlertRetries = 2
+	d.config.AlertTimeout = 2 * time.Second
+
+	d.alerts.rules = &AlertRules{Rules: []AlertRule{rule}}
+	if err := d.alerts.rules.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.startAlerts(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func hostSample(usedPercent int64) *SystemInfo {
+	return &SystemInfo{
+		System: SystemDetails{Hostname: "sopes1-vm"},
+		Memory: MemoryInfo{TotalKB: 1000, UsedKB: usedPercent * 10, FreeKB: 1000 - usedPercent*10},
+	}
+}
+
+// waitNotifications espera a que alert_notifications tenga n filas.
+func waitNotifications(t *testing.T, d *Daemon, n int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var count int
+		if err := d.db.QueryRow(`SELECT COUNT(*) FROM alert_notifications`).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("se esperaban %d notificaciones registradas, hay %d", n, count)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func countAlerts(t *testing.T, d *Daemon) (total, open int) {
+	t.Helper()
+	err := d.db.QueryRow(`SELECT COUNT(*), COUNT(*) - COUNT(resolved_at) FROM alerts`).Scan(&total, &open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return total, open
+}
+
+func TestAlertForDedupAndResolve(t *testing.T) {
+	receiver := newAlertReceiver(t)
+	d := newAlertTestDaemon(t, receiver.server.URL, AlertRule{
+		Name: "host-memory", Metric: alertHostMemory, Op: ">", Threshold: 50, For: jsonDuration(time.Minute),
+	})
+	ctx := context.Background()
+
+	// Sobre el umbral pero sin cumplir for: pendiente, sin registro ni envío
+	d.evaluateAlerts(ctx, hostSample(80), nil)
+	d.evaluateAlerts(ctx, hostSample(85), nil)
+	if total, _ := countAlerts(t, d); total != 0 {
+		t.Fatalf("la alerta se registró antes de cumplir for (%d filas)", total)
+	}
+	inst := d.alerts.active["host-memory|host"]
+	if inst == nil || inst.firing {
+		t.Fatalf("se esperaba la alerta pendiente: %+v", inst)
+	}
+
+	// Cumplido for se dispara una vez; las iteraciones siguientes no la repiten
+	inst.pendingSince = inst.pendingSince.Add(-time.Minute)
+	d.evaluateAlerts(ctx, hostSample(90), nil)
+	d.evaluateAlerts(ctx, hostSample(95), nil)
+	d.evaluateAlerts(ctx, hostSample(90), nil)
+	waitNotifications(t, d, 1)
+	if total, open := countAlerts(t, d); total != 1 || open != 1 {
+		t.Fatalf("alerts: %d filas, %d abiertas; se esperaba 1 abierta", total, open)
+	}
+
+	// Bajo el umbral se resuelve
+	d.evaluateAlerts(ctx, hostSample(40), nil)
+	waitNotifications(t, d, 2)
+	if total, open := countAlerts(t, d); total != 1 || open != 0 {
+		t.Fatalf("alerts: %d filas, %d abiertas; se esperaba 1 resuelta", total, open)
+	}
+	if len(d.alerts.active) != 0 {
+		t.Errorf("quedaron alertas activas: %v", d.alerts.active)
+	}
+
+	payloads := receiver.payloads()
+	if len(payloads) != 2 {
+		t.Fatalf("el webhook recibió %d notificaciones, se esperaban 2: %+v", len(payloads), payloads)
+	}
+	if payloads[0].Status != alertFiring || payloads[0].Value != 90 || payloads[0].Labels["host"] != "sopes1-vm" {
+		t.Errorf("notificación de disparo: %+v", payloads[0])
+	}
+	if payloads[1].Status != alertResolved || payloads[1].ResolvedAt == nil || payloads[1].Value != 40 {
+		t.Errorf("notificación de resolución: %+v", payloads[1])
+	}
+
+	rows, err := d.db.Query(`SELECT n.status, n.attempts, n.response_code, n.delivered, n.alert_id = a.id
+		FROM alert_notifications n JOIN alerts a ON a.rule = 'host-memory' ORDER BY n.id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var statuses []string
+	for rows.Next() {
+		var status string
+		var attempts, code int
+		var delivered, linked bool
+		if err := rows.Scan(&status, &attempts, &code, &delivered, &linked); err != nil {
+			t.Fatal(err)
+		}
+		if attempts != 1 || code != http.StatusOK || !delivered || !linked {
+			t.Errorf("notificación %s: attempts=%d code=%d delivered=%v enlazada=%v", status, attempts, code, delivered, linked)
+		}
+		statuses = append(statuses, status)
+	}
+	if len(statuses) != 2 || statuses[0] != alertFiring || statuses[1] != alertResolved {
+		t.Errorf("alert_notifications: %v", statuses)
+	}
+}
+
+func TestAlertDeliveryRetriesWithBackoff(t *testing.T) {
+	receiver := newAlertReceiver(t, http.StatusInternalServerError)
+	d := newAlertTestDaemon(t, receiver.server.URL, AlertRule{
+		Name: "host-memory", Metric: alertHostMemory, Threshold: 50,
+	})
+
+	d.evaluateAlerts(context.Background(), hostSample(80), nil)
+	waitNotifications(t, d, 1)
+
+	receiver.mu.Lock()
+	times := append([]time.Time(nil), receiver.times...)
+	receiver.mu.Unlock()
+	if len(times) != 2 {
+		t.Fatalf("se esperaban 2 intentos (500 y 200), hubo %d", len(times))
+	}
+	if gap := times[1].Sub(times[0]); gap < time.Second {
+		t.Errorf("el reintento llegó a los %v, antes del backoff de 1s", gap)
+	}
+
+	var attempts, code int
+	var delivered bool
+	var errText *string
+	err := d.db.QueryRow(`SELECT attempts, response_code, delivered, error FROM alert_notifications`).
+		Scan(&attempts, &code, &delivered, &errText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 || code != http.StatusOK || !delivered || errText != nil {
+		t.Errorf("alert_notifications: attempts=%d code=%d delivered=%v error=%v", attempts, code, delivered, errText)
+	}
+}
+
+func TestAlertsNotQueuedWithoutDelivery(t *testing.T) {
+	d := newTestDaemon(t)
+	d.config.AlertWebhook = "http://127.0.0.1:1/"
+	d.alerts.rules = &AlertRules{Rules: []AlertRule{{Name: "host-memory", Metric: alertHostMemory, Op: ">", Threshold: 50}}}
+
+	// Sin startAlerts nadie vacía la cola: la alerta se registra pero no se encola
+	d.evaluateAlerts(context.Background(), hostSample(80), nil)
+	if total, _ := countAlerts(t, d); total != 1 {
+		t.Errorf("alerts: %d filas, se esperaba 1", total)
+	}
+	if queued := len(d.alerts.queue); queued != 0 {
+		t.Errorf("%d notificaciones encoladas sin entrega", queued)
+	}
+}
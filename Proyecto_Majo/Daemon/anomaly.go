@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+)
+
+// Tipos de anomalía en la tabla anomalies.
+const (
+	anomalyLeak       = "leak"
+	anomalyRSSOutlier = "rss_outlier"
+	anomalyCPUOutlier = "cpu_outlier"
+)
+
+// ewma es una media y varianza con peso exponencial: cada muestra pesa alpha
+// y las anteriores decaen, así la varianza sigue una ventana móvil.
+type ewma struct {
+	mean     float64
+	variance float64
+	samples  int
+}
+
+// zscore devuelve cuántas desviaciones se aleja x de la media antes de incluirla.
+// La desviación tiene un piso (1 unidad o 1% de la media) para que una serie
+// constante no marque como outlier cualquier variación mínima.
+func (e *ewma) zscore(x float64) float64 {
+	std := math.Max(math.Sqrt(e.variance), math.Max(1, math.Abs(e.mean)/100))
+	return (x - e.mean) / std
+}
+
+func (e *ewma) add(x, alpha float64) {
+	if e.samples == 0 {
+		e.mean = x
+	} else {
+		diff := x - e.mean
+		incr := alpha * diff
+		e.mean += incr
+		e.variance = (1 - alpha) * (e.variance + diff*incr)
+	}
+	e.samples++
+}
+
+// containerSeries son las series de un contenedor para la detección.
+type containerSeries struct {
+	rss, cpu   ewma
+	rssWindow  []float64 // últimas leak-window muestras de RSS, para la pendiente
+	leaking    bool
+	flaggedRun int64 // última iteración con una anomalía
+}
+
+// anomalyDetector mantiene las series por lifecycleKey; solo lo usa el loop principal.
+type anomalyDetector struct {
+	series map[string]*containerSeries
+}
+
+func newAnomalyDetector() *anomalyDetector {
+	return &anomalyDetector{series: make(map[string]*containerSeries)}
+}
+
+// anomaly es una fila de la tabla anomalies.
+type anomaly struct {
+	container Container
+	kind      string
+	value     float64
+	baseline  float64
+	score     float64 // z-score, o pendiente en KB por iteración para leak
+	detail    string
+}
+
+// containerLoad agrega las muestras de un contenedor: la contabilidad cgroup
+// si está disponible y si no la suma de sus árboles de procesos.
+type containerLoad struct {
+	container Container
+	rssKB     float64
+	cpu       float64
+}
+
+func aggregateLoad(containers []Container) map[string]*containerLoad {
+	loads := make(map[string]*containerLoad)
+	for _, c := range containers {
+		key := lifecycleKey(c)
+		load, ok := loads[key]
+		if !ok {
+			load = &containerLoad{container: c}
+			loads[key] = load
+			if c.Cgroup != nil {
+				load.rssKB, load.cpu = float64(c.Cgroup.MemoryCurrentKB), c.Cgroup.CPUPercent
+				continue
+			}
+		} else if c.Cgroup != nil {
+			continue
+		}
+		tree := c.treeStats()
+		load.rssKB += float64(tree.RSSKB)
+		load.cpu += float64(tree.CPUPercent)
+	}
+	return loads
+}
+
+// detectAnomalies actualiza las series con la muestra y guarda las anomalías
+// nuevas. Una fuga se registra al empezar, no en cada iteración que sigue.
+func (d *Daemon) detectAnomalies(ctx context.Context, containers []Container) {
+	cfg := d.config
+	loads := aggregateLoad(containers)
+
+	keys := make([]string, 0, len(loads))
+	for key := range loads {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var found []anomaly
+	for _, key := range keys {
+		load := loads[key]
+		s, ok := d.anomalies.series[key]
+		if !ok {
+			s = &containerSeries{}
+			d.anomalies.series[key] = s
+		}
+
+		// Los outliers se evalúan contra la historia anterior a la muestra
+		if s.rss.samples >= cfg.LeakWindow {
+			if z := s.rss.zscore(load.rssKB); math.Abs(z) >= cfg.AnomalyZScore {
+				found = append(found, anomaly{load.container, anomalyRSSOutlier, load.rssKB, s.rss.mean, z,
+					fmt.Sprintf("RSS %.0f KB, media %.0f KB", load.rssKB, s.rss.mean)})
+			}
+			if z := s.cpu.zscore(load.cpu); math.Abs(z) >= cfg.AnomalyZScore {
+				found = append(found, anomaly{load.container, anomalyCPUOutlier, load.cpu, s.cpu.mean, z,
+					fmt.Sprintf("CPU %.1f%%, media %.1f%%", load.cpu, s.cpu.mean)})
+			}
+		}
+		s.rss.add(load.rssKB, cfg.AnomalyAlpha)
+		s.cpu.add(load.cpu, cfg.AnomalyAlpha)
+
+		s.rssWindow = append(s.rssWindow, load.rssKB)
+		if len(s.rssWindow) > cfg.LeakWindow {
+			s.rssWindow = s.rssWindow[len(s.rssWindow)-cfg.LeakWindow:]
+		}
+
+		leaking := false
+		if len(s.rssWindow) == cfg.LeakWindow {
+			slope, rising := leakSlope(s.rssWindow)
+			leaking = slope >= float64(cfg.LeakMinSlopeKB) && rising >= 0.7
+			if leaking && !s.leaking {
+				first := s.rssWindow[0]
+				found = append(found, anomaly{load.container, anomalyLeak, load.rssKB, first, slope,
+					fmt.Sprintf("RSS creció de %.0f a %.0f KB en %d iteraciones (%.0f KB/iteración)",
+						first, load.rssKB, cfg.LeakWindow, slope)})
+			}
+		}
+		if leaking {
+			s.flaggedRun = d.iteration.ID
+		}
+		s.leaking = leaking
+	}
+
+	// Las series de contenedores que ya no están se descartan
+	for key := range d.anomalies.series {
+		if _, ok := loads[key]; !ok {
+			delete(d.anomalies.series, key)
+		}
+	}
+
+	if len(found) == 0 {
+		return
+	}
+	for _, a := range found {
+		d.anomalies.series[lifecycleKey(a.container)].flaggedRun = d.iteration.ID
+		log.Printf("Anomalía %s en %s: %s", a.kind, a.container.Name, a.detail)
+	}
+	if err := d.storeAnomalies(ctx, found); err != nil {
+		d.recordError("Error guardando anomalías: %v", err)
+	}
+}
+
+// leakSlope ajusta una recta por mínimos cuadrados y devuelve la pendiente
+// (KB por iteración) y la fracción de pasos en los que el valor no bajó.
+func leakSlope(values []float64) (slope, rising float64) {
+	n := float64(len(values))
+	var sumX, sumY, sumXY, sumXX float64
+	up := 0
+	for i, y := range values {
+		x := float64(i)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+		if i > 0 && y >= values[i-1] {
+			up++
+		}
+	}
+
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, 0
+	}
+	return (n*sumXY - sumX*sumY) / denominator, float64(up) / (n - 1)
+}
+
+// isAnomalous indica si el contenedor tuvo una anomalía dentro de las últimas
+// leak-window iteraciones o sigue con una fuga abierta.
+func (d *Daemon) isAnomalous(c Container) bool {
+	s, ok := d.anomalies.series[lifecycleKey(c)]
+	if !ok || s.flaggedRun == 0 {
+		return false
+	}
+	return s.leaking || d.iteration.ID-s.flaggedRun < int64(d.config.LeakWindow)
+}
+
+// preferAnomalous reordena las víctimas de la clase para que los contenedores
+// marcados vayan primero, conservando el orden de la política entre ellos.
+func (d *Daemon) preferAnomalous(class ClassResult) ClassResult {
+	if !class.Policy.PreferAnomalous {
+		return class
+	}
+
+	ordered := make([]Container, len(class.Containers))
+	copy(ordered, class.Containers)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return d.isAnomalous(ordered[i]) && !d.isAnomalous(ordered[j])
+	})
+	return ClassResult{Policy: class.Policy, Containers: ordered}
+}
+
+func (d *Daemon) storeAnomalies(ctx context.Context, found []anomaly) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO anomalies
+		(iteration_id, container_key, container_id, name, pid, kind, value, baseline, score, detail)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var iterationID sql.NullInt64
+	if d.iteration != nil {
+		iterationID = sql.NullInt64{Int64: d.iteration.ID, Valid: true}
+	}
+
+	for _, a := range found {
+		_, err := stmt.Exec(iterationID, lifecycleKey(a.container), nullString(a.container.ContainerID),
+			a.container.Name, a.container.PID, a.kind, a.value, a.baseline, a.score, a.detail)
+		if err != nil {
+			return fmt.Errorf("contenedor %s: %w", a.container.Name, err)
+		}
+	}
+	return tx.Commit()
+}
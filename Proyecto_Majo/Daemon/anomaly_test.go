@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+// feedAnomalies pasa una muestra por iteración del contenedor web con el RSS
+// de cada valor y devuelve cuántas anomalías de cada tipo quedaron guardadas.
+func feedAnomalies(t *testing.T, d *Daemon, rss []float64) map[string]int {
+	t.Helper()
+	for _, value := range rss {
+		d.iteration = &IterationRecord{ID: d.iteration.ID + 1}
+		d.detectAnomalies(context.Background(), []Container{{PID: 10, Name: "web", RSSKB: int64(value), CPUPercent: 5}})
+	}
+
+	rows, err := d.db.Query(`SELECT kind, COUNT(*) FROM anomalies GROUP BY kind`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	counts := make(map[string]int)
+	for rows.Next() {
+		var kind string
+		var n int
+		if err := rows.Scan(&kind, &n); err != nil {
+			t.Fatal(err)
+		}
+		counts[kind] = n
+	}
+	return counts
+}
+
+func newAnomalyTestDaemon(t *testing.T) *Daemon {
+	t.Helper()
+	d := newTestDaemon(t)
+	d.config.AnomalyAlpha = 0.3
+	d.config.AnomalyZScore = 3
+	d.config.LeakWindow = 10
+	d.config.LeakMinSlopeKB = 256
+	d.iteration = &IterationRecord{}
+	return d
+}
+
+// repeat devuelve n valores que alternan entre a y b.
+func repeat(n int, a, b float64) []float64 {
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = a
+		if i%2 == 1 {
+			values[i] = b
+		}
+	}
+	return values
+}
+
+func TestEWMAZScore(t *testing.T) {
+	var e ewma
+	for _, x := range repeat(30, 10000, 10100) {
+		e.add(x, 0.3)
+	}
+	if math.Abs(e.mean-10050) > 30 {
+		t.Errorf("media %.1f, se esperaba cerca de 10050", e.mean)
+	}
+	if z := e.zscore(10100); math.Abs(z) >= 3 {
+		t.Errorf("z-score %.2f para un valor dentro del ruido", z)
+	}
+	if z := e.zscore(20000); z < 3 {
+		t.Errorf("z-score %.2f para un salto al doble", z)
+	}
+	if z := e.zscore(0); z > -3 {
+		t.Errorf("z-score %.2f para una caída a cero", z)
+	}
+
+	// Una serie constante no tiene varianza: el piso del 1% evita outliers por variaciones mínimas
+	var constant ewma
+	for i := 0; i < 30; i++ {
+		constant.add(1000, 0.3)
+	}
+	if z := constant.zscore(1005); z != 0.5 {
+		t.Errorf("z-score %.2f sobre una serie constante, se esperaba 0.5 (piso de 10 KB)", z)
+	}
+}
+
+func TestLeakSlope(t *testing.T) {
+	cases := []struct {
+		name          string
+		values        []float64
+		slope, rising float64
+	}{
+		{"constante", []float64{5, 5, 5, 5}, 0, 1},
+		{"lineal", []float64{0, 300, 600, 900, 1200}, 300, 1},
+		{"decreciente", []float64{900, 600, 300, 0}, -300, 0},
+		{"sierra", []float64{0, 2000, 1000, 3000, 2000, 4000}, 4400.0 / 7, 0.6},
+		{"un solo valor", []float64{7}, 0, 0},
+	}
+	for _, tc := range cases {
+		slope, rising := leakSlope(tc.values)
+		if math.Abs(slope-tc.slope) > 1e-9 || math.Abs(rising-tc.rising) > 1e-9 {
+			t.Errorf("%s: pendiente %v y subida %v, se esperaban %v y %v", tc.name, slope, rising, tc.slope, tc.rising)
+		}
+	}
+}
+
+func TestDetectAnomaliesOutliers(t *testing.T) {
+	d := newAnomalyTestDaemon(t)
+
+	// Un salto antes de leak-window muestras no tiene historia contra la que compararse
+	early := append(repeat(4, 10000, 10100), 50000, 10000)
+	if counts := feedAnomalies(t, d, early); counts[anomalyRSSOutlier] != 0 {
+		t.Fatalf("outlier con %d muestras: %v", len(early), counts)
+	}
+
+	// Ruido estable: nada
+	if counts := feedAnomalies(t, d, repeat(30, 10000, 10100)); len(counts) != 0 {
+		t.Fatalf("anomalías en una serie estable: %v", counts)
+	}
+
+	// Un salto al doble sí
+	counts := feedAnomalies(t, d, []float64{20000})
+	if counts[anomalyRSSOutlier] != 1 {
+		t.Errorf("outliers de RSS: %d, se esperaba 1 (%v)", counts[anomalyRSSOutlier], counts)
+	}
+	if counts[anomalyCPUOutlier] != 0 || counts[anomalyLeak] != 0 {
+		t.Errorf("anomalías inesperadas: %v", counts)
+	}
+	if !d.isAnomalous(Container{PID: 10, Name: "web"}) {
+		t.Error("el contenedor con el outlier no quedó marcado")
+	}
+}
+
+func TestDetectAnomaliesLeak(t *testing.T) {
+	cases := []struct {
+		name  string
+		rss   []float64
+		leaks int
+	}{
+		// 300 KB por iteración durante más de leak-window iteraciones: una sola fuga
+		{"fuga sostenida", []float64{10000, 10300, 10600, 10900, 11200, 11500, 11800, 12100, 12400, 12700, 13000, 13300, 13600}, 1},
+		// Crece, pero por debajo de leak-min-slope-kb
+		{"crecimiento lento", []float64{10000, 10100, 10200, 10300, 10400, 10500, 10600, 10700, 10800, 10900, 11000}, 0},
+		// Pendiente alta pero baja la mitad de las veces: no es una fuga
+		{"sierra", []float64{0, 2000, 1000, 3000, 2000, 4000, 3000, 5000, 4000, 6000}, 0},
+		// Sube lo suficiente, pero menos muestras que leak-window
+		{"ventana incompleta", []float64{10000, 10300, 10600, 10900, 11200, 11500, 11800, 12100, 12400}, 0},
+	}
+	for _, tc := range cases {
+		d := newAnomalyTestDaemon(t)
+		if counts := feedAnomalies(t, d, tc.rss); counts[anomalyLeak] != tc.leaks {
+			t.Errorf("%s: %d fugas, se esperaban %d", tc.name, counts[anomalyLeak], tc.leaks)
+		}
+	}
+
+	// Una fuga que se detiene y vuelve se registra otra vez
+	d := newAnomalyTestDaemon(t)
+	leak := []float64{10000, 10300, 10600, 10900, 11200, 11500, 11800, 12100, 12400, 12700}
+	flat := repeat(10, 12700, 12700)
+	again := []float64{13000, 13300, 13600, 13900, 14200, 14500, 14800, 15100, 15400, 15700}
+	var rss []float64
+	rss = append(append(append(rss, leak...), flat...), again...)
+	if counts := feedAnomalies(t, d, rss); counts[anomalyLeak] != 2 {
+		t.Errorf("%d fugas tras detenerse y volver, se esperaban 2", counts[anomalyLeak])
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultListLimit = 50
+
+// classView es la representación JSON de una clase.
+type classView struct {
+	Name       string      `json:"name"`
+	Target     int         `json:"target"`
+	Min        int         `json:"min"`
+	Max        *int        `json:"max"`
+	Containers []Container `json:"containers"`
+}
+
+// ContainerAction es una fila de container_actions.
+type ContainerAction struct {
+	ID            int64  `json:"id"`
+	Timestamp     string `json:"timestamp"`
+	Action        string `json:"action"`
+	ContainerPID  int    `json:"container_pid"`
+	ContainerName string `json:"container_name"`
+	ContainerID   string `json:"container_id,omitempty"`
+	Image         string `json:"image,omitempty"`
+	Reason        string `json:"reason"`
+}
+
+// startAPI expone la API de control y estado en config.APIAddr, que
+// validate restringe a loopback porque sus rutas no piden credenciales.
+func (d *Daemon) startAPI(ctx context.Context) error {
+	if d.config.APIAddr == "" {
+		log.Println("API HTTP deshabilitada")
+		return nil
+	}
+	return d.serveHTTP(ctx, "API HTTP", d.config.APIAddr, d.apiHandler())
+}
+
+// startGrafanaAPI expone en config.GrafanaAddr solo las rutas de lectura que
+// consumen Grafana y Prometheus, para que puedan escuchar fuera de loopback
+// sin exponer la API de control.
+func (d *Daemon) startGrafanaAPI(ctx context.Context) error {
+	if d.config.GrafanaAddr == "" {
+		log.Println("Datasource de Grafana y /metrics deshabilitados")
+		return nil
+	}
+	return d.serveHTTP(ctx, "datasource de Grafana", d.config.GrafanaAddr, d.grafanaAPIHandler())
+}
+
+// serveHTTP escucha en addr y registra el cierre del servidor al apagar.
+func (d *Daemon) serveHTTP(ctx context.Context, name, addr string, handler http.Handler) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Error en %s: %v", name, err)
+		}
+	}()
+
+	d.onShutdown(name, server.Shutdown)
+
+	log.Printf("%s escuchando en %s", name, listener.Addr())
+	return nil
+}
+
+func (d *Daemon) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/status", d.handleStatus)
+	mux.HandleFunc("/api/classification", d.handleClassification)
+	mux.HandleFunc("/api/iterations", d.handleIterations)
+	mux.HandleFunc("/api/actions", d.handleActions)
+	mux.HandleFunc("/api/iterate", d.handleIterate)
+	mux.HandleFunc("/api/pause", d.handlePause)
+	mux.HandleFunc("/api/resume", d.handleResume)
+	mux.HandleFunc("/api/protect", d.handleProtect)
+	mux.HandleFunc("/api/unprotect", d.handleUnprotect)
+
+	return mux
+}
+
+// grafanaAPIHandler sirve el datasource SimpleJSON y las métricas de Prometheus.
+func (d *Daemon) grafanaAPIHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("/metrics", d.metrics)
+	mux.Handle("/grafana/", http.StripPrefix("/grafana", d.grafanaHandler()))
+
+	return mux
+}
+
+func (d *Daemon) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	var last *IterationRecord
+	if recent := d.state.recentIterations(1); len(recent) > 0 {
+		last = &recent[0]
+	}
+
+	dryRun, loopInterval := d.state.settings()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"paused":         d.state.isPaused(),
+		"dry_run":        dryRun,
+		"loop_interval":  loopInterval.String(),
+		"protected":      d.state.protectedList(),
+		"last_iteration": last,
+	})
+}
+
+func (d *Daemon) handleClassification(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	classification, at := d.state.lastClassification()
+	if classification == nil {
+		writeError(w, http.StatusServiceUnavailable, "todavía no hay una clasificación")
+		return
+	}
+
+	classes := make([]classView, 0, len(classification.Classes))
+	for _, class := range classification.Classes {
+		containers := class.Containers
+		if containers == nil {
+			containers = []Container{}
+		}
+		classes = append(classes, classView{
+			Name:       class.Policy.Name,
+			Target:     class.Policy.Target,
+			Min:        class.Policy.Min,
+			Max:        class.Policy.Max,
+			Containers: containers,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"timestamp":    at,
+		"classes":      classes,
+		"unclassified": classification.Unclassified,
+	})
+}
+
+func (d *Daemon) handleIterations(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	limit, ok := queryLimit(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, d.state.recentIterations(limit))
+}
+
+func (d *Daemon) handleActions(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	limit, ok := queryLimit(w, r)
+	if !ok {
+		return
+	}
+
+	filter := actionFilter{limit: limit}
+	if action := r.URL.Query().Get("action"); action != "" {
+		filter.actions = []string{action}
+	}
+	actions, err := d.queryActions(r.Context(), filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, actions)
+}
+
+func (d *Daemon) handleIterate(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	queued := d.state.requestIteration()
+	writeJSON(w, http.StatusAccepted, map[string]bool{"queued": queued})
+}
+
+func (d *Daemon) handlePause(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	d.state.setPaused(true)
+	log.Println("Enforcement pausado desde la API")
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": true})
+}
+
+func (d *Daemon) handleResume(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	d.state.setPaused(false)
+	log.Println("Enforcement reanudado desde la API")
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": false})
+}
+
+// protectRequest es el cuerpo de /api/protect y /api/unprotect.
+type protectRequest struct {
+	Container string `json:"container"`
+}
+
+func (d *Daemon) handleProtect(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req protectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "cuerpo inválido: "+err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	key, err := d.protectContainer(ctx, req.Container)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"protected": key})
+}
+
+func (d *Daemon) handleUnprotect(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req protectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "cuerpo inválido: "+err.Error())
+		return
+	}
+
+	if !d.state.unprotect(req.Container) {
+		writeError(w, http.StatusNotFound, "el contenedor no está protegido")
+		return
+	}
+	log.Printf("Protección retirada: %s", req.Container)
+	writeJSON(w, http.StatusOK, map[string]string{"unprotected": req.Container})
+}
+
+// actionFilter restringe las acciones que devuelve queryActions; los campos
+// vacíos no filtran.
+type actionFilter struct {
+	actions   []string
+	container string // nombre o prefijo del ID
+	since     time.Time
+	limit     int
+}
+
+// queryActions lee las últimas acciones que cumplen el filtro.
+func (d *Daemon) queryActions(ctx context.Context, filter actionFilter) ([]ContainerAction, error) {
+	query := `SELECT id, timestamp, action, COALESCE(container_pid, 0), COALESCE(container_name, ''),
+			COALESCE(container_id, ''), COALESCE(image, ''), COALESCE(reason, '')
+		FROM container_actions WHERE 1 = 1`
+	args := []interface{}{}
+	if len(filter.actions) > 0 {
+		query += ` AND action IN (?` + strings.Repeat(`, ?`, len(filter.actions)-1) + `)`
+		for _, action := range filter.actions {
+			args = append(args, action)
+		}
+	}
+	if filter.container != "" {
+		query += ` AND (container_name = ? OR container_id LIKE ? || '%')`
+		args = append(args, filter.container, filter.container)
+	}
+	if !filter.since.IsZero() {
+		query += ` AND timestamp >= ?`
+		args = append(args, filter.since.UTC().Format(sqliteTimeFormat))
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, filter.limit)
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []ContainerAction{}
+	for rows.Next() {
+		var a ContainerAction
+		err := rows.Scan(&a.ID, &a.Timestamp, &a.Action, &a.ContainerPID, &a.ContainerName, &a.ContainerID, &a.Image, &a.Reason)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, "método no permitido")
+	return false
+}
+
+func queryLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	raw := r.URL.Query().Get("limit")
+	if raw == "" {
+		return defaultListLimit, true
+	}
+
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit <= 0 {
+		writeError(w, http.StatusBadRequest, "limit debe ser un entero positivo")
+		return 0, false
+	}
+	return limit, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Error escribiendo respuesta HTTP: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
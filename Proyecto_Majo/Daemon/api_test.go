@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestControlAndGrafanaRoutesAreSeparate(t *testing.T) {
+	d := &Daemon{config: defaultConfig(), state: newRuntimeState(), metrics: newDaemonMetrics()}
+	api, grafana := d.apiHandler(), d.grafanaAPIHandler()
+
+	serve := func(handler http.Handler, method, path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec.Code
+	}
+
+	// El listener expuesto no tiene ninguna ruta de control
+	for _, path := range []string{"/api/pause", "/api/resume", "/api/protect", "/api/unprotect", "/api/iterate", "/api/status"} {
+		if code := serve(grafana, http.MethodPost, path); code != http.StatusNotFound {
+			t.Errorf("grafana-addr responde %d en %s", code, path)
+		}
+	}
+	if code := serve(grafana, http.MethodGet, "/grafana/"); code != http.StatusOK {
+		t.Errorf("grafana-addr: /grafana/ respondió %d", code)
+	}
+	if code := serve(grafana, http.MethodGet, "/metrics"); code != http.StatusOK {
+		t.Errorf("grafana-addr: /metrics respondió %d", code)
+	}
+
+	if code := serve(api, http.MethodGet, "/api/status"); code != http.StatusOK {
+		t.Errorf("api-addr: /api/status respondió %d", code)
+	}
+	if code := serve(api, http.MethodGet, "/grafana/"); code != http.StatusNotFound {
+		t.Errorf("api-addr sigue sirviendo /grafana/ (%d)", code)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrNotInContainer indica que el proceso no pertenece a ningún contenedor.
+var ErrNotInContainer = errors.New("el proceso no pertenece a un contenedor")
+
+// containerIDPattern reconoce un segmento de ruta de cgroup que identifica un contenedor.
+// Cubre las distribuciones de docker y containerd:
+//
+//	/docker/<id>                                  (v1 o v2 con driver cgroupfs)
+//	/system.slice/docker-<id>.scope               (driver systemd)
+//	/kubepods/.../cri-containerd-<id>.scope       (containerd con systemd)
+//	/kubepods/burstable/pod<uid>/<id>             (containerd con cgroupfs)
+//	/default/<id>                                 (namespace de containerd/nerdctl)
+var containerIDPattern = regexp.MustCompile(`^(?:[a-z-]+-)?([0-9a-f]{64})(?:\.scope)?$`)
+
+// cgroupEntry es el resultado de resolver un PID.
+type cgroupEntry struct {
+	containerID string
+	path        string
+	err         error
+}
+
+// CgroupResolver mapea PIDs a contenedores leyendo /proc/<pid>/cgroup.
+// Los resultados se guardan en caché hasta la siguiente llamada a Reset.
+type CgroupResolver struct {
+	procRoot string
+
+	mu    sync.Mutex
+	cache map[int]cgroupEntry
+}
+
+// NewCgroupResolver crea un resolvedor sobre procRoot (normalmente /proc).
+func NewCgroupResolver(procRoot string) *CgroupResolver {
+	return &CgroupResolver{
+		procRoot: procRoot,
+		cache:    make(map[int]cgroupEntry),
+	}
+}
+
+// Reset descarta la caché; se llama al inicio de cada iteración.
+func (r *CgroupResolver) Reset() {
+	r.mu.Lock()
+	r.cache = make(map[int]cgroupEntry)
+	r.mu.Unlock()
+}
+
+// ContainerID devuelve el ID del contenedor al que pertenece el PID.
+// Funciona también para procesos hijos, ya que heredan el cgroup del contenedor.
+func (r *CgroupResolver) ContainerID(pid int) (string, error) {
+	entry := r.lookup(pid)
+	return entry.containerID, entry.err
+}
+
+// CgroupPath devuelve la ruta del cgroup del PID relativa a la raíz de la jerarquía.
+func (r *CgroupResolver) CgroupPath(pid int) (string, error) {
+	entry := r.lookup(pid)
+	return entry.path, entry.err
+}
+
+func (r *CgroupResolver) lookup(pid int) cgroupEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if entry, ok := r.cache[pid]; ok {
+		return entry
+	}
+
+	entry := r.resolve(pid)
+	r.cache[pid] = entry
+	return entry
+}
+
+func (r *CgroupResolver) resolve(pid int) cgroupEntry {
+	file := filepath.Join(r.procRoot, strconv.Itoa(pid), "cgroup")
+
+	f, err := os.Open(file)
+	if err != nil {
+		return cgroupEntry{err: fmt.Errorf("error leyendo %s: %w", file, err)}
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return cgroupEntry{err: fmt.Errorf("error leyendo %s: %w", file, err)}
+	}
+
+	id, path, ok := parseCgroupContainerID(lines)
+	if !ok {
+		return cgroupEntry{path: path, err: fmt.Errorf("PID %d: %w", pid, ErrNotInContainer)}
+	}
+	return cgroupEntry{containerID: id, path: path}
+}
+
+// parseCgroupContainerID extrae el ID del contenedor de las líneas de /proc/<pid>/cgroup.
+// Se prefiere la jerarquía unificada (v2, "0::<ruta>"); en v1 se usa la primera
+// controladora cuya ruta contenga un ID. Con contenedores anidados gana el más interno.
+func parseCgroupContainerID(lines []string) (id, path string, ok bool) {
+	var unified, legacy []string
+
+	for _, line := range lines {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		if parts[0] == "0" && parts[1] == "" {
+			unified = append(unified, parts[2])
+		} else {
+			legacy = append(legacy, parts[2])
+		}
+	}
+
+	for _, cgroupPath := range append(unified, legacy...) {
+		if id, ok := lastContainerID(cgroupPath); ok {
+			return id, cgroupPath, true
+		}
+	}
+
+	if len(unified) > 0 {
+		return "", unified[0], false
+	}
+	if len(legacy) > 0 {
+		return "", legacy[0], false
+	}
+	return "", "", false
+}
+
+func lastContainerID(cgroupPath string) (string, bool) {
+	segments := strings.Split(cgroupPath, "/")
+	for i := len(segments) - 1; i >= 0; i-- {
+		if match := containerIDPattern.FindStringSubmatch(segments[i]); match != nil {
+			return match[1], true
+		}
+	}
+	return "", false
+}
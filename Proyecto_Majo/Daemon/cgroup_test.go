@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var (
+	testOuterID = strings.Repeat("a", 64)
+	testInnerID = strings.Repeat("b", 64)
+)
+
+func TestContainerIDPattern(t *testing.T) {
+	cases := map[string]string{
+		testOuterID:                                testOuterID,
+		"docker-" + testOuterID + ".scope":         testOuterID,
+		"cri-containerd-" + testOuterID + ".scope": testOuterID,
+		"libpod-" + testOuterID + ".scope":         testOuterID,
+		testOuterID + ".scope":                     testOuterID,
+
+		// Longitud, alfabeto o forma distintos no son IDs
+		"docker-" + testOuterID[:63] + ".scope":  "",
+		"docker-" + testOuterID + "0.scope":      "",
+		"docker-" + strings.ToUpper(testOuterID): "",
+		"docker-" + strings.Repeat("g", 64):      "",
+		"docker-" + testOuterID + ".mount":       "",
+		"docker_" + testOuterID + ".scope":       "",
+		"system.slice":                           "",
+		"docker.service":                         "",
+		"user@1000.service":                      "",
+		"":                                       "",
+	}
+	for segment, want := range cases {
+		got := ""
+		if match := containerIDPattern.FindStringSubmatch(segment); match != nil {
+			got = match[1]
+		}
+		if got != want {
+			t.Errorf("containerIDPattern(%q) = %q, se esperaba %q", segment, got, want)
+		}
+	}
+}
+
+func TestCgroupResolver(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		id      string
+		path    string
+	}{
+		{
+			name:    "v2 con driver systemd",
+			content: "0::/system.slice/docker-" + testOuterID + ".scope\n",
+			id:      testOuterID,
+			path:    "/system.slice/docker-" + testOuterID + ".scope",
+		},
+		{
+			name:    "v2 con driver cgroupfs",
+			content: "0::/docker/" + testOuterID + "\n",
+			id:      testOuterID,
+			path:    "/docker/" + testOuterID,
+		},
+		{
+			name: "v1",
+			content: "12:pids:/docker/" + testOuterID + "\n" +
+				"11:memory:/docker/" + testOuterID + "\n" +
+				"1:name=systemd:/docker/" + testOuterID + "\n",
+			id:   testOuterID,
+			path: "/docker/" + testOuterID,
+		},
+		{
+			name: "v1 con controladoras fuera del contenedor",
+			content: "12:pids:/\n" +
+				"11:memory:/docker/" + testOuterID + "\n",
+			id:   testOuterID,
+			path: "/docker/" + testOuterID,
+		},
+		{
+			name: "híbrido: gana la jerarquía unificada",
+			content: "11:memory:/docker/" + testOuterID + "\n" +
+				"0::/system.slice/docker-" + testInnerID + ".scope\n",
+			id:   testInnerID,
+			path: "/system.slice/docker-" + testInnerID + ".scope",
+		},
+		{
+			name:    "anidado: gana el más interno",
+			content: "0::/docker/" + testOuterID + "/docker/" + testInnerID + "\n",
+			id:      testInnerID,
+			path:    "/docker/" + testOuterID + "/docker/" + testInnerID,
+		},
+		{
+			name:    "anidado con subgrupo propio",
+			content: "0::/system.slice/docker-" + testOuterID + ".scope/init.scope\n",
+			id:      testOuterID,
+			path:    "/system.slice/docker-" + testOuterID + ".scope/init.scope",
+		},
+		{
+			name:    "líneas malformadas",
+			content: "basura\n\n0::/docker/" + testOuterID + "\n",
+			id:      testOuterID,
+			path:    "/docker/" + testOuterID,
+		},
+		{
+			name:    "proceso del host",
+			content: "0::/user.slice/user-1000.slice/session-2.scope\n",
+			path:    "/user.slice/user-1000.slice/session-2.scope",
+		},
+		{
+			name:    "servicio de docker",
+			content: "0::/system.slice/docker.service\n",
+			path:    "/system.slice/docker.service",
+		},
+		{
+			name:    "v1 sin contenedor",
+			content: "12:pids:/user.slice\n1:name=systemd:/init.scope\n",
+			path:    "/user.slice",
+		},
+	}
+
+	procRoot := t.TempDir()
+	for i, tc := range cases {
+		dir := filepath.Join(procRoot, strconv.Itoa(100+i))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "cgroup"), []byte(tc.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resolver := NewCgroupResolver(procRoot)
+	for i, tc := range cases {
+		pid := 100 + i
+		id, err := resolver.ContainerID(pid)
+		if tc.id == "" {
+			if !errors.Is(err, ErrNotInContainer) {
+				t.Errorf("%s: error %v, se esperaba ErrNotInContainer", tc.name, err)
+			}
+		} else if err != nil || id != tc.id {
+			t.Errorf("%s: ID %q (%v), se esperaba %q", tc.name, id, err, tc.id)
+		}
+
+		// La ruta se devuelve también fuera de un contenedor
+		if path, _ := resolver.CgroupPath(pid); path != tc.path {
+			t.Errorf("%s: ruta %q, se esperaba %q", tc.name, path, tc.path)
+		}
+	}
+
+	// Un PID sin /proc/<pid>/cgroup es un error de lectura, no ErrNotInContainer
+	if _, err := resolver.ContainerID(99999); err == nil || errors.Is(err, ErrNotInContainer) {
+		t.Errorf("PID inexistente: error %v", err)
+	}
+}
+
+func TestCgroupResolverCachesUntilReset(t *testing.T) {
+	procRoot := t.TempDir()
+	file := filepath.Join(procRoot, "42", "cgroup")
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(id string) {
+		t.Helper()
+		if err := os.WriteFile(file, []byte("0::/docker/"+id+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resolver := NewCgroupResolver(procRoot)
+	write(testOuterID)
+	if id, _ := resolver.ContainerID(42); id != testOuterID {
+		t.Fatalf("ID %q, se esperaba %q", id, testOuterID)
+	}
+
+	// El PID se reutilizó en otro contenedor: hasta Reset se usa la caché
+	write(testInnerID)
+	if id, _ := resolver.ContainerID(42); id != testOuterID {
+		t.Errorf("ID %q antes de Reset, se esperaba el cacheado %q", id, testOuterID)
+	}
+	resolver.Reset()
+	if id, _ := resolver.ContainerID(42); id != testInnerID {
+		t.Errorf("ID %q tras Reset, se esperaba %q", id, testInnerID)
+	}
+}
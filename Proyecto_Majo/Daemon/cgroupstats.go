@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CgroupStats es la contabilidad cgroup v2 del contenedor completo, no solo
+// del PID que reporta el módulo.
+type CgroupStats struct {
+	MemoryCurrentKB int64   `json:"memory_current_kb"`
+	MemoryAnonKB    int64   `json:"memory_anon_kb"`
+	MemoryFileKB    int64   `json:"memory_file_kb"`
+	CPUUsageUsec    int64   `json:"cpu_usage_usec"`
+	CPUPercent      float64 `json:"cpu_percent"` // de una CPU, entre esta iteración y la anterior
+	IOReadBytes     int64   `json:"io_read_bytes"`
+	IOWriteBytes    int64   `json:"io_write_bytes"`
+	Pids            int64   `json:"pids"`
+}
+
+// cgroupStats devuelve la contabilidad del contenedor, o ceros si no se pudo leer.
+func (c Container) cgroupStats() CgroupStats {
+	if c.Cgroup == nil {
+		return CgroupStats{}
+	}
+	return *c.Cgroup
+}
+
+// cgroupCPUSample es el último usage_usec leído de un cgroup.
+type cgroupCPUSample struct {
+	usageUsec int64
+	at        time.Time
+}
+
+// annotateCgroupStats completa Cgroup en cada contenedor cuyo cgroup v2 es
+// legible. Los procesos del mismo contenedor comparten la misma lectura.
+func (d *Daemon) annotateCgroupStats(containers []Container) {
+	now := time.Now()
+	byDir := make(map[string]*CgroupStats)
+	seen := make(map[string]cgroupCPUSample)
+
+	for i := range containers {
+		if containers[i].ContainerID == "" {
+			continue
+		}
+		dir, ok := d.cgroupDir(containers[i].PID)
+		if !ok {
+			continue
+		}
+
+		stats, done := byDir[dir]
+		if !done {
+			var err error
+			stats, err = readCgroupStats(dir)
+			if err != nil {
+				d.recordError("Error leyendo cgroup de %s: %v", containers[i].Name, err)
+			} else {
+				// Sin muestra anterior (o con el contador reiniciado) la CPU queda en cero
+				if prev, ok := d.cgroupCPU[dir]; ok && stats.CPUUsageUsec >= prev.usageUsec {
+					if elapsed := now.Sub(prev.at).Microseconds(); elapsed > 0 {
+						stats.CPUPercent = float64(stats.CPUUsageUsec-prev.usageUsec) * 100 / float64(elapsed)
+					}
+				}
+				seen[dir] = cgroupCPUSample{usageUsec: stats.CPUUsageUsec, at: now}
+			}
+			byDir[dir] = stats
+		}
+		containers[i].Cgroup = stats
+	}
+
+	// Los cgroups que no aparecieron se olvidan
+	d.cgroupCPU = seen
+}
+
+// readCgroupStats lee los archivos de contabilidad de un directorio cgroup v2.
+// io.stat y pids.current dependen de controladores que pueden no estar
+// habilitados; si faltan esos valores quedan en cero.
+func readCgroupStats(dir string) (*CgroupStats, error) {
+	stats := &CgroupStats{}
+
+	current, err := readCgroupInt(filepath.Join(dir, "memory.current"))
+	if err != nil {
+		return nil, err
+	}
+	stats.MemoryCurrentKB = current / 1024
+
+	memory, err := readCgroupKeyed(filepath.Join(dir, "memory.stat"))
+	if err != nil {
+		return nil, err
+	}
+	stats.MemoryAnonKB = memory["anon"] / 1024
+	stats.MemoryFileKB = memory["file"] / 1024
+
+	cpu, err := readCgroupKeyed(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return nil, err
+	}
+	stats.CPUUsageUsec = cpu["usage_usec"]
+
+	stats.IOReadBytes, stats.IOWriteBytes = readCgroupIO(filepath.Join(dir, "io.stat"))
+	stats.Pids, _ = readCgroupInt(filepath.Join(dir, "pids.current"))
+	return stats, nil
+}
+
+func readCgroupInt(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	return value, nil
+}
+
+// readCgroupKeyed lee archivos con formato "clave valor" por línea (memory.stat, cpu.stat).
+func readCgroupKeyed(path string) (map[string]int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]int64)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if value, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			values[fields[0]] = value
+		}
+	}
+	return values, nil
+}
+
+// readCgroupIO suma rbytes y wbytes de todos los dispositivos de io.stat:
+// "8:0 rbytes=1 wbytes=2 rios=3 wios=4 dbytes=0 dios=0".
+func readCgroupIO(path string) (read, write int64) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		for _, field := range strings.Fields(line) {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			value, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			switch parts[0] {
+			case "rbytes":
+				read += value
+			case "wbytes":
+				write += value
+			}
+		}
+	}
+	return read, write
+}
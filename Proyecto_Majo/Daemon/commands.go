@@ -0,0 +1,564 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// command es un subcomando del binario. Todos aceptan además las opciones de
+// configuración, para encontrar la base de datos o la API del daemon.
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"status":  {"consulta el estado del daemon en ejecución por su API", runStatusCommand},
+	"history": {"muestra lo ocurrido con un contenedor: --container NOMBRE --since 1h", runHistoryCommand},
+	"actions": {"lista las eliminaciones y creaciones de contenedores", runActionsCommand},
+	"export":  {"exporta una tabla: --format csv|json --table NOMBRE", runExportCommand},
+	"migrate": {"aplica o lista las migraciones: migrate status|up", runMigrateCommand},
+}
+
+// commandOrder es el orden en que se listan los subcomandos en la ayuda.
+var commandOrder = []string{"status", "history", "actions", "export", "migrate"}
+
+// printUsage lista los subcomandos en stderr.
+func printUsage() {
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "uso: monitor-daemon [subcomando] [flags]")
+	fmt.Fprintln(w, "  run\tarranca el daemon (por defecto)")
+	for _, name := range commandOrder {
+		fmt.Fprintf(w, "  %s\t%s\n", name, commands[name].usage)
+	}
+	w.Flush()
+}
+
+// openCommandDB abre la base de datos de la configuración sin migrarla: las
+// migraciones son responsabilidad del daemon o de "migrate up".
+func openCommandDB(config *DaemonConfig) (*Daemon, error) {
+	if _, err := os.Stat(config.DBPath); err != nil {
+		return nil, fmt.Errorf("no se puede abrir la base de datos: %w", err)
+	}
+
+	db, err := openDB(config.DBPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Daemon{config: config, db: db}, nil
+}
+
+// parseSince acepta una duración hacia atrás ("1h", "30m") o un instante
+// ("2006-01-02 15:04:05" en hora local, o RFC 3339). Vacío no filtra.
+func parseSince(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.ParseInLocation(sqliteTimeFormat, value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("--since inválido: %q (use una duración como 1h o una fecha)", value)
+}
+
+// localTime muestra una fecha de SQLite en hora local; si no se reconoce la deja igual.
+func localTime(value string) string {
+	t, err := parseSQLiteTime(value)
+	if err != nil {
+		return value
+	}
+	return t.Local().Format(sqliteTimeFormat)
+}
+
+// runStatusCommand consulta /api/status del daemon en ejecución.
+func runStatusCommand(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	raw := fs.Bool("json", false, "muestra la respuesta JSON sin formato")
+	config, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if config.APIAddr == "" {
+		return fmt.Errorf("la API HTTP está deshabilitada (api-addr vacío), no se puede consultar el daemon")
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + config.APIAddr + "/api/status")
+	if err != nil {
+		return fmt.Errorf("el daemon no responde en %s: %w", config.APIAddr, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("el daemon respondió %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if *raw {
+		_, err := os.Stdout.Write(body)
+		return err
+	}
+
+	var status struct {
+		Paused        bool             `json:"paused"`
+		DryRun        bool             `json:"dry_run"`
+		LoopInterval  string           `json:"loop_interval"`
+		Protected     []string         `json:"protected"`
+		LastIteration *IterationRecord `json:"last_iteration"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return fmt.Errorf("respuesta inválida del daemon: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Daemon\t%s\n", config.APIAddr)
+	fmt.Fprintf(w, "Pausado\t%s\n", yesNo(status.Paused))
+	fmt.Fprintf(w, "Dry-run\t%s\n", yesNo(status.DryRun))
+	fmt.Fprintf(w, "Intervalo\t%s\n", status.LoopInterval)
+	protected := "ninguno"
+	if len(status.Protected) > 0 {
+		protected = strings.Join(status.Protected, ", ")
+	}
+	fmt.Fprintf(w, "Protegidos\t%s\n", protected)
+
+	if last := status.LastIteration; last != nil {
+		fmt.Fprintf(w, "Última iteración\t#%d %s (%d ms)\n", last.ID, last.StartedAt.Local().Format(sqliteTimeFormat), last.DurationMS)
+		fmt.Fprintf(w, "\tcontenedores %d, eliminados %d, creados %d\n", last.Containers, last.Kills, last.Creates)
+		for _, e := range last.Errors {
+			fmt.Fprintf(w, "\terror: %s\n", e)
+		}
+	} else {
+		fmt.Fprintf(w, "Última iteración\ttodavía ninguna\n")
+	}
+	return w.Flush()
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "sí"
+	}
+	return "no"
+}
+
+// runHistoryCommand reúne lo que la base sabe de un contenedor: ciclos de
+// vida, anomalías, acciones y muestras, para responder por qué se eliminó.
+func runHistoryCommand(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	container := fs.String("container", "", "nombre o prefijo del ID del contenedor (obligatorio)")
+	sinceFlag := fs.String("since", "1h", "desde cuándo: duración (1h) o fecha")
+	samples := fs.Int("samples", 20, "cantidad máxima de muestras de métricas a mostrar")
+	config, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if *container == "" {
+		return fmt.Errorf("uso: history --container NOMBRE [--since 1h]")
+	}
+	since, err := parseSince(*sinceFlag)
+	if err != nil {
+		return err
+	}
+
+	d, err := openCommandDB(config)
+	if err != nil {
+		return err
+	}
+	defer d.db.Close()
+
+	ctx := context.Background()
+	cutoff := since.UTC().Format(sqliteTimeFormat)
+	match := `(name = ? OR container_id LIKE ? || '%')`
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Contenedor %s desde %s\n\n", *container, since.Local().Format(sqliteTimeFormat))
+
+	fmt.Fprintln(w, "CICLO DE VIDA")
+	fmt.Fprintln(w, "NOMBRE\tID\tDESDE\tHASTA\tMUESTRAS\tPICO RSS KB\tPICO CPU %\tCLASE\tFIN")
+	err = printRows(ctx, d.db, w, `SELECT COALESCE(name, ''), substr(COALESCE(container_id, ''), 1, 12), first_seen,
+			last_seen, samples, COALESCE(peak_rss_kb, 0), COALESCE(peak_cpu_percent, 0), COALESCE(class, ''),
+			COALESCE(ended_at, ''), COALESCE(end_reason, '')
+		FROM container_lifecycle WHERE `+match+` AND last_seen >= ? ORDER BY first_seen`,
+		[]interface{}{*container, *container, cutoff},
+		func(v []string) string {
+			end := "activo"
+			if v[8] != "" {
+				end = localTime(v[8]) + " " + v[9]
+			}
+			return strings.Join([]string{v[0], v[1], localTime(v[2]), localTime(v[3]), v[4], v[5], v[6], v[7], end}, "\t")
+		})
+	if err != nil {
+		return fmt.Errorf("ciclos de vida: %w", err)
+	}
+
+	fmt.Fprintln(w, "\nANOMALÍAS")
+	fmt.Fprintln(w, "FECHA\tTIPO\tDETALLE")
+	err = printRows(ctx, d.db, w, `SELECT timestamp, kind, COALESCE(detail, '')
+		FROM anomalies WHERE `+match+` AND timestamp >= ? ORDER BY timestamp`,
+		[]interface{}{*container, *container, cutoff},
+		func(v []string) string { return localTime(v[0]) + "\t" + v[1] + "\t" + v[2] })
+	if err != nil {
+		return fmt.Errorf("anomalías: %w", err)
+	}
+
+	fmt.Fprintln(w, "\nACCIONES")
+	fmt.Fprintln(w, "FECHA\tACCIÓN\tRAZÓN")
+	actions, err := d.queryActions(ctx, actionFilter{container: *container, since: since, limit: 1000})
+	if err != nil {
+		return fmt.Errorf("acciones: %w", err)
+	}
+	for i := len(actions) - 1; i >= 0; i-- {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", localTime(actions[i].Timestamp), actions[i].Action, actions[i].Reason)
+	}
+	if len(actions) == 0 {
+		fmt.Fprintln(w, "(sin registros)")
+	}
+
+	fmt.Fprintln(w, "\nMÉTRICAS")
+	fmt.Fprintln(w, "FECHA\tPID\tPROCESOS\tRSS KB\tCPU %\tCGROUP KB\tCGROUP CPU %")
+	err = printRows(ctx, d.db, w, `SELECT timestamp, pid, COALESCE(tree_processes, 1),
+			COALESCE(tree_rss_kb, rss_kb, 0), COALESCE(tree_cpu_percent, cpu_percent, 0),
+			COALESCE(cgroup_memory_kb, ''), COALESCE(round(cgroup_cpu_percent, 1), '')
+		FROM (SELECT * FROM container_metrics WHERE `+match+` AND timestamp >= ? ORDER BY id DESC LIMIT ?)
+		ORDER BY id`,
+		[]interface{}{*container, *container, cutoff, *samples},
+		func(v []string) string { return localTime(v[0]) + "\t" + strings.Join(v[1:], "\t") })
+	if err != nil {
+		return fmt.Errorf("métricas: %w", err)
+	}
+	return w.Flush()
+}
+
+// printRows escribe una línea por fila; format recibe las columnas como texto.
+func printRows(ctx context.Context, db *sql.DB, w io.Writer, query string, args []interface{}, format func([]string) string) error {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	empty := true
+	for rows.Next() {
+		values, err := scanText(rows, len(columns))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, format(values))
+		empty = false
+	}
+	if empty {
+		fmt.Fprintln(w, "(sin registros)")
+	}
+	return rows.Err()
+}
+
+// scanText lee una fila convirtiendo cada columna a texto; NULL queda vacío.
+func scanText(rows *sql.Rows, n int) ([]string, error) {
+	values, err := scanValues(rows, n)
+	if err != nil {
+		return nil, err
+	}
+
+	text := make([]string, n)
+	for i, value := range values {
+		switch v := value.(type) {
+		case nil:
+		case time.Time:
+			text[i] = v.UTC().Format(sqliteTimeFormat)
+		case float64:
+			text[i] = fmt.Sprintf("%g", v)
+		default:
+			text[i] = fmt.Sprint(v)
+		}
+	}
+	return text, nil
+}
+
+// scanValues lee una fila con los tipos del driver; los []byte se devuelven como texto.
+func scanValues(rows *sql.Rows, n int) ([]interface{}, error) {
+	values := make([]interface{}, n)
+	pointers := make([]interface{}, n)
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	if err := rows.Scan(pointers...); err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		if b, ok := value.([]byte); ok {
+			values[i] = string(b)
+		}
+	}
+	return values, nil
+}
+
+// runActionsCommand lista las acciones sobre contenedores; por defecto las
+// eliminaciones y creaciones reales.
+func runActionsCommand(args []string) error {
+	fs := flag.NewFlagSet("actions", flag.ContinueOnError)
+	container := fs.String("container", "", "solo las de este contenedor (nombre o prefijo del ID)")
+	sinceFlag := fs.String("since", "", "desde cuándo: duración (24h) o fecha")
+	kinds := fs.String("action", "KILLED,CREATED", "tipos de acción separados por coma (all: todos)")
+	limit := fs.Int("limit", defaultListLimit, "cantidad máxima de acciones")
+	config, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	since, err := parseSince(*sinceFlag)
+	if err != nil {
+		return err
+	}
+	if *limit <= 0 {
+		return fmt.Errorf("--limit debe ser un entero positivo")
+	}
+
+	filter := actionFilter{container: *container, since: since, limit: *limit}
+	if *kinds != "all" {
+		for _, kind := range strings.Split(*kinds, ",") {
+			if kind = strings.ToUpper(strings.TrimSpace(kind)); kind != "" {
+				filter.actions = append(filter.actions, kind)
+			}
+		}
+	}
+
+	d, err := openCommandDB(config)
+	if err != nil {
+		return err
+	}
+	defer d.db.Close()
+
+	actions, err := d.queryActions(context.Background(), filter)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FECHA\tACCIÓN\tCONTENEDOR\tID\tIMAGEN\tRAZÓN")
+	for i := len(actions) - 1; i >= 0; i-- {
+		a := actions[i]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.12s\t%s\t%s\n", localTime(a.Timestamp), a.Action, a.ContainerName, a.ContainerID, a.Image, a.Reason)
+	}
+	return w.Flush()
+}
+
+// exportTimeColumns son las columnas por las que --since filtra, en orden de preferencia.
+var exportTimeColumns = []string{"timestamp", "bucket", "started_at", "first_seen"}
+
+// runExportCommand vuelca una tabla completa en CSV o JSON.
+func runExportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "csv", "formato de salida: csv o json")
+	table := fs.String("table", "", "tabla a exportar (obligatorio)")
+	sinceFlag := fs.String("since", "", "solo las filas desde: duración (24h) o fecha")
+	output := fs.String("output", "", "archivo de salida (vacío: stdout)")
+	config, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("--format debe ser csv o json")
+	}
+	since, err := parseSince(*sinceFlag)
+	if err != nil {
+		return err
+	}
+
+	d, err := openCommandDB(config)
+	if err != nil {
+		return err
+	}
+	defer d.db.Close()
+
+	tables, err := exportableTables(d.db)
+	if err != nil {
+		return err
+	}
+	columns, ok := tables[*table]
+	if !ok {
+		names := make([]string, 0, len(tables))
+		for name := range tables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("uso: export --table NOMBRE; tablas disponibles: %s", strings.Join(names, ", "))
+	}
+
+	// El nombre se validó contra sqlite_master, así que puede ir en la consulta
+	query := `SELECT * FROM "` + *table + `"`
+	var queryArgs []interface{}
+	if !since.IsZero() {
+		column := ""
+		for _, candidate := range exportTimeColumns {
+			if columns[candidate] {
+				column = candidate
+				break
+			}
+		}
+		if column == "" {
+			return fmt.Errorf("la tabla %s no tiene columna de tiempo para --since", *table)
+		}
+		query += ` WHERE "` + column + `" >= ?`
+		queryArgs = append(queryArgs, since.UTC().Format(sqliteTimeFormat))
+	}
+	query += ` ORDER BY rowid`
+
+	rows, err := d.db.Query(query, queryArgs...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	write := exportCSV
+	if *format == "json" {
+		write = exportJSON
+	}
+	if *output == "" {
+		return write(os.Stdout, rows)
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := write(file, rows); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// exportableTables devuelve las tablas de la base con sus columnas, sin las internas de SQLite.
+func exportableTables(db *sql.DB) (map[string]map[string]bool, error) {
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'`)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tables := make(map[string]map[string]bool, len(names))
+	for _, name := range names {
+		info, err := db.Query(`SELECT name FROM pragma_table_info(?)`, name)
+		if err != nil {
+			return nil, err
+		}
+		columns := make(map[string]bool)
+		for info.Next() {
+			var column string
+			if err := info.Scan(&column); err != nil {
+				info.Close()
+				return nil, err
+			}
+			columns[column] = true
+		}
+		info.Close()
+		tables[name] = columns
+	}
+	return tables, nil
+}
+
+func exportCSV(out io.Writer, rows *sql.Rows) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(out)
+	if err := w.Write(columns); err != nil {
+		return err
+	}
+	for rows.Next() {
+		values, err := scanText(rows, len(columns))
+		if err != nil {
+			return err
+		}
+		if err := w.Write(values); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// exportJSON escribe un arreglo de objetos fila por fila, sin cargar la tabla en memoria.
+func exportJSON(out io.Writer, rows *sql.Rows) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(out, "["); err != nil {
+		return err
+	}
+	first := true
+	for rows.Next() {
+		values, err := scanValues(rows, len(columns))
+		if err != nil {
+			return err
+		}
+
+		record := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if t, ok := values[i].(time.Time); ok {
+				values[i] = t.UTC().Format(sqliteTimeFormat)
+			}
+			record[column] = values[i]
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		separator := ",\n"
+		if first {
+			separator = "\n"
+			first = false
+		}
+		if _, err := io.WriteString(out, separator); err != nil {
+			return err
+		}
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = io.WriteString(out, "\n]\n")
+	return err
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newCommandTestDB crea una base migrada con filas de dos contenedores, una de
+// hace dos horas y otra de hace diez minutos por tabla, y devuelve su ruta.
+func newCommandTestDB(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "monitoring.db")
+	db, err := openDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-2 * time.Hour).UTC().Format(sqliteTimeFormat)
+	recent := time.Now().Add(-10 * time.Minute).UTC().Format(sqliteTimeFormat)
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`INSERT INTO container_metrics (timestamp, pid, name, rss_kb, cpu_percent, cmdline) VALUES (?, 10, 'web', 100, 5, NULL)`, []interface{}{old}},
+		{`INSERT INTO container_metrics (timestamp, pid, name, rss_kb, cpu_percent, cmdline) VALUES (?, 10, 'web', 200, 7, 'nginx -g "daemon off;"')`, []interface{}{recent}},
+		{`INSERT INTO container_metrics (timestamp, pid, name, rss_kb, cpu_percent) VALUES (?, 20, 'db', 900, 50)`, []interface{}{recent}},
+		{`INSERT INTO container_metrics_1m (bucket, container_key, name, samples, rss_avg_kb) VALUES (?, 'web:10', 'web', 3, 100)`, []interface{}{old}},
+		{`INSERT INTO container_metrics_1m (bucket, container_key, name, samples, rss_avg_kb) VALUES (?, 'web:10', 'web', 3, 200)`, []interface{}{recent}},
+		{`INSERT INTO container_lifecycle (container_key, name, pid, first_seen, last_seen, samples, class) VALUES ('web:10', 'web', 10, ?, ?, 5, 'low')`, []interface{}{old, recent}},
+		{`INSERT INTO container_lifecycle (container_key, name, pid, first_seen, last_seen, samples, class) VALUES ('db:20', 'db', 20, ?, ?, 5, 'high')`, []interface{}{recent, recent}},
+		{`INSERT INTO container_actions (timestamp, action, container_pid, container_name, reason) VALUES (?, 'KILLED', 10, 'web', 'exceso viejo')`, []interface{}{old}},
+		{`INSERT INTO container_actions (timestamp, action, container_pid, container_name, reason) VALUES (?, 'THROTTLED', 10, 'web', 'exceso reciente')`, []interface{}{recent}},
+		{`INSERT INTO container_actions (timestamp, action, container_pid, container_name, reason) VALUES (?, 'KILLED', 20, 'db', 'exceso de db')`, []interface{}{recent}},
+		{`INSERT INTO anomalies (timestamp, container_key, name, kind, detail) VALUES (?, 'web:10', 'web', 'leak', 'pendiente 512 KB')`, []interface{}{recent}},
+	}
+	for _, s := range statements {
+		if _, err := db.Exec(s.query, s.args...); err != nil {
+			t.Fatalf("%s: %v", s.query, err)
+		}
+	}
+	return path
+}
+
+// captureStdout devuelve lo que run escribe en os.Stdout.
+func captureStdout(t *testing.T, run func() error) (string, error) {
+	t.Helper()
+	file, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	stdout := os.Stdout
+	os.Stdout = file
+	runErr := run()
+	os.Stdout = stdout
+
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), runErr
+}
+
+func TestExportRejectsUnknownTables(t *testing.T) {
+	path := newCommandTestDB(t)
+	for _, table := range []string{"", "no_existe", "sqlite_sequence", `container_metrics"; DROP TABLE container_metrics; --`} {
+		err := runExportCommand([]string{"-db-path", path, "-table", table})
+		if err == nil || !strings.Contains(err.Error(), "tablas disponibles") {
+			t.Errorf("--table %q: error %v, se esperaba la lista de tablas", table, err)
+		}
+	}
+
+	err := runExportCommand([]string{"-db-path", path, "-table", "container_metrics", "-format", "xml"})
+	if err == nil {
+		t.Error("--format xml aceptado")
+	}
+}
+
+func TestExportableTables(t *testing.T) {
+	path := newCommandTestDB(t)
+	db, err := openDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tables, err := exportableTables(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tables["sqlite_sequence"]; ok {
+		t.Error("se exporta la tabla interna sqlite_sequence")
+	}
+	for table, column := range map[string]string{
+		"container_metrics":    "timestamp",
+		"container_metrics_1m": "bucket",
+		"iterations":           "started_at",
+		"container_lifecycle":  "first_seen",
+		"schema_migrations":    "applied_at",
+	} {
+		if !tables[table][column] {
+			t.Errorf("%s sin la columna %s: %v", table, column, tables[table])
+		}
+	}
+}
+
+func TestExportSinceUsesTheTableTimeColumn(t *testing.T) {
+	path := newCommandTestDB(t)
+
+	// timestamp, bucket y first_seen: solo quedan las filas de la última hora
+	cases := map[string]int{
+		"container_metrics":    2,
+		"container_metrics_1m": 1,
+		"container_lifecycle":  1,
+		"container_actions":    2,
+	}
+	for table, want := range cases {
+		output := filepath.Join(t.TempDir(), table+".json")
+		err := runExportCommand([]string{"-db-path", path, "-table", table, "-since", "1h", "-format", "json", "-output", output})
+		if err != nil {
+			t.Errorf("%s: %v", table, err)
+			continue
+		}
+		var records []map[string]interface{}
+		data, _ := os.ReadFile(output)
+		if err := json.Unmarshal(data, &records); err != nil {
+			t.Errorf("%s: JSON inválido: %v", table, err)
+			continue
+		}
+		if len(records) != want {
+			t.Errorf("%s: %d filas desde hace 1h, se esperaban %d", table, len(records), want)
+		}
+	}
+
+	// Sin columna de tiempo --since es un error, no un volcado completo
+	err := runExportCommand([]string{"-db-path", path, "-table", "schema_migrations", "-since", "1h"})
+	if err == nil || !strings.Contains(err.Error(), "columna de tiempo") {
+		t.Errorf("schema_migrations con --since: error %v", err)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	path := newCommandTestDB(t)
+	output := filepath.Join(t.TempDir(), "metrics.csv")
+	if err := runExportCommand([]string{"-db-path", path, "-table", "container_metrics", "-output", output}); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatalf("CSV inválido: %v", err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("%d registros, se esperaban cabecera y 3 filas", len(records))
+	}
+
+	column := make(map[string]int)
+	for i, name := range records[0] {
+		column[name] = i
+	}
+	for _, name := range []string{"id", "timestamp", "name", "rss_kb", "cmdline", "container_id"} {
+		if _, ok := column[name]; !ok {
+			t.Errorf("falta la columna %s en la cabecera %v", name, records[0])
+		}
+	}
+
+	first, second := records[1], records[2]
+	if first[column["name"]] != "web" || first[column["rss_kb"]] != "100" {
+		t.Errorf("primera fila %v", first)
+	}
+	// NULL queda vacío y las comillas se escapan según CSV
+	if first[column["cmdline"]] != "" {
+		t.Errorf("cmdline NULL exportado como %q", first[column["cmdline"]])
+	}
+	if second[column["cmdline"]] != `nginx -g "daemon off;"` {
+		t.Errorf("cmdline %q", second[column["cmdline"]])
+	}
+	if _, err := time.Parse(sqliteTimeFormat, first[column["timestamp"]]); err != nil {
+		t.Errorf("timestamp %q no tiene el formato de SQLite", first[column["timestamp"]])
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	path := newCommandTestDB(t)
+	output := filepath.Join(t.TempDir(), "metrics.json")
+	if err := runExportCommand([]string{"-db-path", path, "-table", "container_metrics", "-format", "json", "-output", output}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []map[string]interface{}
+	if err := json.Unmarshal(data, &records); err != nil {
+		t.Fatalf("JSON inválido: %v\n%s", err, data)
+	}
+	if len(records) != 3 {
+		t.Fatalf("%d filas, se esperaban 3", len(records))
+	}
+
+	web := records[0]
+	if web["name"] != "web" || web["rss_kb"] != float64(100) {
+		t.Errorf("primera fila %v", web)
+	}
+	if value, ok := web["cmdline"]; !ok || value != nil {
+		t.Errorf("cmdline NULL exportado como %#v", value)
+	}
+	if ts, _ := web["timestamp"].(string); ts == "" {
+		t.Errorf("timestamp %#v, se esperaba texto", web["timestamp"])
+	} else if _, err := time.Parse(sqliteTimeFormat, ts); err != nil {
+		t.Errorf("timestamp %q no tiene el formato de SQLite", ts)
+	}
+}
+
+func TestHistoryCommand(t *testing.T) {
+	path := newCommandTestDB(t)
+
+	if err := runHistoryCommand([]string{"-db-path", path}); err == nil {
+		t.Error("history sin --container aceptado")
+	}
+	if err := runHistoryCommand([]string{"-db-path", path, "-container", "web", "-since", "ayer"}); err == nil {
+		t.Error("--since inválido aceptado")
+	}
+
+	out, err := captureStdout(t, func() error {
+		return runHistoryCommand([]string{"-db-path", path, "-container", "web", "-since", "1h"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"CICLO DE VIDA", "low", "pendiente 512 KB", "THROTTLED", "exceso reciente", "200"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("falta %q en:\n%s", want, out)
+		}
+	}
+	// Ni otro contenedor ni lo anterior a --since
+	for _, unwanted := range []string{"high", "exceso de db", "exceso viejo", "900"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("sobra %q en:\n%s", unwanted, out)
+		}
+	}
+}
@@ -0,0 +1,559 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configField describe una opción de DaemonConfig. La misma entrada se usa como
+// clave del archivo de configuración, como flag (-name) y como variable de
+// entorno (MONITOR_NAME, con guiones convertidos a guiones bajos).
+type configField struct {
+	name   string
+	usage  string
+	reload bool // se aplica en caliente al recibir SIGHUP
+	bind   func(c *DaemonConfig) interface{}
+}
+
+var configFields = []configField{
+	{name: "project-root", usage: "directorio raíz del proyecto (contiene Bash/ y Grafana/)",
+		bind: func(c *DaemonConfig) interface{} { return &c.ProjectRoot }},
+	{name: "container-info-path", usage: "archivo /proc del módulo de contenedores",
+		bind: func(c *DaemonConfig) interface{} { return &c.ContainerInfoPath }},
+	{name: "system-info-path", usage: "archivo /proc del módulo de sistema",
+		bind: func(c *DaemonConfig) interface{} { return &c.SystemInfoPath }},
+	{name: "metrics-source", usage: "auto (módulos de kernel o /proc si no están cargados), kernel, proc o fixture", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.MetricsSource }},
+	{name: "fixture-dir", usage: "directorio con sysinfo.json y continfo.json para -metrics-source=fixture", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.FixtureDir }},
+	{name: "db-path", usage: "ruta de la base de datos SQLite",
+		bind: func(c *DaemonConfig) interface{} { return &c.DBPath }},
+	{name: "lock-file", usage: "pidfile con lock que impide dos instancias (vacío: db-path + .lock)",
+		bind: func(c *DaemonConfig) interface{} { return &c.LockFile }},
+	{name: "loop-interval", usage: "intervalo entre iteraciones", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.LoopInterval }},
+	{name: "min-low", usage: "contenedores de bajo consumo a mantener", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.MinLowConsumption }},
+	{name: "min-high", usage: "contenedores de alto consumo a mantener", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.MinHighConsumption }},
+	{name: "memory-threshold-kb", usage: "RSS en KB a partir del cual un contenedor es de alto consumo", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.MemoryThreshold }},
+	{name: "cpu-threshold", usage: "porcentaje de CPU a partir del cual un contenedor es de alto consumo", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.CPUThreshold }},
+	{name: "policy", usage: "archivo JSON de política (vacío: política basada en umbrales)", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.PolicyPath }},
+	{name: "workload-profile", usage: "archivo JSON con las imágenes y pesos por clase para crear contenedores", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.WorkloadProfilePath }},
+	{name: "dry-run", usage: "clasificar y registrar decisiones (WOULD_KILL/WOULD_CREATE) sin actuar", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.DryRun }},
+	{name: "grace-iterations", usage: "iteraciones que debe verse un contenedor antes de que pueda eliminarse", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.GraceIterations }},
+	{name: "reclassify-samples", usage: "muestras seguidas de otra clase necesarias para reclasificar un contenedor", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.ReclassifySamples }},
+	{name: "enforcement-mode", usage: "kill (eliminar directamente) o graduated (limitar, pausar y eliminar)", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.EnforcementMode }},
+	{name: "escalate-after", usage: "iteraciones en exceso antes de pasar a la etapa siguiente o de liberar", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.EscalateAfter }},
+	{name: "throttle-memory-percent", usage: "memory.high al limitar, como porcentaje del RSS actual", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.ThrottleMemoryPercent }},
+	{name: "throttle-cpu-percent", usage: "cpu.max al limitar, como porcentaje de una CPU", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.ThrottleCPUPercent }},
+	{name: "cgroup-root", usage: "punto de montaje de cgroup v2",
+		bind: func(c *DaemonConfig) interface{} { return &c.CgroupRoot }},
+	{name: "anomaly-alpha", usage: "peso de cada muestra en la media y varianza exponencial de RSS y CPU (0-1]", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.AnomalyAlpha }},
+	{name: "anomaly-zscore", usage: "desviaciones estándar desde la media para registrar un outlier", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.AnomalyZScore }},
+	{name: "leak-window", usage: "iteraciones sobre las que se mide la pendiente de RSS (y calentamiento de los outliers)", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.LeakWindow }},
+	{name: "leak-min-slope-kb", usage: "crecimiento mínimo de RSS, en KB por iteración, para registrar una fuga", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.LeakMinSlopeKB }},
+	{name: "alert-rules", usage: "archivo JSON de reglas de alerta (vacío: memoria del host, kill rate y lecturas fallidas)", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.AlertRulesPath }},
+	{name: "alert-webhook", usage: "URL a la que se envían las alertas activas y resueltas (vacío: solo se registran)", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.AlertWebhook }},
+	{name: "alert-retries", usage: "reintentos de cada notificación al webhook", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.AlertRetries }},
+	{name: "alert-timeout", usage: "plazo de cada intento de envío al webhook", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.AlertTimeout }},
+	{name: "process-top-n", usage: "guardar solo los N procesos de mayor RSS por iteración (0: todos)", reload: true,
+		bind: func(c *DaemonConfig) interface{} { return &c.ProcessTopN }},
+	{name: "api-addr", usage: "dirección de loopback de la API HTTP de control, sin autenticación (vacío: deshabilitada)",
+		bind: func(c *DaemonConfig) interface{} { return &c.APIAddr }},
+	{name: "grafana-addr", usage: "dirección del datasource de Grafana (/grafana/) y de /metrics, de solo lectura (vacío: deshabilitada)",
+		bind: func(c *DaemonConfig) interface{} { return &c.GrafanaAddr }},
+	{name: "shutdown-timeout", usage: "espera máxima por la iteración en curso y por la limpieza al apagar",
+		bind: func(c *DaemonConfig) interface{} { return &c.ShutdownTimeout }},
+	{name: "create-schedule", usage: "cron o @every de iteraciones de creación adicionales al loop (vacío deshabilita)",
+		bind: func(c *DaemonConfig) interface{} { return &c.CreateSchedule }},
+	{name: "retention-schedule", usage: "cron o @every de los rollups y la purga (vacío deshabilita)",
+		bind: func(c *DaemonConfig) interface{} { return &c.RetentionSchedule }},
+	{name: "report-schedule", usage: "cron o @every del reporte periódico (vacío deshabilita)",
+		bind: func(c *DaemonConfig) interface{} { return &c.ReportSchedule }},
+	{name: "retention-raw", usage: "tiempo que se conservan las métricas crudas",
+		bind: func(c *DaemonConfig) interface{} { return &c.RetentionRaw }},
+	{name: "retention-1m", usage: "tiempo que se conservan los agregados por minuto",
+		bind: func(c *DaemonConfig) interface{} { return &c.Retention1m }},
+	{name: "retention-1h", usage: "tiempo que se conservan los agregados por hora",
+		bind: func(c *DaemonConfig) interface{} { return &c.Retention1h }},
+	{name: "create-script", usage: "script de creación anterior, para limpiar sus entradas del crontab (por defecto <project-root>/Bash/create_containers.sh)",
+		bind: func(c *DaemonConfig) interface{} { return &c.CreateContainersScript }},
+	{name: "clean-script", usage: "script de limpieza de contenedores (por defecto <project-root>/Bash/clean_containers.sh)",
+		bind: func(c *DaemonConfig) interface{} { return &c.CleanContainersScript }},
+	{name: "kernel-modules-script", usage: "script de carga de módulos (por defecto <project-root>/load_kernel_modules.sh)",
+		bind: func(c *DaemonConfig) interface{} { return &c.KernelModulesScript }},
+	{name: "bash-dir", usage: "directorio de trabajo de los scripts (por defecto <project-root>/Bash)",
+		bind: func(c *DaemonConfig) interface{} { return &c.BashDir }},
+	{name: "docker-socket", usage: "socket unix del Docker Engine",
+		bind: func(c *DaemonConfig) interface{} { return &c.DockerSocket }},
+	{name: "docker-events", usage: "seguir los eventos start/die/oom/kill del engine para reaccionar sin esperar al loop",
+		bind: func(c *DaemonConfig) interface{} { return &c.DockerEvents }},
+}
+
+// defaultConfig devuelve los valores por defecto; las rutas derivadas de
+// ProjectRoot se completan en resolvePaths.
+func defaultConfig() *DaemonConfig {
+	return &DaemonConfig{
+		ContainerInfoPath:     "/proc/continfo_so1_202100265",
+		SystemInfoPath:        "/proc/sysinfo_so1_202100265",
+		MetricsSource:         sourceAuto,
+		LoopInterval:          20 * time.Second,
+		MinLowConsumption:     3,
+		MinHighConsumption:    2,
+		MemoryThreshold:       30000, // 30MB en KB
+		CPUThreshold:          80,    // 80%
+		GraceIterations:       3,     // 1 minuto con el intervalo por defecto
+		ReclassifySamples:     2,
+		EnforcementMode:       enforcementKill,
+		EscalateAfter:         3,
+		ThrottleMemoryPercent: 80,
+		ThrottleCPUPercent:    20,
+		CgroupRoot:            "/sys/fs/cgroup",
+		AnomalyAlpha:          0.3,
+		AnomalyZScore:         3,
+		LeakWindow:            10,
+		LeakMinSlopeKB:        256,
+		AlertRetries:          3,
+		AlertTimeout:          5 * time.Second,
+		DockerSocket:          "/var/run/docker.sock",
+		DockerEvents:          true,
+		APIAddr:               "127.0.0.1:8081",
+		GrafanaAddr:           "172.17.0.1:8082", // gateway de docker0: host.docker.internal para el contenedor de Grafana
+		ShutdownTimeout:       30 * time.Second,
+		RetentionSchedule:     "@every 10m",
+		ReportSchedule:        "@hourly",
+		RetentionRaw:          24 * time.Hour,
+		Retention1m:           7 * 24 * time.Hour,
+		Retention1h:           90 * 24 * time.Hour,
+	}
+}
+
+// LoadConfig arma la configuración por capas: valores por defecto, archivo
+// (-config o MONITOR_CONFIG), variables de entorno y flags.
+func LoadConfig(args []string) (*DaemonConfig, error) {
+	return loadConfig(flag.NewFlagSet("monitor-daemon", flag.ContinueOnError), args)
+}
+
+// loadConfig es LoadConfig sobre un FlagSet que puede traer ya registrados
+// los flags propios de un subcomando.
+func loadConfig(fs *flag.FlagSet, args []string) (*DaemonConfig, error) {
+	configPath := fs.String("config", os.Getenv("MONITOR_CONFIG"), "archivo de configuración JSON")
+
+	flagValues := make(map[string]*rawFlag, len(configFields))
+	for _, field := range configFields {
+		_, isBool := field.bind(&DaemonConfig{}).(*bool)
+		flagValues[field.name] = &rawFlag{isBool: isBool}
+		fs.Var(flagValues[field.name], field.name, field.usage)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("argumentos no reconocidos: %s", strings.Join(fs.Args(), " "))
+	}
+
+	config := defaultConfig()
+	config.ConfigPath = *configPath
+
+	// Archivo de configuración
+	if config.ConfigPath != "" {
+		if err := applyConfigFile(config, config.ConfigPath); err != nil {
+			return nil, err
+		}
+	}
+
+	// Variables de entorno
+	for _, field := range configFields {
+		key := envKey(field.name)
+		if value, ok := os.LookupEnv(key); ok {
+			if err := setConfigValue(field.bind(config), value); err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+
+	// Flags explícitos
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		raw, ok := flagValues[f.Name]
+		if !ok || flagErr != nil {
+			return
+		}
+		for _, field := range configFields {
+			if field.name == f.Name {
+				if err := setConfigValue(field.bind(config), raw.value); err != nil {
+					flagErr = fmt.Errorf("-%s: %w", f.Name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	config.resolvePaths()
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// rawFlag guarda el texto de un flag para aplicarlo después del archivo y del entorno.
+type rawFlag struct {
+	value  string
+	isBool bool
+}
+
+func (f *rawFlag) String() string { return f.value }
+
+func (f *rawFlag) Set(value string) error {
+	f.value = value
+	return nil
+}
+
+// IsBoolFlag permite usar -dry-run sin valor explícito.
+func (f *rawFlag) IsBoolFlag() bool { return f.isBool }
+
+// applyConfigFile aplica un objeto JSON cuyas claves son los nombres de configField.
+func applyConfigFile(config *DaemonConfig, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error leyendo configuración: %w", err)
+	}
+
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("error parseando configuración %s: %w", path, err)
+	}
+
+	known := make(map[string]configField, len(configFields))
+	for _, field := range configFields {
+		known[field.name] = field
+	}
+
+	// Orden estable para que los errores sean reproducibles
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		field, ok := known[key]
+		if !ok {
+			return fmt.Errorf("%s: opción desconocida %q", path, key)
+		}
+
+		raw := bytes.TrimSpace(values[key])
+		value := string(raw)
+		if len(raw) > 0 && raw[0] == '"' {
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return fmt.Errorf("%s: %s: %w", path, key, err)
+			}
+		}
+
+		if err := setConfigValue(field.bind(config), value); err != nil {
+			return fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+	}
+
+	return nil
+}
+
+// setConfigValue convierte value al tipo del campo apuntado por target.
+func setConfigValue(target interface{}, value string) error {
+	switch p := target.(type) {
+	case *string:
+		*p = value
+	case *bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *int:
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *int64:
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *float64:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *time.Duration:
+		v, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*p = v
+	default:
+		return fmt.Errorf("tipo de configuración no soportado %T", target)
+	}
+	return nil
+}
+
+func envKey(name string) string {
+	return "MONITOR_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// resolvePaths completa las rutas que dependen de ProjectRoot.
+func (c *DaemonConfig) resolvePaths() {
+	if c.ProjectRoot == "" {
+		c.ProjectRoot = detectProjectRoot()
+	}
+	if abs, err := filepath.Abs(c.ProjectRoot); err == nil {
+		c.ProjectRoot = abs
+	}
+
+	if c.BashDir == "" {
+		c.BashDir = filepath.Join(c.ProjectRoot, "Bash")
+	}
+	if c.CreateContainersScript == "" {
+		c.CreateContainersScript = filepath.Join(c.BashDir, "create_containers.sh")
+	}
+	if c.CleanContainersScript == "" {
+		c.CleanContainersScript = filepath.Join(c.BashDir, "clean_containers.sh")
+	}
+	if c.KernelModulesScript == "" {
+		c.KernelModulesScript = filepath.Join(c.ProjectRoot, "load_kernel_modules.sh")
+	}
+	if c.DBPath == "" {
+		c.DBPath = filepath.Join(c.ProjectRoot, "Daemon", "monitoring.db")
+	}
+	if c.LockFile == "" {
+		c.LockFile = c.DBPath + ".lock"
+	}
+}
+
+// detectProjectRoot busca el directorio que contiene Bash/, empezando por el
+// del ejecutable y luego por el directorio actual.
+func detectProjectRoot() string {
+	var candidates []string
+	if exe, err := os.Executable(); err == nil {
+		if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+			exe = resolved
+		}
+		candidates = append(candidates, filepath.Dir(filepath.Dir(exe)), filepath.Dir(exe))
+	}
+
+	cwd, err := os.Getwd()
+	if err == nil {
+		candidates = append(candidates, filepath.Dir(cwd), cwd)
+	}
+
+	for _, dir := range candidates {
+		if info, err := os.Stat(filepath.Join(dir, "Bash")); err == nil && info.IsDir() {
+			return dir
+		}
+	}
+
+	// Comportamiento histórico: el daemon se ejecuta desde Daemon/
+	return filepath.Dir(cwd)
+}
+
+// validate reporta todos los valores inválidos de una vez.
+func (c *DaemonConfig) validate() error {
+	var problems []string
+
+	if c.LoopInterval < time.Second {
+		problems = append(problems, "loop-interval debe ser al menos 1s")
+	}
+	switch c.MetricsSource {
+	case sourceAuto, sourceKernel, sourceProc:
+	case sourceFixture:
+		if c.FixtureDir == "" {
+			problems = append(problems, "metrics-source=fixture requiere fixture-dir")
+		}
+	default:
+		problems = append(problems, "metrics-source debe ser auto, kernel, proc o fixture")
+	}
+	if c.MinLowConsumption < 0 {
+		problems = append(problems, "min-low no puede ser negativo")
+	}
+	if c.MinHighConsumption < 0 {
+		problems = append(problems, "min-high no puede ser negativo")
+	}
+	if c.MemoryThreshold <= 0 {
+		problems = append(problems, "memory-threshold-kb debe ser positivo")
+	}
+	if c.CPUThreshold < 0 {
+		problems = append(problems, "cpu-threshold no puede ser negativo")
+	}
+	if c.GraceIterations < 1 {
+		problems = append(problems, "grace-iterations debe ser al menos 1")
+	}
+	if c.ReclassifySamples < 1 {
+		problems = append(problems, "reclassify-samples debe ser al menos 1")
+	}
+	if c.APIAddr != "" && !isLoopbackAddr(c.APIAddr) {
+		// Pausar, proteger o forzar iteraciones no requiere credenciales
+		problems = append(problems, "api-addr debe ser una dirección de loopback (127.0.0.1, ::1 o localhost); use grafana-addr para exponer /grafana/ y /metrics")
+	}
+	if c.GrafanaAddr != "" && c.GrafanaAddr == c.APIAddr {
+		problems = append(problems, "grafana-addr y api-addr deben ser distintas")
+	}
+	if c.ShutdownTimeout <= 0 {
+		problems = append(problems, "shutdown-timeout debe ser positivo")
+	}
+	if c.EnforcementMode != enforcementGraduated && c.EnforcementMode != enforcementKill {
+		problems = append(problems, "enforcement-mode debe ser graduated o kill")
+	}
+	if c.EscalateAfter < 1 {
+		problems = append(problems, "escalate-after debe ser al menos 1")
+	}
+	if c.ThrottleMemoryPercent < 1 || c.ThrottleMemoryPercent > 100 {
+		problems = append(problems, "throttle-memory-percent debe estar entre 1 y 100")
+	}
+	if c.ThrottleCPUPercent < 1 {
+		problems = append(problems, "throttle-cpu-percent debe ser positivo")
+	}
+	if c.AnomalyAlpha <= 0 || c.AnomalyAlpha > 1 {
+		problems = append(problems, "anomaly-alpha debe estar en (0, 1]")
+	}
+	if c.AnomalyZScore <= 0 {
+		problems = append(problems, "anomaly-zscore debe ser positivo")
+	}
+	if c.LeakWindow < 3 {
+		problems = append(problems, "leak-window debe ser al menos 3")
+	}
+	if c.AlertRetries < 0 {
+		problems = append(problems, "alert-retries no puede ser negativo")
+	}
+	if c.AlertTimeout <= 0 {
+		problems = append(problems, "alert-timeout debe ser positivo")
+	}
+	if c.ProcessTopN < 0 {
+		problems = append(problems, "process-top-n no puede ser negativo")
+	}
+	if c.DockerSocket == "" {
+		problems = append(problems, "docker-socket es obligatorio")
+	}
+	if c.DBPath == "" {
+		problems = append(problems, "db-path es obligatorio")
+	}
+	for name, spec := range map[string]string{
+		"create-schedule":    c.CreateSchedule,
+		"retention-schedule": c.RetentionSchedule,
+		"report-schedule":    c.ReportSchedule,
+	} {
+		if spec == "" {
+			continue
+		}
+		if _, err := ParseSchedule(spec); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if c.RetentionSchedule != "" {
+		// Los rollups por hora se calculan desde los datos crudos
+		if c.RetentionRaw < 2*time.Hour {
+			problems = append(problems, "retention-raw debe ser al menos 2h")
+		}
+		if c.Retention1m < c.RetentionRaw {
+			problems = append(problems, "retention-1m no puede ser menor que retention-raw")
+		}
+		if c.Retention1h < c.Retention1m {
+			problems = append(problems, "retention-1h no puede ser menor que retention-1m")
+		}
+	}
+	if c.PolicyPath != "" {
+		if _, err := os.Stat(c.PolicyPath); err != nil {
+			problems = append(problems, fmt.Sprintf("policy: %v", err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("configuración inválida:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// isLoopbackAddr indica si host:port solo escucha en la interfaz de loopback.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// applyReload copia a c los campos recargables de next y devuelve los nombres
+// de los campos no recargables que cambiaron.
+func (c *DaemonConfig) applyReload(next *DaemonConfig) (changed, ignored []string) {
+	for _, field := range configFields {
+		current := fmt.Sprint(deref(field.bind(c)))
+		updated := fmt.Sprint(deref(field.bind(next)))
+		if current == updated {
+			continue
+		}
+
+		if !field.reload {
+			ignored = append(ignored, field.name)
+			continue
+		}
+		setConfigValue(field.bind(c), updated)
+		changed = append(changed, fmt.Sprintf("%s=%s", field.name, updated))
+	}
+	return changed, ignored
+}
+
+func deref(target interface{}) interface{} {
+	switch p := target.(type) {
+	case *string:
+		return *p
+	case *bool:
+		return *p
+	case *int:
+		return *p
+	case *int64:
+		return *p
+	case *float64:
+		return *p
+	case *time.Duration:
+		return *p
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestAPIAddrMustBeLoopback(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "daemon.db")
+	cases := map[string]bool{
+		"127.0.0.1:8081": true,
+		"[::1]:8081":     true,
+		"localhost:8081": true,
+		"0.0.0.0:8081":   false,
+		":8081":          false,
+		"10.0.0.5:8081":  false,
+	}
+	for addr, ok := range cases {
+		_, err := LoadConfig([]string{"-db-path", dbPath, "-api-addr", addr})
+		if ok && err != nil {
+			t.Errorf("api-addr %s rechazada: %v", addr, err)
+		}
+		if !ok && err == nil {
+			t.Errorf("api-addr %s aceptada fuera de loopback", addr)
+		}
+	}
+
+	// grafana-addr no tiene autenticación: por defecto no escucha en todas las
+	// interfaces, aunque se puede pedir explícitamente
+	if host, _, _ := net.SplitHostPort(defaultConfig().GrafanaAddr); host == "" || net.ParseIP(host).IsUnspecified() {
+		t.Errorf("grafana-addr por defecto %q escucha en todas las interfaces", defaultConfig().GrafanaAddr)
+	}
+	if _, err := LoadConfig([]string{"-db-path", dbPath, "-grafana-addr", "0.0.0.0:8082"}); err != nil {
+		t.Errorf("grafana-addr 0.0.0.0:8082 rechazada: %v", err)
+	}
+	if _, err := LoadConfig([]string{"-db-path", dbPath, "-grafana-addr", "127.0.0.1:8081"}); err == nil {
+		t.Error("grafana-addr igual a api-addr aceptada")
+	}
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errores tipados del cliente de Docker. Se comparan con errors.Is.
+var (
+	ErrDockerUnavailable = errors.New("docker: engine no disponible")
+	ErrNotFound          = errors.New("docker: recurso no encontrado")
+	ErrNotModified       = errors.New("docker: sin cambios")
+	ErrConflict          = errors.New("docker: conflicto")
+)
+
+// DockerError representa una respuesta de error del Engine API.
+type DockerError struct {
+	Op         string
+	StatusCode int
+	Message    string
+}
+
+func (e *DockerError) Error() string {
+	return fmt.Sprintf("docker %s: %d %s", e.Op, e.StatusCode, e.Message)
+}
+
+// Is permite usar errors.Is(err, ErrNotFound) y similares.
+func (e *DockerError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrNotModified:
+		return e.StatusCode == http.StatusNotModified
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	}
+	return false
+}
+
+// DockerClient habla con el Engine API a través del socket unix.
+type DockerClient struct {
+	socketPath string
+	http       *http.Client
+}
+
+// ContainerSummary es una entrada de GET /containers/json.
+type ContainerSummary struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	State  string            `json:"State"`
+	Status string            `json:"Status"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// ContainerState es el estado devuelto por GET /containers/{id}/json.
+type ContainerState struct {
+	Status    string `json:"Status"`
+	Running   bool   `json:"Running"`
+	Paused    bool   `json:"Paused"`
+	OOMKilled bool   `json:"OOMKilled"`
+	Pid       int    `json:"Pid"`
+	ExitCode  int    `json:"ExitCode"`
+}
+
+// ContainerDetails es el subconjunto de GET /containers/{id}/json que usa el daemon.
+type ContainerDetails struct {
+	ID     string         `json:"Id"`
+	Name   string         `json:"Name"`
+	Image  string         `json:"Image"`
+	State  ContainerState `json:"State"`
+	Config struct {
+		Image  string            `json:"Image"`
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+}
+
+// PortBinding es un mapeo de puerto del host.
+type PortBinding struct {
+	HostIP   string `json:"HostIp,omitempty"`
+	HostPort string `json:"HostPort"`
+}
+
+// RestartPolicy es la política de reinicio de un contenedor.
+type RestartPolicy struct {
+	Name string `json:"Name"`
+}
+
+// HostConfig es el subconjunto de HostConfig que usa el daemon.
+type HostConfig struct {
+	Binds         []string                 `json:"Binds,omitempty"`
+	PortBindings  map[string][]PortBinding `json:"PortBindings,omitempty"`
+	RestartPolicy RestartPolicy            `json:"RestartPolicy,omitempty"`
+	ExtraHosts    []string                 `json:"ExtraHosts,omitempty"`
+}
+
+// ContainerConfig es el cuerpo de POST /containers/create.
+type ContainerConfig struct {
+	Image        string              `json:"Image"`
+	Env          []string            `json:"Env,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	HostConfig   HostConfig          `json:"HostConfig"`
+}
+
+// ContainerUpdate es el cuerpo de POST /containers/{id}/update; los campos en
+// cero no se modifican.
+type ContainerUpdate struct {
+	Memory     int64 `json:"Memory,omitempty"`
+	MemorySwap int64 `json:"MemorySwap,omitempty"`
+	CPUPeriod  int64 `json:"CpuPeriod,omitempty"`
+	CPUQuota   int64 `json:"CpuQuota,omitempty"`
+}
+
+// Event es un mensaje de GET /events.
+type Event struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Actor  struct {
+		ID         string            `json:"ID"`
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+	Time     int64 `json:"time"`
+	TimeNano int64 `json:"timeNano"`
+}
+
+// NewDockerClient crea un cliente para el socket indicado (p. ej. /var/run/docker.sock).
+func NewDockerClient(socketPath string) *DockerClient {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+		MaxIdleConns:    4,
+		IdleConnTimeout: 30 * time.Second,
+	}
+
+	return &DockerClient{
+		socketPath: socketPath,
+		http:       &http.Client{Transport: transport},
+	}
+}
+
+// Ping verifica que el engine responde.
+func (c *DockerClient) Ping(ctx context.Context) error {
+	return c.do(ctx, "ping", http.MethodGet, "/_ping", nil, nil, nil)
+}
+
+// ListContainers lista contenedores; filters sigue el formato del Engine API.
+func (c *DockerClient) ListContainers(ctx context.Context, all bool, filters map[string][]string) ([]ContainerSummary, error) {
+	query := url.Values{}
+	if all {
+		query.Set("all", "1")
+	}
+	if len(filters) > 0 {
+		encoded, err := json.Marshal(filters)
+		if err != nil {
+			return nil, err
+		}
+		query.Set("filters", string(encoded))
+	}
+
+	var containers []ContainerSummary
+	if err := c.do(ctx, "list containers", http.MethodGet, "/containers/json", query, nil, &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+// InspectContainer devuelve el detalle de un contenedor.
+func (c *DockerClient) InspectContainer(ctx context.Context, id string) (*ContainerDetails, error) {
+	var details ContainerDetails
+	path := "/containers/" + url.PathEscape(id) + "/json"
+	if err := c.do(ctx, "inspect container", http.MethodGet, path, nil, nil, &details); err != nil {
+		return nil, err
+	}
+	return &details, nil
+}
+
+// StopContainer detiene un contenedor. Un contenedor ya detenido no es error.
+func (c *DockerClient) StopContainer(ctx context.Context, id string, timeout time.Duration) error {
+	query := url.Values{}
+	query.Set("t", strconv.Itoa(int(timeout.Seconds())))
+	path := "/containers/" + url.PathEscape(id) + "/stop"
+
+	err := c.do(ctx, "stop container", http.MethodPost, path, query, nil, nil)
+	if errors.Is(err, ErrNotModified) {
+		return nil
+	}
+	return err
+}
+
+// RemoveContainer elimina un contenedor.
+func (c *DockerClient) RemoveContainer(ctx context.Context, id string, force bool) error {
+	query := url.Values{}
+	if force {
+		query.Set("force", "1")
+	}
+	path := "/containers/" + url.PathEscape(id)
+	return c.do(ctx, "remove container", http.MethodDelete, path, query, nil, nil)
+}
+
+// CreateContainer crea un contenedor y devuelve su ID.
+func (c *DockerClient) CreateContainer(ctx context.Context, name string, config *ContainerConfig) (string, error) {
+	query := url.Values{}
+	if name != "" {
+		query.Set("name", name)
+	}
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := c.do(ctx, "create container", http.MethodPost, "/containers/create", query, config, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// StartContainer inicia un contenedor. Un contenedor ya iniciado no es error.
+func (c *DockerClient) StartContainer(ctx context.Context, id string) error {
+	path := "/containers/" + url.PathEscape(id) + "/start"
+	err := c.do(ctx, "start container", http.MethodPost, path, nil, nil, nil)
+	if errors.Is(err, ErrNotModified) {
+		return nil
+	}
+	return err
+}
+
+// PauseContainer congela los procesos del contenedor. Uno ya pausado no es error.
+func (c *DockerClient) PauseContainer(ctx context.Context, id string) error {
+	path := "/containers/" + url.PathEscape(id) + "/pause"
+	err := c.do(ctx, "pause container", http.MethodPost, path, nil, nil, nil)
+	if errors.Is(err, ErrConflict) {
+		return nil
+	}
+	return err
+}
+
+// UnpauseContainer reanuda un contenedor pausado. Uno no pausado no es error.
+func (c *DockerClient) UnpauseContainer(ctx context.Context, id string) error {
+	path := "/containers/" + url.PathEscape(id) + "/unpause"
+	err := c.do(ctx, "unpause container", http.MethodPost, path, nil, nil, nil)
+	if errors.Is(err, ErrConflict) {
+		return nil
+	}
+	return err
+}
+
+// UpdateContainer cambia los límites de recursos de un contenedor en ejecución.
+func (c *DockerClient) UpdateContainer(ctx context.Context, id string, update *ContainerUpdate) error {
+	path := "/containers/" + url.PathEscape(id) + "/update"
+	return c.do(ctx, "update container", http.MethodPost, path, nil, update, nil)
+}
+
+// StreamEvents se suscribe a /events desde since (cero: solo eventos nuevos) y
+// llama a handle con cada evento hasta que el flujo se corte o ctx termine.
+func (c *DockerClient) StreamEvents(ctx context.Context, since time.Time, filters map[string][]string, handle func(Event)) error {
+	query := url.Values{}
+	if !since.IsZero() {
+		query.Set("since", strconv.FormatInt(since.Unix(), 10))
+	}
+	if len(filters) > 0 {
+		encoded, err := json.Marshal(filters)
+		if err != nil {
+			return err
+		}
+		query.Set("filters", string(encoded))
+	}
+
+	// El flujo no tiene plazo; solo lo corta ctx
+	resp, err := c.request(ctx, "events", http.MethodGet, "/events", query, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var event Event
+		if err := decoder.Decode(&event); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err == io.EOF {
+				return fmt.Errorf("docker events: el engine cerró el flujo")
+			}
+			return fmt.Errorf("docker events: flujo inválido: %w", err)
+		}
+		handle(event)
+	}
+}
+
+// ImageExists indica si la imagen está disponible localmente.
+func (c *DockerClient) ImageExists(ctx context.Context, name string) (bool, error) {
+	path := "/images/" + name + "/json"
+	err := c.do(ctx, "inspect image", http.MethodGet, path, nil, nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PullImage descarga una imagen y espera a que termine.
+func (c *DockerClient) PullImage(ctx context.Context, image string) error {
+	name, tag := image, "latest"
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		name, tag = image[:i], image[i+1:]
+	}
+
+	query := url.Values{}
+	query.Set("fromImage", name)
+	query.Set("tag", tag)
+
+	resp, err := c.request(ctx, "pull image", http.MethodPost, "/images/create", query, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return readJSONStream("pull image", resp.Body)
+}
+
+// BuildImage construye una imagen a partir de un directorio con Dockerfile.
+func (c *DockerClient) BuildImage(ctx context.Context, dir, tag string) error {
+	buildContext, err := tarDirectory(dir)
+	if err != nil {
+		return fmt.Errorf("error empaquetando contexto %s: %w", dir, err)
+	}
+
+	query := url.Values{}
+	query.Set("t", tag)
+	query.Set("rm", "1")
+
+	resp, err := c.request(ctx, "build image", http.MethodPost, "/build", query, buildContext, "application/x-tar")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return readJSONStream("build image", resp.Body)
+}
+
+// do envía una petición JSON y decodifica la respuesta en out (si no es nil).
+func (c *DockerClient) do(ctx context.Context, op, method, path string, query url.Values, in, out interface{}) error {
+	var body io.Reader
+	contentType := ""
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(encoded)
+		contentType = "application/json"
+	}
+
+	resp, err := c.request(ctx, op, method, path, query, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("docker %s: respuesta inválida: %w", op, err)
+	}
+	return nil
+}
+
+// request envía la petición y convierte los códigos de error en DockerError.
+func (c *DockerClient) request(ctx context.Context, op, method, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	u := url.URL{Scheme: "http", Host: "docker", Path: path, RawQuery: query.Encode()}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w (%s): %v", ErrDockerUnavailable, c.socketPath, err)
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, &DockerError{Op: op, StatusCode: resp.StatusCode, Message: readErrorMessage(resp.Body)}
+	}
+
+	return resp, nil
+}
+
+// readErrorMessage extrae el campo "message" del cuerpo de error del engine.
+func readErrorMessage(r io.Reader) string {
+	data, _ := io.ReadAll(io.LimitReader(r, 64*1024))
+
+	var payload struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(data, &payload); err == nil && payload.Message != "" {
+		return payload.Message
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// readJSONStream consume un flujo de progreso (build/pull) y devuelve el primer error reportado.
+func readJSONStream(op string, r io.Reader) error {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var message struct {
+			Error       string `json:"error"`
+			ErrorDetail struct {
+				Message string `json:"message"`
+			} `json:"errorDetail"`
+		}
+		if err := decoder.Decode(&message); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("docker %s: flujo inválido: %w", op, err)
+		}
+
+		if message.Error != "" {
+			return &DockerError{Op: op, StatusCode: http.StatusOK, Message: message.Error}
+		}
+	}
+}
+
+// tarDirectory empaqueta un directorio como contexto de build.
+func tarDirectory(dir string) (io.Reader, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// containerName devuelve el nombre sin la barra inicial que agrega el engine.
+func containerName(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(names[0], "/")
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// fakeEngine sirve respuestas fijas del Engine API en un socket unix temporal
+// y guarda las peticiones recibidas.
+type fakeEngine struct {
+	t      *testing.T
+	socket string
+	server *httptest.Server
+
+	mu       sync.Mutex
+	requests []recordedRequest
+	routes   map[string]fakeResponse // "MÉTODO /ruta"
+}
+
+type recordedRequest struct {
+	method, path, query, contentType string
+	body                             []byte
+}
+
+type fakeResponse struct {
+	status int
+	body   string
+}
+
+func newFakeEngine(t *testing.T, routes map[string]fakeResponse) *fakeEngine {
+	t.Helper()
+
+	f := &fakeEngine{t: t, socket: filepath.Join(t.TempDir(), "docker.sock"), routes: routes}
+	listener, err := net.Listen("unix", f.socket)
+	if err != nil {
+		t.Fatalf("no se pudo escuchar en %s: %v", f.socket, err)
+	}
+
+	f.server = httptest.NewUnstartedServer(http.HandlerFunc(f.serve))
+	f.server.Listener.Close()
+	f.server.Listener = listener
+	f.server.Start()
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeEngine) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	f.requests = append(f.requests, recordedRequest{
+		method:      r.Method,
+		path:        r.URL.Path,
+		query:       r.URL.RawQuery,
+		contentType: r.Header.Get("Content-Type"),
+		body:        body,
+	})
+	resp, ok := f.routes[r.Method+" "+r.URL.Path]
+	f.mu.Unlock()
+
+	if !ok {
+		resp = fakeResponse{http.StatusNotFound, `{"message":"ruta no esperada"}`}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.status)
+	io.WriteString(w, resp.body)
+}
+
+// last devuelve la última petición recibida.
+func (f *fakeEngine) last() recordedRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.requests) == 0 {
+		f.t.Fatal("el engine no recibió peticiones")
+	}
+	return f.requests[len(f.requests)-1]
+}
+
+func TestDockerErrorsMapToTypedErrors(t *testing.T) {
+	engine := newFakeEngine(t, map[string]fakeResponse{
+		"GET /containers/missing/json":   {http.StatusNotFound, `{"message":"No such container: missing"}`},
+		"POST /containers/same/update":   {http.StatusNotModified, ``},
+		"DELETE /containers/running":     {http.StatusConflict, `{"message":"container is running"}`},
+		"POST /containers/started/start": {http.StatusNotModified, ``},
+	})
+	client := NewDockerClient(engine.socket)
+	ctx := context.Background()
+
+	_, err := client.InspectContainer(ctx, "missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("404: se esperaba ErrNotFound, se obtuvo %v", err)
+	}
+	var dockerErr *DockerError
+	if !errors.As(err, &dockerErr) || dockerErr.Message != "No such container: missing" {
+		t.Errorf("404: mensaje del engine perdido: %v", err)
+	}
+
+	err = client.UpdateContainer(ctx, "same", &ContainerUpdate{Memory: 1})
+	if !errors.Is(err, ErrNotModified) {
+		t.Errorf("304: se esperaba ErrNotModified, se obtuvo %v", err)
+	}
+
+	err = client.RemoveContainer(ctx, "running", false)
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("409: se esperaba ErrConflict, se obtuvo %v", err)
+	}
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrDockerUnavailable) {
+		t.Errorf("409 no debe coincidir con otros errores tipados: %v", err)
+	}
+
+	// StartContainer trata el 304 (ya iniciado) como éxito
+	if err := client.StartContainer(ctx, "started"); err != nil {
+		t.Errorf("start de un contenedor ya iniciado: %v", err)
+	}
+}
+
+func TestDockerConnectionRefused(t *testing.T) {
+	// Un socket que nadie escucha
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+
+	client := NewDockerClient(socket)
+	err = client.Ping(context.Background())
+	if !errors.Is(err, ErrDockerUnavailable) {
+		t.Fatalf("se esperaba ErrDockerUnavailable, se obtuvo %v", err)
+	}
+
+	_, err = NewDockerClient(filepath.Join(t.TempDir(), "no-existe.sock")).ListContainers(context.Background(), false, nil)
+	if !errors.Is(err, ErrDockerUnavailable) {
+		t.Fatalf("socket inexistente: se esperaba ErrDockerUnavailable, se obtuvo %v", err)
+	}
+}
+
+func TestDockerRequestPathsAndBodies(t *testing.T) {
+	engine := newFakeEngine(t, map[string]fakeResponse{
+		"GET /containers/json": {http.StatusOK,
+			`[{"Id":"abc123","Names":["/web-1"],"Image":"nginx","State":"running","Labels":{"monitor.class":"low"}}]`},
+		"GET /containers/abc123/json": {http.StatusOK,
+			`{"Id":"abc123","Name":"/web-1","State":{"Status":"running","Running":true,"Pid":4242}}`},
+		"POST /containers/create":        {http.StatusCreated, `{"Id":"new456","Warnings":[]}`},
+		"POST /containers/new456/start":  {http.StatusNoContent, ``},
+		"POST /containers/abc123/update": {http.StatusOK, `{"Warnings":[]}`},
+	})
+	client := NewDockerClient(engine.socket)
+	ctx := context.Background()
+
+	// List: all y filters van en la query
+	containers, err := client.ListContainers(ctx, true, map[string][]string{"name": {"web"}})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(containers) != 1 || containers[0].ID != "abc123" || containers[0].Labels["monitor.class"] != "low" {
+		t.Errorf("list: respuesta mal decodificada: %+v", containers)
+	}
+	req := engine.last()
+	if req.method != http.MethodGet || req.path != "/containers/json" {
+		t.Errorf("list: petición %s %s", req.method, req.path)
+	}
+	if want := "all=1&filters=%7B%22name%22%3A%5B%22web%22%5D%7D"; req.query != want {
+		t.Errorf("list: query %q, se esperaba %q", req.query, want)
+	}
+
+	// Inspect
+	details, err := client.InspectContainer(ctx, "abc123")
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if details.State.Pid != 4242 || !details.State.Running {
+		t.Errorf("inspect: estado mal decodificado: %+v", details.State)
+	}
+	if req := engine.last(); req.method != http.MethodGet || req.path != "/containers/abc123/json" {
+		t.Errorf("inspect: petición %s %s", req.method, req.path)
+	}
+
+	// Create: el nombre va en la query y la configuración en el cuerpo
+	id, err := client.CreateContainer(ctx, "worker-1", &ContainerConfig{
+		Image:      "alpine",
+		Cmd:        []string{"sleep", "60"},
+		Labels:     map[string]string{"monitor.class": "high"},
+		HostConfig: HostConfig{RestartPolicy: RestartPolicy{Name: "no"}},
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if id != "new456" {
+		t.Errorf("create: ID %q", id)
+	}
+	req = engine.last()
+	if req.method != http.MethodPost || req.path != "/containers/create" || req.query != "name=worker-1" {
+		t.Errorf("create: petición %s %s?%s", req.method, req.path, req.query)
+	}
+	if req.contentType != "application/json" {
+		t.Errorf("create: Content-Type %q", req.contentType)
+	}
+	var sent ContainerConfig
+	if err := json.Unmarshal(req.body, &sent); err != nil {
+		t.Fatalf("create: cuerpo inválido %q: %v", req.body, err)
+	}
+	if sent.Image != "alpine" || len(sent.Cmd) != 2 || sent.Labels["monitor.class"] != "high" || sent.HostConfig.RestartPolicy.Name != "no" {
+		t.Errorf("create: cuerpo enviado %+v", sent)
+	}
+
+	// Start: sin cuerpo
+	if err := client.StartContainer(ctx, "new456"); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	req = engine.last()
+	if req.method != http.MethodPost || req.path != "/containers/new456/start" || len(req.body) != 0 {
+		t.Errorf("start: petición %s %s con cuerpo %q", req.method, req.path, req.body)
+	}
+
+	// Update: solo los campos definidos
+	err = client.UpdateContainer(ctx, "abc123", &ContainerUpdate{Memory: 64 << 20, CPUPeriod: 100000, CPUQuota: 20000})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	req = engine.last()
+	if req.method != http.MethodPost || req.path != "/containers/abc123/update" {
+		t.Errorf("update: petición %s %s", req.method, req.path)
+	}
+	var update map[string]int64
+	if err := json.Unmarshal(req.body, &update); err != nil {
+		t.Fatalf("update: cuerpo inválido %q: %v", req.body, err)
+	}
+	want := map[string]int64{"Memory": 64 << 20, "CpuPeriod": 100000, "CpuQuota": 20000}
+	if len(update) != len(want) {
+		t.Errorf("update: cuerpo %v, se esperaba %v", update, want)
+	}
+	for key, value := range want {
+		if update[key] != value {
+			t.Errorf("update: %s = %d, se esperaba %d", key, update[key], value)
+		}
+	}
+}
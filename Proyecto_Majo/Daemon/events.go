@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Razones de fin que solo conoce el flujo de eventos.
+const (
+	endReasonExited = "exited"
+	endReasonOOM    = "oom"
+	endReasonSignal = "signal"
+)
+
+const (
+	eventsMinBackoff = time.Second
+	eventsMaxBackoff = 30 * time.Second
+)
+
+// containerEventFilters limita el flujo a los eventos que el daemon procesa.
+var containerEventFilters = map[string][]string{
+	"type":  {"container"},
+	"event": {"start", "die", "oom", "kill"},
+}
+
+// startEvents suscribe al daemon al flujo de eventos del engine. Los eventos
+// se entregan al loop principal por d.events, que es el único que toca el
+// tracker de ciclos de vida.
+func (d *Daemon) startEvents(ctx context.Context) {
+	if !d.config.DockerEvents {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.watchEvents(ctx)
+	}()
+
+	d.onShutdown("eventos de Docker", func(shutdownCtx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-shutdownCtx.Done():
+			return shutdownCtx.Err()
+		}
+	})
+	log.Println("Suscrito a eventos de contenedores del engine")
+}
+
+// watchEvents mantiene la suscripción: si el flujo se corta reconecta con
+// backoff exponencial y pide desde el último evento recibido para no perder
+// los ocurridos durante la desconexión.
+func (d *Daemon) watchEvents(ctx context.Context) {
+	var since time.Time
+	var lastNano int64
+	backoff := eventsMinBackoff
+	failing := false
+
+	for {
+		connected := time.Now()
+		err := d.docker.StreamEvents(ctx, since, containerEventFilters, func(ev Event) {
+			// since tiene resolución de segundos: se descartan los repetidos
+			if ev.TimeNano != 0 && ev.TimeNano <= lastNano {
+				return
+			}
+			lastNano = ev.TimeNano
+			since = time.Unix(ev.Time, 0)
+
+			if failing {
+				log.Println("Flujo de eventos de Docker restablecido")
+				failing = false
+			}
+			select {
+			case d.events <- ev:
+			case <-ctx.Done():
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Una conexión que duró más que el backoff máximo se considera sana
+		if time.Since(connected) > eventsMaxBackoff {
+			backoff = eventsMinBackoff
+		}
+		if since.IsZero() {
+			since = connected
+		}
+
+		// Solo se registra el primer fallo de una racha, para no llenar el log
+		if !failing || !errors.Is(err, ErrDockerUnavailable) {
+			log.Printf("Flujo de eventos de Docker interrumpido: %v (reintento en %v)", err, backoff)
+		}
+		failing = true
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > eventsMaxBackoff {
+			backoff = eventsMaxBackoff
+		}
+	}
+}
+
+// handleContainerEvent actualiza los ciclos de vida con un evento del engine.
+// Solo lo llama el loop principal.
+func (d *Daemon) handleContainerEvent(ctx context.Context, ev Event) {
+	id := ev.Actor.ID
+	name := ev.Actor.Attributes["name"]
+	at := time.Unix(0, ev.TimeNano)
+	if ev.TimeNano == 0 {
+		at = time.Unix(ev.Time, 0)
+	}
+
+	switch ev.Action {
+	case "start":
+		log.Printf("Evento: contenedor %s (%.12s) iniciado", name, id)
+		d.lifecycle.started(id, name, at)
+		// Revisar la política sin esperar al próximo intervalo
+		d.state.requestIteration()
+
+	case "oom":
+		log.Printf("Evento: contenedor %s (%.12s) sin memoria (OOM)", name, id)
+		d.lifecycle.markEndingByID(id, endReasonOOM, true)
+		d.recordAction("OOM", Container{Name: name, ContainerID: id}, ev.Actor.Attributes["image"],
+			"El kernel eliminó un proceso por falta de memoria")
+
+	case "kill":
+		// Una señal no implica que el contenedor termine; solo se anota por si termina
+		d.lifecycle.markEndingByID(id, endReasonSignal+":"+ev.Actor.Attributes["signal"], false)
+
+	case "die":
+		exitCode := ev.Actor.Attributes["exitCode"]
+		log.Printf("Evento: contenedor %s (%.12s) terminó con código %s", name, id, exitCode)
+		d.recordAction("EXITED", Container{Name: name, ContainerID: id}, ev.Actor.Attributes["image"],
+			fmt.Sprintf("Código de salida %s", exitCode))
+		delete(d.throttles, id)
+		d.state.forgetContainer(id)
+		if !d.lifecycle.end(id, endReasonExited+":"+exitCode, at) {
+			return
+		}
+
+	default:
+		return
+	}
+
+	if err := d.storeLifecycles(ctx); err != nil {
+		log.Printf("Error guardando ciclos de vida tras evento %s: %v", ev.Action, err)
+	}
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Endpoints del protocolo SimpleJSON / JSON API de Grafana, montados en
+// /grafana/ del listener de grafana-addr (ver startGrafanaAPI), separado de
+// la API de control: Grafana consulta al daemon en lugar de leer una copia de
+// monitoring.db.
+
+// grafanaSeries describe una métrica: la expresión sobre la tabla cruda y
+// sobre los agregados (que guardan promedios por bucket).
+type grafanaSeries struct {
+	raw, rollup string
+	container   bool // una serie por contenedor
+}
+
+var grafanaTargets = map[string]grafanaSeries{
+	"system.memory_total_kb":     {raw: "total_memory_kb", rollup: "total_memory_kb"},
+	"system.memory_used_kb":      {raw: "used_memory_kb", rollup: "used_memory_avg_kb"},
+	"system.memory_free_kb":      {raw: "free_memory_kb", rollup: "free_memory_avg_kb"},
+	"system.memory_used_percent": {raw: "used_memory_kb * 100.0 / total_memory_kb", rollup: "used_memory_avg_kb * 100.0 / total_memory_kb"},
+	"system.processes_total":     {raw: "total_processes", rollup: "total_processes_avg"},
+	"system.processes_running":   {raw: "running_processes", rollup: "running_processes_avg"},
+	"container.rss_kb":           {raw: "COALESCE(tree_rss_kb, rss_kb)", rollup: "rss_avg_kb", container: true},
+	"container.cpu_percent":      {raw: "COALESCE(tree_cpu_percent, cpu_percent)", rollup: "cpu_avg", container: true},
+}
+
+// grafanaCounts son series de conteo de acciones por intervalo.
+var grafanaCounts = map[string]string{
+	"daemon.kills":   "KILLED",
+	"daemon.creates": "CREATED",
+}
+
+// grafanaActionsTable es el target que devuelve las acciones como tabla.
+const grafanaActionsTable = "actions"
+
+// grafanaAlertsAnnotation pide los periodos de alertas en lugar de acciones.
+const grafanaAlertsAnnotation = "alerts"
+
+type grafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type grafanaQueryRequest struct {
+	Range         grafanaRange `json:"range"`
+	IntervalMS    int64        `json:"intervalMs"`
+	MaxDataPoints int64        `json:"maxDataPoints"`
+	Targets       []struct {
+		Target string `json:"target"`
+		RefID  string `json:"refId"`
+		Type   string `json:"type"`
+	} `json:"targets"`
+}
+
+type grafanaTimeSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"` // [valor, epoch en ms]
+}
+
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type grafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+type grafanaAnnotationRequest struct {
+	Range      grafanaRange    `json:"range"`
+	Annotation json.RawMessage `json:"annotation"`
+}
+
+type grafanaAnnotation struct {
+	Annotation json.RawMessage `json:"annotation"`
+	Time       int64           `json:"time"`
+	TimeEnd    int64           `json:"timeEnd,omitempty"`
+	Title      string          `json:"title"`
+	Text       string          `json:"text"`
+	Tags       []string        `json:"tags"`
+}
+
+func (d *Daemon) grafanaHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", d.handleGrafanaTest)
+	mux.HandleFunc("/search", d.handleGrafanaSearch)
+	mux.HandleFunc("/query", d.handleGrafanaQuery)
+	mux.HandleFunc("/annotations", d.handleGrafanaAnnotations)
+
+	return mux
+}
+
+// handleGrafanaTest responde al "Save & test" del datasource.
+func (d *Daemon) handleGrafanaTest(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleGrafanaSearch lista los targets que contienen el texto buscado.
+func (d *Daemon) handleGrafanaSearch(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req struct {
+		Target string `json:"target"`
+	}
+	// Algunas versiones del plugin envían el cuerpo vacío
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "JSON inválido: "+err.Error())
+		return
+	}
+
+	targets := []string{grafanaActionsTable}
+	for name := range grafanaTargets {
+		targets = append(targets, name)
+	}
+	for name := range grafanaCounts {
+		targets = append(targets, name)
+	}
+	sort.Strings(targets)
+
+	matched := []string{}
+	for _, target := range targets {
+		if strings.Contains(target, req.Target) {
+			matched = append(matched, target)
+		}
+	}
+	writeJSON(w, http.StatusOK, matched)
+}
+
+func (d *Daemon) handleGrafanaQuery(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req grafanaQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "JSON inválido: "+err.Error())
+		return
+	}
+	if !req.Range.To.After(req.Range.From) {
+		writeError(w, http.StatusBadRequest, "range inválido")
+		return
+	}
+
+	results := []interface{}{}
+	for _, t := range req.Targets {
+		if t.Target == "" {
+			continue
+		}
+
+		var result []interface{}
+		var err error
+		if t.Target == grafanaActionsTable || t.Type == "table" {
+			var table *grafanaTable
+			table, err = d.grafanaActions(r.Context(), req.Range)
+			result = []interface{}{table}
+		} else {
+			var series []grafanaTimeSeries
+			series, err = d.grafanaQuerySeries(r.Context(), t.Target, req)
+			for _, s := range series {
+				result = append(result, s)
+			}
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("%s: %v", t.Target, err))
+			return
+		}
+		results = append(results, result...)
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// grafanaStep elige la tabla y el tamaño del intervalo: la cruda mientras
+// cubra el inicio del rango, si no los agregados por minuto o por hora.
+// El paso nunca es menor que el bucket ni genera más de maxDataPoints puntos.
+func (d *Daemon) grafanaStep(req grafanaQueryRequest) (suffix string, step int64) {
+	now := time.Now()
+	interval := time.Duration(req.IntervalMS) * time.Millisecond
+
+	var bucket time.Duration
+	switch {
+	case !req.Range.From.Before(now.Add(-d.config.RetentionRaw)) && interval < time.Minute:
+		suffix, bucket = "", time.Second
+	case !req.Range.From.Before(now.Add(-d.config.Retention1m)) && interval < time.Hour:
+		suffix, bucket = "_1m", time.Minute
+	default:
+		suffix, bucket = "_1h", time.Hour
+	}
+	if interval < bucket {
+		interval = bucket
+	}
+
+	if req.MaxDataPoints > 0 {
+		if minimum := req.Range.To.Sub(req.Range.From) / time.Duration(req.MaxDataPoints); interval < minimum {
+			interval = minimum
+		}
+	}
+	step = int64((interval + time.Second - 1) / time.Second)
+	return suffix, step
+}
+
+// grafanaQuerySeries resuelve un target de serie temporal. Los de contenedor
+// aceptan un filtro por nombre: "container.rss_kb:web".
+func (d *Daemon) grafanaQuerySeries(ctx context.Context, target string, req grafanaQueryRequest) ([]grafanaTimeSeries, error) {
+	name, container, _ := strings.Cut(target, ":")
+	suffix, step := d.grafanaStep(req)
+	from := req.Range.From.UTC().Format(sqliteTimeFormat)
+	to := req.Range.To.UTC().Format(sqliteTimeFormat)
+
+	if action, ok := grafanaCounts[name]; ok {
+		return d.grafanaSeriesRows(ctx, name, false, `SELECT (CAST(strftime('%s', timestamp) AS INTEGER) / ?) * ? AS t, '', COUNT(*)
+			FROM container_actions WHERE action = ? AND timestamp >= ? AND timestamp <= ?
+			GROUP BY t ORDER BY t`, step, step, action, from, to)
+	}
+
+	series, ok := grafanaTargets[name]
+	if !ok {
+		return nil, fmt.Errorf("target desconocido")
+	}
+
+	// Con los agregados, el tramo posterior a su último bucket completo sale
+	// de la tabla cruda agrupada en buckets del mismo tamaño
+	tailFrom, bucket := from, int64(0)
+	if suffix != "" {
+		bucket = grafanaBucketSeconds[suffix]
+		processed, ok, err := d.rollupProcessedUntil(ctx, grafanaSource(series)+suffix)
+		if err != nil {
+			return nil, err
+		}
+		if ok && processed.Format(sqliteTimeFormat) > tailFrom {
+			tailFrom = processed.Format(sqliteTimeFormat)
+		}
+	}
+
+	if !series.container {
+		perBucket := `SELECT timestamp AS ts, ` + series.raw + ` AS value
+			FROM system_metrics WHERE timestamp >= ? AND timestamp <= ?`
+		args := []interface{}{step, step, from, to}
+		if suffix != "" {
+			perBucket = `SELECT bucket AS ts, ` + series.rollup + ` AS value
+				FROM system_metrics` + suffix + ` WHERE bucket >= ? AND bucket <= ?
+			UNION ALL
+			SELECT ` + grafanaBucketExpr("timestamp") + ` AS ts, AVG(` + series.raw + `) AS value
+				FROM system_metrics WHERE timestamp >= ? AND timestamp <= ?
+				GROUP BY ts`
+			args = append(args, bucket, bucket, tailFrom, to)
+		}
+		return d.grafanaSeriesRows(ctx, name, false, `SELECT (CAST(strftime('%s', ts) AS INTEGER) / ?) * ? AS t, '', AVG(value)
+			FROM (`+perBucket+`)
+			GROUP BY t ORDER BY t`, args...)
+	}
+
+	// Las dos tablas se reducen a lo mismo: un valor por contenedor y muestra
+	// (o bucket), sumado entre los contenedores con el mismo nombre y
+	// promediado en cada paso. La cruda suma las filas de un mismo contenedor
+	// en la muestra; el rollup ya guarda el promedio de esas sumas por
+	// container_key (ver rollupContainerWindow).
+	filter := ""
+	if container != "" {
+		filter = ` AND name = ?`
+	}
+	withFilter := func(args ...interface{}) []interface{} {
+		if container != "" {
+			args = append(args, container)
+		}
+		return args
+	}
+
+	rawSamples := `SELECT timestamp AS ts, COALESCE(container_id, name || ':' || pid) AS container_key,
+			COALESCE(name, '') AS name, SUM(` + series.raw + `) AS value
+		FROM container_metrics WHERE timestamp >= ? AND timestamp <= ?` + filter + `
+		GROUP BY timestamp, container_key`
+
+	perSample := rawSamples
+	args := append([]interface{}{step, step}, withFilter(from, to)...)
+	if suffix != "" {
+		perSample = `SELECT bucket AS ts, container_key, COALESCE(name, '') AS name, ` + series.rollup + ` AS value
+			FROM container_metrics` + suffix + ` WHERE bucket >= ? AND bucket <= ?` + filter + `
+		UNION ALL
+		SELECT ` + grafanaBucketExpr("ts") + ` AS bucket_ts, container_key, name, AVG(value)
+			FROM (` + rawSamples + `)
+			GROUP BY bucket_ts, container_key`
+		args = append(args, bucket, bucket)
+		args = append(args, withFilter(tailFrom, to)...)
+	}
+
+	return d.grafanaSeriesRows(ctx, name, true, `SELECT (CAST(strftime('%s', ts) AS INTEGER) / ?) * ? AS t, name, AVG(value)
+		FROM (SELECT ts, name, SUM(value) AS value FROM (`+perSample+`) GROUP BY ts, name)
+		GROUP BY t, name ORDER BY name, t`, args...)
+}
+
+// grafanaBucketSeconds es el tamaño del bucket de cada tabla de agregados.
+var grafanaBucketSeconds = map[string]int64{"_1m": 60, "_1h": 3600}
+
+// grafanaBucketExpr trunca column al inicio de su bucket, con el formato de
+// la columna bucket de los agregados; el tamaño va como parámetro.
+func grafanaBucketExpr(column string) string {
+	return `datetime((CAST(strftime('%s', ` + column + `) AS INTEGER) / ?) * ?, 'unixepoch')`
+}
+
+// grafanaSource es la tabla cruda de la que sale la serie.
+func grafanaSource(series grafanaSeries) string {
+	if series.container {
+		return "container_metrics"
+	}
+	return "system_metrics"
+}
+
+// grafanaSeriesRows lee filas (epoch, nombre, valor); con perContainer cada
+// nombre es una serie, si no todo va a la serie del target.
+func (d *Daemon) grafanaSeriesRows(ctx context.Context, target string, perContainer bool, query string, args ...interface{}) ([]grafanaTimeSeries, error) {
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	series := []grafanaTimeSeries{}
+	index := make(map[string]int)
+	for rows.Next() {
+		var epoch int64
+		var name string
+		var value *float64
+		if err := rows.Scan(&epoch, &name, &value); err != nil {
+			return nil, err
+		}
+		if value == nil {
+			continue
+		}
+
+		label := target
+		if perContainer {
+			label = name
+		}
+		i, ok := index[label]
+		if !ok {
+			i = len(series)
+			index[label] = i
+			series = append(series, grafanaTimeSeries{Target: label, Datapoints: [][2]float64{}})
+		}
+		series[i].Datapoints = append(series[i].Datapoints, [2]float64{*value, float64(epoch * 1000)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(series) == 0 && !perContainer {
+		series = append(series, grafanaTimeSeries{Target: target, Datapoints: [][2]float64{}})
+	}
+	return series, nil
+}
+
+// grafanaActions devuelve las acciones del rango como tabla.
+func (d *Daemon) grafanaActions(ctx context.Context, span grafanaRange) (*grafanaTable, error) {
+	rows, err := d.db.QueryContext(ctx, `SELECT CAST(strftime('%s', timestamp) AS INTEGER), action,
+			COALESCE(container_name, ''), COALESCE(container_id, ''), COALESCE(reason, '')
+		FROM container_actions WHERE timestamp >= ? AND timestamp <= ?
+		ORDER BY id DESC LIMIT 1000`,
+		span.From.UTC().Format(sqliteTimeFormat), span.To.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	table := &grafanaTable{
+		Type: "table",
+		Columns: []grafanaColumn{
+			{Text: "Time", Type: "time"},
+			{Text: "Action", Type: "string"},
+			{Text: "Container", Type: "string"},
+			{Text: "ID", Type: "string"},
+			{Text: "Reason", Type: "string"},
+		},
+		Rows: [][]interface{}{},
+	}
+	for rows.Next() {
+		var epoch int64
+		var action, name, id, reason string
+		if err := rows.Scan(&epoch, &action, &name, &id, &reason); err != nil {
+			return nil, err
+		}
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		table.Rows = append(table.Rows, []interface{}{epoch * 1000, action, name, id, reason})
+	}
+	return table, rows.Err()
+}
+
+// handleGrafanaAnnotations marca las acciones del rango. La consulta de la
+// anotación elige los tipos separados por coma (por defecto KILLED), o
+// "alerts" para los periodos en que hubo alertas activas.
+func (d *Daemon) handleGrafanaAnnotations(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req grafanaAnnotationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "JSON inválido: "+err.Error())
+		return
+	}
+	var annotation struct {
+		Query string `json:"query"`
+	}
+	if len(req.Annotation) > 0 {
+		if err := json.Unmarshal(req.Annotation, &annotation); err != nil {
+			writeError(w, http.StatusBadRequest, "annotation inválida: "+err.Error())
+			return
+		}
+	}
+
+	from := req.Range.From.UTC().Format(sqliteTimeFormat)
+	to := req.Range.To.UTC().Format(sqliteTimeFormat)
+
+	var query string
+	var args []interface{}
+	if strings.TrimSpace(annotation.Query) == grafanaAlertsAnnotation {
+		query = `SELECT CAST(strftime('%s', started_at) AS INTEGER), COALESCE(CAST(strftime('%s', resolved_at) AS INTEGER), 0),
+				rule, COALESCE(severity, ''), COALESCE(summary, '')
+			FROM alerts WHERE started_at <= ? AND (resolved_at IS NULL OR resolved_at >= ?)
+			ORDER BY started_at`
+		args = []interface{}{to, from}
+	} else {
+		var actions []string
+		for _, action := range strings.Split(annotation.Query, ",") {
+			if action = strings.ToUpper(strings.TrimSpace(action)); action != "" {
+				actions = append(actions, action)
+			}
+		}
+		// Una consulta vacía o sin acciones válidas (p. ej. ",") muestra las eliminaciones
+		if len(actions) == 0 {
+			actions = []string{"KILLED"}
+		}
+		query = `SELECT CAST(strftime('%s', timestamp) AS INTEGER), 0, action, COALESCE(container_name, ''), COALESCE(reason, '')
+			FROM container_actions
+			WHERE action IN (?` + strings.Repeat(`, ?`, len(actions)-1) + `) AND timestamp >= ? AND timestamp <= ?
+			ORDER BY id`
+		for _, action := range actions {
+			args = append(args, action)
+		}
+		args = append(args, from, to)
+	}
+
+	rows, err := d.db.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	annotations := []grafanaAnnotation{}
+	for rows.Next() {
+		var start, end int64
+		var kind, subject, text string
+		if err := rows.Scan(&start, &end, &kind, &subject, &text); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		annotations = append(annotations, grafanaAnnotation{
+			Annotation: req.Annotation,
+			Time:       start * 1000,
+			TimeEnd:    end * 1000,
+			Title:      kind + " " + subject,
+			Text:       text,
+			Tags:       []string{kind, subject},
+		})
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, annotations)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postGrafana envía body al endpoint de /grafana y decodifica la respuesta en out.
+func postGrafana(t *testing.T, d *Daemon, path, body string, out interface{}) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	d.grafanaHandler().ServeHTTP(rec, req)
+	if rec.Code == http.StatusOK && out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s: respuesta inválida %q: %v", path, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func TestGrafanaAnnotationsWithoutValidActions(t *testing.T) {
+	d := newTestDaemon(t)
+	now := time.Now().UTC()
+	for _, action := range []string{"KILLED", "CREATED"} {
+		_, err := d.db.Exec(`INSERT INTO container_actions (timestamp, action, container_pid, container_name, reason) VALUES (?, ?, 10, 'stress', 'prueba')`,
+			now.Add(-time.Minute).Format(sqliteTimeFormat), action)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	from, to := now.Add(-time.Hour).Format(time.RFC3339), now.Add(time.Hour).Format(time.RFC3339)
+	for _, query := range []string{"", ",", " , ", "killed, ,"} {
+		body := `{"range":{"from":"` + from + `","to":"` + to + `"},"annotation":{"query":"` + query + `"}}`
+		var annotations []grafanaAnnotation
+		if code := postGrafana(t, d, "/annotations", body, &annotations); code != http.StatusOK {
+			t.Errorf("consulta %q: código %d", query, code)
+			continue
+		}
+		if len(annotations) != 1 || annotations[0].Tags[0] != "KILLED" {
+			t.Errorf("consulta %q: se esperaba solo la eliminación, se obtuvo %+v", query, annotations)
+		}
+	}
+}
+
+func TestGrafanaContainerSeriesMatchAcrossRetention(t *testing.T) {
+	d := newTestDaemon(t)
+	ctx := context.Background()
+
+	// Un contenedor con dos procesos reportados, tres muestras en un minuto
+	minute := time.Now().UTC().Truncate(time.Minute).Add(-10 * time.Minute)
+	id := strings.Repeat("ab", 32)
+	for i, offset := range []int{0, 20, 40} {
+		ts := minute.Add(time.Duration(offset) * time.Second).Format(sqliteTimeFormat)
+		for pid, rss := range map[int]int{10: 100 + 10*i, 11: 50} {
+			_, err := d.db.Exec(`INSERT INTO container_metrics (timestamp, pid, name, rss_kb, cpu_percent, container_id)
+				VALUES (?, ?, 'web', ?, 5, ?)`, ts, pid, rss, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	recordIteration(t, d, time.Now())
+	if err := d.rollup(ctx, rollups[0], time.Now()); err != nil {
+		t.Fatalf("rollup: %v", err)
+	}
+
+	query := func() grafanaTimeSeries {
+		t.Helper()
+		body := `{"range":{"from":"` + minute.Format(time.RFC3339) + `","to":"` + minute.Add(time.Minute).Format(time.RFC3339) +
+			`"},"intervalMs":1000,"maxDataPoints":1,"targets":[{"target":"container.rss_kb"}]}`
+		var series []grafanaTimeSeries
+		if code := postGrafana(t, d, "/query", body, &series); code != http.StatusOK {
+			t.Fatalf("query: código %d", code)
+		}
+		if len(series) != 1 || len(series[0].Datapoints) != 1 {
+			t.Fatalf("se esperaba un punto de una serie: %+v", series)
+		}
+		return series[0]
+	}
+
+	raw := query()
+	if suffix, _ := d.grafanaStep(grafanaQueryRequest{Range: grafanaRange{From: minute, To: minute.Add(time.Minute)}}); suffix != "" {
+		t.Fatalf("el rango debía leerse de la tabla cruda, se usó %s", suffix)
+	}
+
+	// Con la retención cruda vencida el mismo rango sale del rollup por minuto
+	d.config.RetentionRaw = time.Minute
+	rollup := query()
+
+	if raw.Target != "web" || rollup.Target != raw.Target {
+		t.Errorf("series %q y %q, se esperaba web en las dos", raw.Target, rollup.Target)
+	}
+	// Suma por muestra (150, 160, 170) promediada en el minuto
+	if raw.Datapoints[0][0] != 160 || rollup.Datapoints[0][0] != raw.Datapoints[0][0] {
+		t.Errorf("RSS crudo %v y del rollup %v, se esperaba 160 en los dos", raw.Datapoints[0][0], rollup.Datapoints[0][0])
+	}
+	if rollup.Datapoints[0][1] != raw.Datapoints[0][1] {
+		t.Errorf("tiempos distintos: %v y %v", raw.Datapoints[0][1], rollup.Datapoints[0][1])
+	}
+}
+
+func TestGrafanaRollupRangeIncludesRawTail(t *testing.T) {
+	d := newTestDaemon(t)
+	ctx := context.Background()
+
+	// Un minuto ya agregado y otro posterior que sólo está en la tabla cruda
+	first := time.Now().UTC().Truncate(time.Minute).Add(-10 * time.Minute)
+	tail := first.Add(5 * time.Minute)
+	insert := func(ts time.Time, rss, used int) {
+		t.Helper()
+		_, err := d.db.Exec(`INSERT INTO container_metrics (timestamp, pid, name, rss_kb, cpu_percent, container_id)
+			VALUES (?, 10, 'web', ?, 5, ?)`, ts.Format(sqliteTimeFormat), rss, strings.Repeat("ab", 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = d.db.Exec(`INSERT INTO system_metrics (timestamp, total_memory_kb, used_memory_kb, free_memory_kb,
+				total_processes, running_processes, sleeping_processes)
+			VALUES (?, 1000, ?, 0, 1, 1, 0)`, ts.Format(sqliteTimeFormat), used)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert(first, 100, 400)
+	insert(first.Add(30*time.Second), 200, 600)
+	insert(tail, 300, 700)
+	insert(tail.Add(30*time.Second), 500, 900)
+
+	recordIteration(t, d, first.Add(2*time.Minute))
+	for _, spec := range []rollupSpec{rollups[0], rollups[2]} {
+		if err := d.rollup(ctx, spec, first.Add(2*time.Minute)); err != nil {
+			t.Fatalf("rollup %s: %v", spec.table, err)
+		}
+	}
+
+	d.config.RetentionRaw = time.Minute
+	body := `{"range":{"from":"` + first.Format(time.RFC3339) + `","to":"` + tail.Add(time.Minute).Format(time.RFC3339) +
+		`"},"intervalMs":60000,"targets":[{"target":"container.rss_kb"},{"target":"system.memory_used_kb"}]}`
+	var series []grafanaTimeSeries
+	if code := postGrafana(t, d, "/query", body, &series); code != http.StatusOK {
+		t.Fatalf("query: código %d", code)
+	}
+	if len(series) != 2 {
+		t.Fatalf("se esperaban dos series: %+v", series)
+	}
+
+	want := map[string][2]float64{"web": {150, 400}, "system.memory_used_kb": {500, 800}}
+	for _, s := range series {
+		expected, ok := want[s.Target]
+		if !ok {
+			t.Errorf("serie inesperada %q", s.Target)
+			continue
+		}
+		if len(s.Datapoints) != 2 {
+			t.Errorf("%s: se esperaban el bucket agregado y el de la cola cruda: %v", s.Target, s.Datapoints)
+			continue
+		}
+		for i, point := range s.Datapoints {
+			if point[0] != expected[i] {
+				t.Errorf("%s: punto %d = %v, se esperaba %v", s.Target, i, point[0], expected[i])
+			}
+		}
+		if s.Datapoints[1][1] != float64(tail.Unix()*1000) {
+			t.Errorf("%s: el bucket de la cola está en %v, se esperaba %v", s.Target, s.Datapoints[1][1], tail.Unix()*1000)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// startScheduler registra las tareas periódicas del daemon y las inicia.
+func (d *Daemon) startScheduler(ctx context.Context) error {
+	d.scheduler = NewScheduler(d.db)
+
+	if err := d.scheduler.Add("create-containers", d.config.CreateSchedule, false, d.createContainersJob); err != nil {
+		return err
+	}
+
+	if d.config.RetentionSchedule != "" {
+		if err := ensureIncrementalVacuum(ctx, d.db); err != nil {
+			log.Printf("Error configurando auto_vacuum incremental: %v", err)
+		}
+		log.Printf("Retención: crudos %v, 1m %v, 1h %v", d.config.RetentionRaw, d.config.Retention1m, d.config.Retention1h)
+	}
+	if err := d.scheduler.Add("retention", d.config.RetentionSchedule, true, d.retentionJob); err != nil {
+		return err
+	}
+
+	if err := d.scheduler.Add("report", d.config.ReportSchedule, false, d.reportJob); err != nil {
+		return err
+	}
+
+	d.scheduler.Start(ctx)
+	d.onShutdown("tareas programadas", func(ctx context.Context) error {
+		return d.scheduler.Stop(ctx)
+	})
+	log.Printf("Tareas programadas: creación %q, retención %q, reporte %q",
+		d.config.CreateSchedule, d.config.RetentionSchedule, d.config.ReportSchedule)
+	return nil
+}
+
+// createContainersJob fuerza una iteración fuera del intervalo del loop, que
+// crea el déficit de cada clase con el perfil de carga.
+func (d *Daemon) createContainersJob(ctx context.Context, last time.Time) (string, error) {
+	if d.state.isPaused() {
+		return "omitida (enforcement pausado)", nil
+	}
+	if !d.state.requestIteration() {
+		return "ya había una iteración pendiente", nil
+	}
+	return "iteración solicitada", nil
+}
+
+func (d *Daemon) retentionJob(ctx context.Context, last time.Time) (string, error) {
+	return "", d.runRetention(ctx, time.Now().UTC())
+}
+
+// reportJob resume la actividad desde el reporte anterior (o la última hora).
+func (d *Daemon) reportJob(ctx context.Context, last time.Time) (string, error) {
+	since := last
+	if since.IsZero() {
+		since = time.Now().Add(-time.Hour)
+	}
+	from := since.UTC().Format(sqliteTimeFormat)
+
+	var iterations, failed, kills, creates int64
+	err := d.db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(SUM(errors IS NOT NULL), 0),
+			COALESCE(SUM(kills), 0), COALESCE(SUM(creates), 0)
+		FROM iterations WHERE started_at >= ?`, from).Scan(&iterations, &failed, &kills, &creates)
+	if err != nil {
+		return "", err
+	}
+
+	var avgUsed float64
+	var maxUsed, total int64
+	err = d.db.QueryRowContext(ctx, `SELECT COALESCE(AVG(used_memory_kb), 0), COALESCE(MAX(used_memory_kb), 0),
+			COALESCE(MAX(total_memory_kb), 0)
+		FROM system_metrics WHERE timestamp >= ?`, from).Scan(&avgUsed, &maxUsed, &total)
+	if err != nil {
+		return "", err
+	}
+
+	report := fmt.Sprintf("desde %s: %d iteraciones (%d con errores), %d eliminados, %d creados, memoria usada prom %.0f KB / máx %d KB",
+		since.Format("2006-01-02 15:04"), iterations, failed, kills, creates, avgUsed, maxUsed)
+	if total > 0 {
+		report += fmt.Sprintf(" (%.1f%% de %d KB)", float64(maxUsed)*100/float64(total), total)
+	}
+	return report, nil
+}
+
+// removeLegacyCronEntries quita del crontab las líneas que versiones anteriores
+// del daemon agregaban para el script de creación, incluso tras una caída.
+func (d *Daemon) removeLegacyCronEntries(ctx context.Context) error {
+	if _, err := exec.LookPath("crontab"); err != nil {
+		return nil
+	}
+
+	output, err := exec.CommandContext(ctx, "crontab", "-l").Output()
+	if err != nil {
+		// crontab -l falla si el usuario no tiene crontab
+		return nil
+	}
+
+	var kept []string
+	removed := 0
+	for _, line := range strings.Split(strings.TrimRight(string(output), "\n"), "\n") {
+		if strings.Contains(line, d.config.CreateContainersScript) && !strings.HasPrefix(strings.TrimSpace(line), "#") {
+			removed++
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if removed == 0 {
+		return nil
+	}
+
+	cmd := exec.CommandContext(ctx, "crontab", "-")
+	cmd.Stdin = bytes.NewBufferString(strings.Join(kept, "\n") + "\n")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("error reescribiendo crontab: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	log.Printf("Eliminadas %d entradas antiguas de crontab para %s", removed, d.config.CreateContainersScript)
+	return nil
+}
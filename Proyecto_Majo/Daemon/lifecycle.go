@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Razones de fin de un ciclo de vida en container_lifecycle.
+const (
+	endReasonKilled      = "killed"
+	endReasonDisappeared = "disappeared"
+)
+
+// classChange es una entrada del historial de clases de un contenedor.
+type classChange struct {
+	Class string    `json:"class"`
+	At    time.Time `json:"at"`
+}
+
+// containerLifecycle sigue a un contenedor desde que aparece hasta que desaparece.
+type containerLifecycle struct {
+	id          int64 // fila en container_lifecycle; 0 si todavía no se guardó
+	key         string
+	containerID string
+	name        string
+	pid         int
+	firstSeen   time.Time
+	lastSeen    time.Time
+	samples     int // iteraciones consecutivas en las que se vio; cero si lo abrió un evento start y el módulo todavía no lo reportó
+	peakRSS     int64
+	peakCPU     int
+	class       string // clase estable, la que usa el enforcement
+	history     []classChange
+
+	// Histéresis: clase candidata y cuántas muestras seguidas la confirmaron
+	pendingClass string
+	pendingCount int
+
+	endReason string // razón registrada antes de desaparecer (p. ej. killed)
+	endedAt   time.Time
+}
+
+// lifecycleTracker mantiene los ciclos de vida abiertos; solo lo usa el loop principal.
+type lifecycleTracker struct {
+	active map[string]*containerLifecycle
+	ended  []*containerLifecycle // terminados en esta iteración, pendientes de guardar
+}
+
+func newLifecycleTracker() *lifecycleTracker {
+	return &lifecycleTracker{active: make(map[string]*containerLifecycle)}
+}
+
+// lifecycleKey identifica al contenedor igual que los rollups: ID o nombre:pid.
+func lifecycleKey(c Container) string {
+	if c.ContainerID != "" {
+		return c.ContainerID
+	}
+	return c.Name + ":" + strconv.Itoa(c.PID)
+}
+
+// observe registra una muestra con la clase que asignó la política y devuelve
+// la clase estable: solo cambia tras `confirm` muestras seguidas de la nueva clase.
+// now identifica la iteración: una segunda muestra con el mismo now solo
+// actualiza los picos.
+func (t *lifecycleTracker) observe(c Container, class string, now time.Time, confirm int) string {
+	key := lifecycleKey(c)
+	lc, ok := t.active[key]
+	if !ok {
+		lc = &containerLifecycle{
+			key:         key,
+			containerID: c.ContainerID,
+			name:        c.Name,
+			pid:         c.PID,
+			firstSeen:   now,
+			class:       class,
+			history:     []classChange{{Class: class, At: now}},
+		}
+		t.active[key] = lc
+	}
+
+	if lc.samples == 0 && lc.class == "" && len(lc.history) == 0 {
+		// Primera muestra de un ciclo abierto por un evento start
+		lc.name, lc.pid, lc.class = c.Name, c.PID, class
+		lc.history = []classChange{{Class: class, At: now}}
+	}
+
+	tree := c.treeStats()
+	if tree.RSSKB > lc.peakRSS {
+		lc.peakRSS = tree.RSSKB
+	}
+	if tree.CPUPercent > lc.peakCPU {
+		lc.peakCPU = tree.CPUPercent
+	}
+
+	// Varias entradas del módulo pueden resolver al mismo contenedor: en la
+	// misma iteración solo cuenta la primera, para no acortar la gracia ni la
+	// histéresis
+	if lc.samples > 0 && lc.lastSeen.Equal(now) {
+		return lc.class
+	}
+	lc.lastSeen = now
+	lc.samples++
+
+	switch {
+	case class == lc.class:
+		lc.pendingClass, lc.pendingCount = "", 0
+	case class == lc.pendingClass:
+		lc.pendingCount++
+	default:
+		lc.pendingClass, lc.pendingCount = class, 1
+	}
+	if lc.pendingClass != "" && lc.pendingCount >= confirm {
+		log.Printf("Contenedor %s reclasificado: %s -> %s", c.Name, displayClass(lc.class), displayClass(lc.pendingClass))
+		lc.class = lc.pendingClass
+		lc.history = append(lc.history, classChange{Class: lc.class, At: now})
+		lc.pendingClass, lc.pendingCount = "", 0
+	}
+
+	return lc.class
+}
+
+// sweep cierra los ciclos de vida de los contenedores que no aparecieron en la muestra.
+func (t *lifecycleTracker) sweep(seen map[string]bool, now time.Time) {
+	for key, lc := range t.active {
+		// Los abiertos por eventos que el módulo aún no reportó los cierra el evento die
+		if seen[key] || lc.samples == 0 {
+			continue
+		}
+		if lc.endReason == "" {
+			lc.endReason = endReasonDisappeared
+		}
+		lc.endedAt = now
+		t.ended = append(t.ended, lc)
+		delete(t.active, key)
+	}
+}
+
+// samplesFor devuelve cuántas iteraciones seguidas se vio el contenedor.
+func (t *lifecycleTracker) samplesFor(c Container) int {
+	if lc, ok := t.active[lifecycleKey(c)]; ok {
+		return lc.samples
+	}
+	return 0
+}
+
+// markEnding anota la razón con la que se cerrará el ciclo cuando el contenedor desaparezca.
+func (t *lifecycleTracker) markEnding(c Container, reason string) {
+	if lc, ok := t.active[lifecycleKey(c)]; ok {
+		lc.endReason = reason
+	}
+}
+
+// started abre el ciclo de un contenedor recién iniciado según el engine.
+func (t *lifecycleTracker) started(id, name string, at time.Time) {
+	if _, ok := t.active[id]; ok {
+		return
+	}
+	t.active[id] = &containerLifecycle{
+		key:         id,
+		containerID: id,
+		name:        name,
+		firstSeen:   at,
+		lastSeen:    at,
+	}
+}
+
+// markEndingByID es markEnding por ID de Docker; sin overwrite conserva una
+// razón ya anotada (p. ej. killed por el propio daemon).
+func (t *lifecycleTracker) markEndingByID(id, reason string, overwrite bool) {
+	if lc, ok := t.active[id]; ok && (overwrite || lc.endReason == "") {
+		lc.endReason = reason
+	}
+}
+
+// end cierra el ciclo de un contenedor que terminó según el engine y devuelve
+// false si no se estaba siguiendo.
+func (t *lifecycleTracker) end(id, reason string, at time.Time) bool {
+	lc, ok := t.active[id]
+	if !ok {
+		return false
+	}
+	if lc.endReason == "" {
+		lc.endReason = reason
+	}
+	lc.endedAt = at
+	t.ended = append(t.ended, lc)
+	delete(t.active, id)
+	return true
+}
+
+func displayClass(class string) string {
+	if class == "" {
+		return "sin clase"
+	}
+	return class
+}
+
+// applyLifecycles pasa la clasificación de la política por la histéresis del
+// tracker y devuelve una clasificación con las clases estables.
+func (d *Daemon) applyLifecycles(ctx context.Context, raw *Classification) *Classification {
+	now := time.Now()
+	confirm := d.config.ReclassifySamples
+	seen := make(map[string]bool)
+
+	stable := &Classification{Classes: make([]ClassResult, len(raw.Classes))}
+	index := make(map[string]int, len(raw.Classes))
+	for i, class := range raw.Classes {
+		stable.Classes[i].Policy = class.Policy
+		index[class.Policy.Name] = i
+	}
+
+	place := func(c Container, class string) {
+		seen[lifecycleKey(c)] = true
+		i, ok := index[d.lifecycle.observe(c, class, now, confirm)]
+		if !ok {
+			// Sin clase, o la clase estable ya no existe en la política
+			stable.Unclassified = append(stable.Unclassified, c)
+			return
+		}
+		stable.Classes[i].Containers = append(stable.Classes[i].Containers, c)
+	}
+
+	for _, class := range raw.Classes {
+		for _, container := range class.Containers {
+			place(container, class.Policy.Name)
+		}
+	}
+	for _, container := range raw.Unclassified {
+		place(container, "")
+	}
+
+	d.lifecycle.sweep(seen, now)
+
+	for i := range stable.Classes {
+		sortVictims(stable.Classes[i].Containers, stable.Classes[i].Policy.VictimOrder)
+	}
+
+	if err := d.storeLifecycles(ctx); err != nil {
+		d.recordError("Error guardando ciclos de vida: %v", err)
+	}
+	return stable
+}
+
+// matureContainers devuelve la clase restringida a los contenedores que ya
+// cumplieron el periodo de gracia; solo esos cuentan para el enforcement.
+func (d *Daemon) matureContainers(class ClassResult) ClassResult {
+	mature := ClassResult{Policy: class.Policy}
+	for _, container := range class.Containers {
+		if d.lifecycle.samplesFor(container) >= d.config.GraceIterations {
+			mature.Containers = append(mature.Containers, container)
+		}
+	}
+	return mature
+}
+
+// storeLifecycles guarda en una transacción los ciclos abiertos y los que terminaron.
+func (d *Daemon) storeLifecycles(ctx context.Context) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.Prepare(`INSERT INTO container_lifecycle
+		(container_key, container_id, name, pid, first_seen, last_seen, samples,
+		 peak_rss_kb, peak_cpu_percent, class, class_history, ended_at, end_reason)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	update, err := tx.Prepare(`UPDATE container_lifecycle SET
+		last_seen = ?, samples = ?, peak_rss_kb = ?, peak_cpu_percent = ?,
+		class = ?, class_history = ?, ended_at = ?, end_reason = ?
+		WHERE id = ?`)
+	if err != nil {
+		return err
+	}
+	defer update.Close()
+
+	save := func(lc *containerLifecycle) error {
+		history, err := json.Marshal(lc.history)
+		if err != nil {
+			return err
+		}
+
+		var endedAt, endReason sql.NullString
+		if !lc.endedAt.IsZero() {
+			endedAt = sql.NullString{String: lc.endedAt.UTC().Format(sqliteTimeFormat), Valid: true}
+			endReason = sql.NullString{String: lc.endReason, Valid: true}
+		}
+
+		if lc.id != 0 {
+			_, err := update.Exec(lc.lastSeen.UTC().Format(sqliteTimeFormat), lc.samples, lc.peakRSS, lc.peakCPU,
+				nullString(lc.class), string(history), endedAt, endReason, lc.id)
+			return err
+		}
+
+		result, err := insert.Exec(lc.key, nullString(lc.containerID), lc.name, lc.pid,
+			lc.firstSeen.UTC().Format(sqliteTimeFormat), lc.lastSeen.UTC().Format(sqliteTimeFormat), lc.samples,
+			lc.peakRSS, lc.peakCPU, nullString(lc.class), string(history), endedAt, endReason)
+		if err != nil {
+			return err
+		}
+		lc.id, err = result.LastInsertId()
+		return err
+	}
+
+	for _, lc := range d.lifecycle.active {
+		if err := save(lc); err != nil {
+			return fmt.Errorf("contenedor %s: %w", lc.name, err)
+		}
+	}
+	for _, lc := range d.lifecycle.ended {
+		if err := save(lc); err != nil {
+			return fmt.Errorf("contenedor %s: %w", lc.name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	d.lifecycle.ended = nil
+	return nil
+}
+
+// loadLifecycles recupera los ciclos abiertos de una ejecución anterior, para
+// que reiniciar el daemon no reinicie el periodo de gracia.
+func (d *Daemon) loadLifecycles(ctx context.Context) error {
+	rows, err := d.db.QueryContext(ctx, `SELECT id, container_key, COALESCE(container_id, ''), COALESCE(name, ''),
+			COALESCE(pid, 0), first_seen, last_seen, samples, peak_rss_kb, peak_cpu_percent,
+			COALESCE(class, ''), COALESCE(class_history, '[]')
+		FROM container_lifecycle
+		WHERE ended_at IS NULL`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		lc := &containerLifecycle{}
+		var firstSeen, lastSeen, history string
+		if err := rows.Scan(&lc.id, &lc.key, &lc.containerID, &lc.name, &lc.pid, &firstSeen, &lastSeen,
+			&lc.samples, &lc.peakRSS, &lc.peakCPU, &lc.class, &history); err != nil {
+			return err
+		}
+
+		lc.firstSeen, _ = parseSQLiteTime(firstSeen)
+		lc.lastSeen, _ = parseSQLiteTime(lastSeen)
+		if err := json.Unmarshal([]byte(history), &lc.history); err != nil {
+			log.Printf("Historial de clases inválido para %s: %v", lc.key, err)
+		}
+		d.lifecycle.active[lc.key] = lc
+	}
+	return rows.Err()
+}
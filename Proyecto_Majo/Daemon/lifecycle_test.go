@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestObserveCountsOneSamplePerIteration(t *testing.T) {
+	tracker := newLifecycleTracker()
+	// Dos procesos reportados que resuelven al mismo contenedor
+	first := Container{PID: 100, Name: "web", ContainerID: "abc", RSSKB: 1000}
+	second := Container{PID: 101, Name: "web", ContainerID: "abc", RSSKB: 5000}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		now := start.Add(time.Duration(i) * time.Second)
+		tracker.observe(first, "low", now, 2)
+		tracker.observe(second, "low", now, 2)
+	}
+
+	if got := tracker.samplesFor(first); got != 3 {
+		t.Errorf("samples=%d tras 3 iteraciones, se esperaba 3", got)
+	}
+	if lc := tracker.active["abc"]; lc.peakRSS != 5000 {
+		t.Errorf("peakRSS=%d, la segunda entrada debe contar para los picos", lc.peakRSS)
+	}
+}
+
+func TestObserveHysteresisIgnoresRepeatsInAnIteration(t *testing.T) {
+	tracker := newLifecycleTracker()
+	a := Container{PID: 100, Name: "web", ContainerID: "abc"}
+	b := Container{PID: 101, Name: "web", ContainerID: "abc"}
+
+	start := time.Now()
+	tracker.observe(a, "low", start, 2)
+
+	// Una sola iteración con la clase nueva no alcanza aunque aparezca dos veces
+	next := start.Add(time.Second)
+	tracker.observe(a, "high", next, 2)
+	if class := tracker.observe(b, "high", next, 2); class != "low" {
+		t.Fatalf("clase %q tras una iteración, se esperaba low", class)
+	}
+
+	if class := tracker.observe(a, "high", next.Add(time.Second), 2); class != "high" {
+		t.Errorf("clase %q tras dos iteraciones, se esperaba high", class)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// instanceLock es el pidfile con flock que impide dos daemons sobre la misma
+// base. El kernel libera el flock cuando el proceso termina, así que el
+// archivo que deja una instancia caída no bloquea a la siguiente.
+type instanceLock struct {
+	path string
+	file *os.File
+}
+
+// errLocked indica que otra instancia viva tiene el lock.
+type errLocked struct {
+	path string
+	pid  int // 0 si el archivo no tiene un PID legible
+}
+
+func (e *errLocked) Error() string {
+	if e.pid == 0 {
+		return fmt.Sprintf("otra instancia del daemon tiene el lock %s", e.path)
+	}
+	return fmt.Sprintf("otra instancia del daemon (PID %d) tiene el lock %s", e.pid, e.path)
+}
+
+// acquireInstanceLock toma el lock sin esperar y escribe el PID propio.
+func acquireInstanceLock(path string) (*instanceLock, error) {
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			pid := readLockPID(file)
+			file.Close()
+			if errors.Is(err, syscall.EWOULDBLOCK) {
+				return nil, &errLocked{path: path, pid: pid}
+			}
+			return nil, fmt.Errorf("error tomando el lock %s: %w", path, err)
+		}
+
+		// Si otra instancia borró el archivo al terminar entre el open y el
+		// flock, el lock quedó sobre un inodo huérfano: se vuelve a intentar
+		if !sameFile(file, path) {
+			file.Close()
+			continue
+		}
+
+		if pid := readLockPID(file); pid != 0 && pid != os.Getpid() {
+			log.Printf("Lock %s abandonado por el PID %d, que ya no lo tiene; se toma", path, pid)
+		}
+		if err := writeLockPID(file); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error escribiendo el PID en %s: %w", path, err)
+		}
+		return &instanceLock{path: path, file: file}, nil
+	}
+}
+
+// release borra el pidfile antes de soltar el flock, para que nadie lo lea
+// con el PID de una instancia que ya terminó.
+func (l *instanceLock) release() error {
+	removeErr := os.Remove(l.path)
+	if errors.Is(removeErr, os.ErrNotExist) {
+		removeErr = nil
+	}
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	return removeErr
+}
+
+func readLockPID(file *os.File) int {
+	buf := make([]byte, 32)
+	n, _ := file.ReadAt(buf, 0)
+	pid, err := strconv.Atoi(string(bytes.TrimSpace(buf[:n])))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+func writeLockPID(file *os.File) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// sameFile indica si el descriptor abierto sigue siendo el archivo de path.
+func sameFile(file *os.File, path string) bool {
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(opened, current)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestInstanceLockRejectsSecondInstance(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daemon.lock")
+
+	lock, err := acquireInstanceLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Otro descriptor sobre el mismo archivo compite por el flock igual que otro proceso
+	_, err = acquireInstanceLock(path)
+	var locked *errLocked
+	if !errors.As(err, &locked) {
+		t.Fatalf("segundo acquire: %v, se esperaba errLocked", err)
+	}
+	if locked.pid != os.Getpid() {
+		t.Errorf("errLocked con PID %d, se esperaba el del dueño %d", locked.pid, os.Getpid())
+	}
+	if !strings.Contains(err.Error(), strconv.Itoa(os.Getpid())) {
+		t.Errorf("el mensaje no nombra al dueño: %v", err)
+	}
+
+	// Al soltarlo se borra el pidfile y se puede volver a tomar
+	if err := lock.release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("el pidfile sigue tras release: %v", err)
+	}
+	lock, err = acquireInstanceLock(path)
+	if err != nil {
+		t.Fatalf("acquire tras release: %v", err)
+	}
+	lock.release()
+}
+
+func TestInstanceLockTakesOverStaleFile(t *testing.T) {
+	// Un PID que ya terminó: el archivo quedó de una instancia caída, sin flock
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("no se pudo obtener un PID terminado: %v", err)
+	}
+	dead := cmd.Process.Pid
+
+	path := filepath.Join(t.TempDir(), "daemon.lock")
+	if err := os.WriteFile(path, []byte(strconv.Itoa(dead)+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := acquireInstanceLock(path)
+	if err != nil {
+		t.Fatalf("no se tomó el lock abandonado por el PID %d: %v", dead, err)
+	}
+	defer lock.release()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(data)); got != strconv.Itoa(os.Getpid()) {
+		t.Errorf("pidfile con %q, se esperaba el PID propio %d", got, os.Getpid())
+	}
+}
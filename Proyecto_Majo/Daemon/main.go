@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -11,7 +14,6 @@ import (
 	"os/signal"
 	"path/filepath"
 	"sort"
-	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -74,13 +76,28 @@ type Container struct {
 	RSSKB         int64  `json:"rss_kb"`
 	MemoryPercent int    `json:"memory_percent"`
 	CPUPercent    int    `json:"cpu_percent"`
+	ContainerID   string `json:"container_id,omitempty"` // resuelto por cgroup, no lo reporta el módulo
+
+	Cgroup *CgroupStats      `json:"cgroup,omitempty"` // nil si el cgroup v2 no es legible
+	Tree   *ProcessTreeStats `json:"tree,omitempty"`   // proceso y descendientes; nil sin lista de procesos
 }
 
+const (
+	grafanaContainerName = "grafana-monitoring"
+	grafanaImage         = "grafana/grafana:latest"
+	dockerStopTimeout    = 10 * time.Second
+)
+
 // Configuración del daemon
 type DaemonConfig struct {
+	ConfigPath             string
+	ProjectRoot            string
 	ContainerInfoPath      string
 	SystemInfoPath         string
+	MetricsSource          string // auto, kernel, proc o fixture
+	FixtureDir             string // sysinfo.json y continfo.json para la fuente fixture
 	DBPath                 string
+	LockFile               string // pidfile de instancia única; por defecto junto a la base
 	LoopInterval           time.Duration
 	MinLowConsumption      int
 	MinHighConsumption     int
@@ -90,65 +107,263 @@ type DaemonConfig struct {
 	CleanContainersScript  string
 	KernelModulesScript    string
 	BashDir                string
+	DockerSocket           string
+	DockerEvents           bool          // seguir el flujo /events además del loop
+	PolicyPath             string        // vacío: política por defecto basada en los umbrales
+	AlertRulesPath         string        // vacío: reglas por defecto de host y daemon
+	AlertWebhook           string        // URL que recibe las alertas; vacío: solo se registran
+	AlertRetries           int           // reintentos por notificación
+	AlertTimeout           time.Duration // plazo de cada intento
+	WorkloadProfilePath    string        // vacío: imágenes de create_containers.sh
+	DryRun                 bool          // clasificar y registrar decisiones sin detener ni crear contenedores
+	GraceIterations        int           // iteraciones antes de que un contenedor cuente para el enforcement
+	ReclassifySamples      int           // muestras seguidas para aceptar un cambio de clase (histéresis)
+	EnforcementMode        string        // graduated o kill
+	EscalateAfter          int           // iteraciones en exceso antes de pasar a la etapa siguiente
+	ThrottleMemoryPercent  int           // memory.high como porcentaje del RSS al limitar
+	ThrottleCPUPercent     int           // cpu.max como porcentaje de una CPU
+	CgroupRoot             string        // montaje de la jerarquía cgroup v2
+	AnomalyAlpha           float64       // peso de cada muestra en la EWMA
+	AnomalyZScore          float64       // desviaciones desde la media para marcar un outlier
+	LeakWindow             int           // muestras sobre las que se mide la pendiente de RSS
+	LeakMinSlopeKB         int64         // pendiente mínima, en KB por iteración, para marcar una fuga
+	ProcessTopN            int           // procesos guardados por iteración, por RSS; 0 guarda todos
+	APIAddr                string        // dirección de la API HTTP; vacío la deshabilita
+	GrafanaAddr            string        // dirección de /grafana/ y /metrics, de solo lectura; vacío la deshabilita
+	CreateSchedule         string        // cron o @every; reemplaza la entrada de crontab
+	RetentionSchedule      string        // cron o @every de rollups y purga
+	ReportSchedule         string        // cron o @every del reporte periódico
+	ShutdownTimeout        time.Duration // espera máxima por la iteración en curso y por la limpieza
+	RetentionRaw           time.Duration // filas crudas de container_metrics/system_metrics
+	Retention1m            time.Duration // tablas *_1m
+	Retention1h            time.Duration // tablas *_1h
 }
 
 type Daemon struct {
 	config         *DaemonConfig
+	args           []string // argumentos originales, para recargar con SIGHUP
 	db             *sql.DB
+	docker         *DockerClient
+	cgroups        *CgroupResolver
+	policy         *Policy
+	workload       *WorkloadProfile
+	lifecycle      *lifecycleTracker
+	throttles      map[string]*throttleState  // enforcement graduado, por lifecycleKey
+	events         chan Event                 // eventos del engine hacia el loop principal
+	procSource     *procSource                // conserva la CPU de la muestra anterior
+	activeSource   string                     // fuente de la última muestra leída
+	cgroupCPU      map[string]cgroupCPUSample // usage_usec anterior por directorio cgroup
+	anomalies      *anomalyDetector
+	alerts         *alertManager
+	state          *runtimeState
+	metrics        *daemonMetrics
+	iteration      *IterationRecord // iteración en curso, solo desde el loop principal
+	bootID         string           // boot_id del kernel, vacío si no se pudo leer
+	hostRecorded   bool
+	scheduler      *Scheduler
+	cleanups       []cleanupStep // pasos de apagado, en orden de arranque
 	grafanaStarted bool
-	cronJobActive  bool
 }
 
 func main() {
-	// Obtener directorio actual del proyecto
-	currentDir, err := os.Getwd()
-	if err != nil {
-		log.Fatalf("Error obteniendo directorio actual: %v", err)
+	// Sin subcomando (o con flags directamente) se arranca el daemon, como antes
+	name, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
 
-	// El directorio raíz del proyecto (subir un nivel desde /Daemon)
-	projectRoot := filepath.Dir(currentDir)
+	if name == "run" {
+		os.Exit(run(args))
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		log.Printf("Subcomando desconocido: %s", name)
+		printUsage()
+		os.Exit(exitConfig)
+	}
+	if err := cmd.run(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		log.Fatalf("Error en %s: %v", name, err)
+	}
+}
 
-	config := &DaemonConfig{
-		ContainerInfoPath:      "/proc/continfo_so1_202100265",
-		SystemInfoPath:         "/proc/sysinfo_so1_202100265",
-		DBPath:                 "./monitoring.db",
-		LoopInterval:           20 * time.Second,
-		MinLowConsumption:      3,
-		MinHighConsumption:     2,
-		MemoryThreshold:        30000, // 30MB en KB
-		CPUThreshold:           80,    // 80%
-		CreateContainersScript: filepath.Join(projectRoot, "Bash", "create_containers.sh"),
-		CleanContainersScript:  filepath.Join(projectRoot, "Bash", "clean_containers.sh"),
-		KernelModulesScript:    filepath.Join(projectRoot, "load_kernel_modules.sh"),
-		BashDir:                filepath.Join(projectRoot, "Bash"),
+// run arranca el daemon y devuelve el código de salida. SIGINT/SIGTERM dejan de
+// aceptar iteraciones nuevas; la iteración en curso tiene ShutdownTimeout para
+// terminar antes de que se cancelen sus operaciones.
+func run(args []string) int {
+	config, err := LoadConfig(args)
+	if err != nil {
+		log.Printf("Error cargando configuración: %v", err)
+		return exitConfig
 	}
 
 	daemon := &Daemon{
-		config: config,
+		config:     config,
+		args:       args,
+		docker:     NewDockerClient(config.DockerSocket),
+		cgroups:    NewCgroupResolver("/proc"),
+		lifecycle:  newLifecycleTracker(),
+		throttles:  make(map[string]*throttleState),
+		events:     make(chan Event, 64),
+		procSource: newProcSource("/proc"),
+		anomalies:  newAnomalyDetector(),
+		alerts:     newAlertManager(),
+		state:      newRuntimeState(),
+		metrics:    newDaemonMetrics(),
+	}
+	daemon.state.setSettings(config)
+
+	// Cargar la política de clasificación
+	if daemon.policy, err = loadPolicy(config); err != nil {
+		log.Printf("Error cargando política: %v", err)
+		return exitConfig
+	}
+
+	// Cargar el perfil de imágenes para crear contenedores
+	if daemon.workload, err = loadWorkloadProfile(config, daemon.policy); err != nil {
+		log.Printf("Error cargando perfil de carga: %v", err)
+		return exitConfig
+	}
+
+	// Cargar las reglas de alerta
+	if daemon.alerts.rules, err = loadAlertRules(config); err != nil {
+		log.Printf("Error cargando reglas de alerta: %v", err)
+		return exitConfig
 	}
 
 	// Verificar que los scripts existen
 	if err := daemon.validateScripts(); err != nil {
-		log.Fatalf("Error validando scripts: %v", err)
+		log.Printf("Error validando scripts: %v", err)
+		return exitConfig
+	}
+
+	// root se pasa a todas las operaciones; solo se cancela si el apagado no
+	// termina a tiempo. stop se cancela con la primera señal de terminación.
+	root, abort := context.WithCancel(context.Background())
+	defer abort()
+	stop, stopSignals := signal.NotifyContext(root, os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	// Una sola instancia por base de datos
+	lock, err := acquireInstanceLock(config.LockFile)
+	if err != nil {
+		log.Printf("No se puede iniciar: %v", err)
+		return exitLocked
 	}
+	daemon.onShutdown("lock de instancia", func(context.Context) error { return lock.release() })
 
 	// Inicializar la base de datos
-	if err := daemon.initDB(); err != nil {
-		log.Fatalf("Error inicializando la base de datos: %v", err)
+	if err := daemon.initDB(root); err != nil {
+		log.Printf("Error inicializando la base de datos: %v", err)
+		daemon.shutdown(config.ShutdownTimeout)
+		return exitInit
 	}
-	defer daemon.db.Close()
 
-	// Manejar señales para limpieza
-	daemon.setupSignalHandlers()
+	// Retomar los ciclos de vida abiertos de la ejecución anterior
+	if err := daemon.loadLifecycles(root); err != nil {
+		log.Printf("Error cargando ciclos de vida, se empieza de cero: %v", err)
+	}
+
+	// Identificar el arranque actual para guardar los datos del host una vez
+	bootID, err := readBootID()
+	if err != nil {
+		log.Printf("No se pudo leer el boot_id, no se guardarán datos del host: %v", err)
+	}
+	daemon.bootID = bootID
 
 	// Iniciar el daemon
-	daemon.start()
+	daemon.start(root, stop)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		daemon.mainLoop(root, stop.Done())
+	}()
+
+	<-stop.Done()
+	// Una segunda señal termina el proceso sin esperar
+	stopSignals()
+	log.Printf("Recibida señal de terminación, esperando la iteración en curso (máximo %v)...", config.ShutdownTimeout)
+
+	code := exitOK
+	if !waitLoop(done, config.ShutdownTimeout, abort) {
+		code = exitShutdownTimeout
+	}
+
+	if !daemon.shutdown(config.ShutdownTimeout) && code == exitOK {
+		code = exitError
+	}
+	return code
+}
+
+func loadPolicy(config *DaemonConfig) (*Policy, error) {
+	if config.PolicyPath == "" {
+		return DefaultPolicy(config), nil
+	}
+
+	policy, err := LoadPolicy(config.PolicyPath)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Política cargada desde %s", config.PolicyPath)
+	return policy, nil
+}
+
+// reloadConfig vuelve a leer la configuración y aplica los umbrales, el
+// intervalo y la política sin reiniciar el daemon. La configuración nueva y
+// los archivos que referencia se cargan antes de tocar nada: si alguno falla
+// se mantiene todo lo actual, nunca una mezcla de los dos.
+func (d *Daemon) reloadConfig(ticker *time.Ticker) {
+	log.Println("Recibida señal SIGHUP, recargando configuración...")
+
+	next, err := LoadConfig(d.args)
+	if err != nil {
+		log.Printf("Error recargando configuración, se mantiene la actual: %v", err)
+		return
+	}
+
+	candidate := *d.config
+	changed, ignored := candidate.applyReload(next)
+	if len(ignored) > 0 {
+		log.Printf("Opciones que requieren reinicio, ignoradas: %s", strings.Join(ignored, ", "))
+	}
+
+	policy, err := loadPolicy(&candidate)
+	if err != nil {
+		log.Printf("Error recargando política, se mantiene la configuración actual: %v", err)
+		return
+	}
+	workload, err := loadWorkloadProfile(&candidate, policy)
+	if err != nil {
+		log.Printf("Error recargando perfil de carga, se mantiene la configuración actual: %v", err)
+		return
+	}
+	rules, err := loadAlertRules(&candidate)
+	if err != nil {
+		log.Printf("Error recargando reglas de alerta, se mantiene la configuración actual: %v", err)
+		return
+	}
+
+	*d.config = candidate
+	d.policy = policy
+	d.workload = workload
+	d.alerts.rules = rules
+
+	ticker.Reset(d.config.LoopInterval)
+	d.state.setSettings(d.config)
+
+	if len(changed) == 0 {
+		log.Println("Configuración recargada sin cambios")
+		return
+	}
+	log.Printf("Configuración recargada: %s", strings.Join(changed, ", "))
 }
 
 func (d *Daemon) validateScripts() error {
 	scripts := []string{
-		d.config.CreateContainersScript,
 		d.config.CleanContainersScript,
 	}
 
@@ -166,113 +381,129 @@ func (d *Daemon) validateScripts() error {
 	return nil
 }
 
-func (d *Daemon) initDB() error {
+func (d *Daemon) initDB(ctx context.Context) error {
 	var err error
-	d.db, err = sql.Open("sqlite3", d.config.DBPath)
+	d.db, err = openDB(d.config.DBPath)
 	if err != nil {
 		return err
 	}
+	d.onShutdown("base de datos", func(context.Context) error { return d.db.Close() })
 
-	// Crear tablas
-	tables := []string{
-		`CREATE TABLE IF NOT EXISTS system_metrics (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-			total_memory_kb INTEGER,
-			free_memory_kb INTEGER,
-			used_memory_kb INTEGER,
-			total_processes INTEGER,
-			running_processes INTEGER,
-			sleeping_processes INTEGER
-		)`,
-		`CREATE TABLE IF NOT EXISTS container_metrics (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-			pid INTEGER,
-			name TEXT,
-			cmdline TEXT,
-			vsz_kb INTEGER,
-			rss_kb INTEGER,
-			memory_percent INTEGER,
-			cpu_percent INTEGER,
-			status TEXT DEFAULT 'active'
-		)`,
-		`CREATE TABLE IF NOT EXISTS container_actions (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-			action TEXT,
-			container_pid INTEGER,
-			container_name TEXT,
-			reason TEXT
-		)`,
-	}
-
-	for _, table := range tables {
-		if _, err := d.db.Exec(table); err != nil {
-			return err
-		}
+	// Aplicar migraciones pendientes
+	if _, err := migrateUp(d.db); err != nil {
+		return err
+	}
+
+	// Continuar la numeración de iteraciones de ejecuciones anteriores
+	var lastID sql.NullInt64
+	if err := d.db.QueryRowContext(ctx, `SELECT MAX(id) FROM iterations`).Scan(&lastID); err != nil {
+		return err
 	}
+	d.state.setNextIteration(lastID.Int64 + 1)
 
 	return nil
 }
 
-func (d *Daemon) start() {
+// readBootID devuelve el identificador que el kernel genera en cada arranque.
+func readBootID() (string, error) {
+	data, err := ioutil.ReadFile("/proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// openDB abre SQLite en modo WAL con busy timeout, para que Grafana y otros
+// lectores del mismo archivo no bloqueen ni corrompan las escrituras del daemon.
+func openDB(path string) (*sql.DB, error) {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	dsn := path + separator + "_journal_mode=WAL&_busy_timeout=5000&_synchronous=NORMAL"
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// nullString guarda NULL en lugar de cadenas vacías.
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+// start ejecuta los pasos de arranque; los pasos puntuales usan stop para
+// abortar si llega una señal, los servicios de fondo viven hasta el apagado.
+func (d *Daemon) start(root, stop context.Context) {
 	log.Println("Iniciando daemon de monitoreo...")
+	if d.config.DryRun {
+		log.Println("MODO DRY-RUN: no se detendrán, eliminarán ni crearán contenedores")
+	}
 
-	// 1. Ejecutar script de limpieza inicial
-	if err := d.executeCleanContainers(); err != nil {
-		log.Printf("Error en limpieza inicial: %v", err)
+	// 1. Ejecutar script de limpieza inicial; se repite al apagar
+	if d.config.DryRun {
+		log.Println("Dry-run: se omite la limpieza inicial")
+	} else {
+		if err := d.executeCleanContainers(stop); err != nil {
+			log.Printf("Error en limpieza inicial: %v", err)
+		}
+		d.onShutdown("limpieza de contenedores", d.executeCleanContainers)
 	}
 
 	// 2. Crear contenedor de Grafana
-	if err := d.startGrafana(); err != nil {
+	if err := d.startGrafana(stop); err != nil {
 		log.Printf("Error iniciando Grafana: %v", err)
 	}
 
-	// 3. Iniciar cronjob
-	if err := d.startCronJob(); err != nil {
-		log.Printf("Error iniciando cronjob: %v", err)
+	// 3. Quitar entradas de crontab de versiones anteriores del daemon
+	if err := d.removeLegacyCronEntries(stop); err != nil {
+		log.Printf("Error revisando crontab: %v", err)
 	}
 
 	// 4. Construir imágenes Docker si no existen
-	if err := d.buildDockerImages(); err != nil {
+	if err := d.buildDockerImages(stop); err != nil {
 		log.Printf("Error construyendo imágenes Docker: %v", err)
 	}
 
 	// 5. Cargar módulos de kernel
-	if err := d.loadKernelModules(); err != nil {
+	if err := d.loadKernelModules(stop); err != nil {
 		log.Printf("Error cargando módulos de kernel: %v", err)
 	}
 
-	// 5. Crear contenedores iniciales
-	if err := d.executeCreateContainers(); err != nil {
-		log.Printf("Error creando contenedores iniciales: %v", err)
+	// 6. Tareas programadas: creación, retención y reportes
+	if err := d.startScheduler(root); err != nil {
+		log.Printf("Error iniciando tareas programadas: %v", err)
 	}
 
-	// 6. Loop principal
-	d.mainLoop()
-}
+	// 7. API HTTP de control y estado
+	if err := d.startAPI(root); err != nil {
+		log.Printf("Error iniciando API HTTP: %v", err)
+	}
 
-func (d *Daemon) executeCreateContainers() error {
-	log.Println("Ejecutando script de creación de contenedores...")
+	// 8. Datasource de Grafana y métricas de Prometheus, de solo lectura
+	if err := d.startGrafanaAPI(root); err != nil {
+		log.Printf("Error iniciando el datasource de Grafana: %v", err)
+	}
 
-	cmd := exec.Command("bash", d.config.CreateContainersScript)
-	cmd.Dir = d.config.BashDir
+	// 9. Eventos de contenedores del engine
+	d.startEvents(root)
 
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("Output del script create_containers: %s", string(output))
-		return fmt.Errorf("error ejecutando create_containers.sh: %v", err)
+	// 10. Entrega de alertas
+	if err := d.startAlerts(root); err != nil {
+		log.Printf("Error iniciando alertas, se registrarán sin notificar: %v", err)
 	}
-
-	log.Printf("Script create_containers ejecutado exitosamente: %s", string(output))
-	return nil
 }
 
-func (d *Daemon) executeCleanContainers() error {
+func (d *Daemon) executeCleanContainers(ctx context.Context) error {
 	log.Println("Ejecutando script de limpieza de contenedores...")
 
-	cmd := exec.Command("bash", d.config.CleanContainersScript)
+	cmd := exec.CommandContext(ctx, "bash", d.config.CleanContainersScript)
 	cmd.Dir = d.config.BashDir
 
 	output, err := cmd.CombinedOutput()
@@ -285,94 +516,91 @@ func (d *Daemon) executeCleanContainers() error {
 	return nil
 }
 
-func (d *Daemon) startGrafana() error {
+func (d *Daemon) startGrafana(ctx context.Context) error {
 	log.Println("Iniciando Grafana...")
 
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
 	// Verificar si ya existe
-	cmd := exec.Command("docker", "ps", "-q", "-f", "name=grafana-monitoring")
-	if output, _ := cmd.Output(); len(strings.TrimSpace(string(output))) > 0 {
+	existing, err := d.docker.ListContainers(ctx, true, map[string][]string{"name": {"^/" + grafanaContainerName + "$"}})
+	if err != nil {
+		return fmt.Errorf("error consultando contenedores: %w", err)
+	}
+	if len(existing) > 0 {
+		if existing[0].State != "running" {
+			if err := d.docker.StartContainer(ctx, existing[0].ID); err != nil {
+				return fmt.Errorf("error reiniciando Grafana: %w", err)
+			}
+		}
 		log.Println("Grafana ya está ejecutándose")
 		d.grafanaStarted = true
 		return nil
 	}
 
-	// Obtener directorio del proyecto (un nivel arriba del Daemon)
-	currentDir, err := os.Getwd()
+	// Descargar la imagen si no está disponible
+	found, err := d.docker.ImageExists(ctx, grafanaImage)
 	if err != nil {
-		return fmt.Errorf("error obteniendo directorio actual: %v", err)
+		return fmt.Errorf("error verificando imagen de Grafana: %w", err)
 	}
-
-	projectRoot := filepath.Dir(currentDir)
-
-	// Intentar primero con docker compose (nuevo comando)
-	cmd = exec.Command("docker", "compose", "up", "-d", "grafana")
-	cmd.Dir = projectRoot
-
-	if err := cmd.Run(); err != nil {
-		log.Printf("Docker compose falló, intentando con docker-compose: %v", err)
-
-		// Intentar con docker-compose (comando legacy)
-		cmd = exec.Command("docker-compose", "up", "-d", "grafana")
-		cmd.Dir = projectRoot
-
-		if err := cmd.Run(); err != nil {
-			log.Printf("Docker-compose falló, intentando con docker run: %v", err)
-			return d.startGrafanaWithDocker()
+	if !found {
+		log.Printf("Descargando imagen %s...", grafanaImage)
+		if err := d.docker.PullImage(ctx, grafanaImage); err != nil {
+			return fmt.Errorf("error descargando imagen de Grafana: %w", err)
 		}
 	}
 
-	d.grafanaStarted = true
-	log.Println("Grafana iniciado con compose en puerto 3000")
-	return nil
-}
-
-func (d *Daemon) startGrafanaWithDocker() error {
-	log.Println("Iniciando contenedor de Grafana con docker run...")
-
-	// Primero detener cualquier contenedor existente con el mismo nombre
-	exec.Command("docker", "stop", "grafana-monitoring").Run()
-	exec.Command("docker", "rm", "grafana-monitoring").Run()
-
-	cmd := exec.Command("docker", "run", "-d",
-		"--name", "grafana-monitoring",
-		"-p", "3000:3000",
-		"-e", "GF_SECURITY_ADMIN_PASSWORD=admin",
-		"-v", "grafana-data:/var/lib/grafana",
-		"--restart", "unless-stopped",
-		"grafana/grafana:latest")
-
-	output, err := cmd.CombinedOutput()
+	id, err := d.docker.CreateContainer(ctx, grafanaContainerName, d.grafanaContainerConfig())
 	if err != nil {
-		log.Printf("Error detallado de Docker: %s", string(output))
-		return fmt.Errorf("error creando contenedor de Grafana: %v", err)
+		return fmt.Errorf("error creando contenedor de Grafana: %w", err)
+	}
+	if err := d.docker.StartContainer(ctx, id); err != nil {
+		return fmt.Errorf("error iniciando contenedor de Grafana: %w", err)
 	}
 
-	log.Printf("Grafana iniciado exitosamente: %s", string(output))
+	d.grafanaStarted = true
+	log.Printf("Grafana iniciado en puerto 3000 (%.12s)", id)
 	return nil
 }
 
-func (d *Daemon) startCronJob() error {
-	log.Println("Configurando cronjob para creación de contenedores...")
+// grafanaContainerConfig replica el servicio grafana de docker-compose.yml.
+func (d *Daemon) grafanaContainerConfig() *ContainerConfig {
+	projectRoot := d.config.ProjectRoot
 
-	// Crear entrada de cron que ejecute el script cada minuto
-	cronEntry := fmt.Sprintf("* * * * * %s", d.config.CreateContainersScript)
-
-	cmd := exec.Command("bash", "-c", fmt.Sprintf("(crontab -l 2>/dev/null; echo '%s') | crontab -", cronEntry))
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("error configurando cronjob: %v", err)
+	binds := []string{"grafana-data:/var/lib/grafana"}
+	mounts := map[string]string{
+		filepath.Join(projectRoot, "Grafana", "provisioning"): "/etc/grafana/provisioning",
+		filepath.Join(projectRoot, "Grafana", "dashboards"):   "/var/lib/grafana/dashboards",
+	}
+	for hostPath, containerPath := range mounts {
+		if _, err := os.Stat(hostPath); err == nil {
+			binds = append(binds, hostPath+":"+containerPath)
+		}
 	}
 
-	d.cronJobActive = true
-	log.Println("Cronjob configurado correctamente")
-	return nil
+	return &ContainerConfig{
+		Image: grafanaImage,
+		Env: []string{
+			"GF_SECURITY_ADMIN_PASSWORD=admin",
+			"GF_INSTALL_PLUGINS=grafana-piechart-panel,simpod-json-datasource",
+		},
+		ExposedPorts: map[string]struct{}{"3000/tcp": {}},
+		HostConfig: HostConfig{
+			Binds:         binds,
+			PortBindings:  map[string][]PortBinding{"3000/tcp": {{HostPort: "3000"}}},
+			RestartPolicy: RestartPolicy{Name: "unless-stopped"},
+			// El datasource Daemon consulta la API del daemon en el host
+			ExtraHosts: []string{"host.docker.internal:host-gateway"},
+		},
+	}
 }
 
-func (d *Daemon) loadKernelModules() error {
+func (d *Daemon) loadKernelModules(ctx context.Context) error {
 	log.Println("Cargando módulos de kernel...")
 
 	// Si existe el script de módulos, ejecutarlo
 	if _, err := os.Stat(d.config.KernelModulesScript); err == nil {
-		cmd := exec.Command("bash", d.config.KernelModulesScript)
+		cmd := exec.CommandContext(ctx, "bash", d.config.KernelModulesScript)
 		if err := cmd.Run(); err != nil {
 			log.Printf("Error ejecutando script de módulos: %v", err)
 		}
@@ -382,291 +610,525 @@ func (d *Daemon) loadKernelModules() error {
 	return nil
 }
 
-func (d *Daemon) mainLoop() {
+// mainLoop corre hasta que se cierra stop. ctx se pasa a cada iteración y solo
+// se cancela si la iteración en curso excede el plazo de apagado.
+func (d *Daemon) mainLoop(ctx context.Context, stop <-chan struct{}) {
 	log.Printf("Iniciando loop principal (cada %v)...", d.config.LoopInterval)
 
 	ticker := time.NewTicker(d.config.LoopInterval)
 	defer ticker.Stop()
 
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
 	for {
+		// Priorizar el apagado sobre una iteración que ya esté lista
+		select {
+		case <-stop:
+			log.Println("Loop principal detenido")
+			return
+		default:
+		}
+
 		select {
+		case <-stop:
+			log.Println("Loop principal detenido")
+			return
 		case <-ticker.C:
-			d.processIteration()
+			d.processIteration(ctx, false)
+		case <-d.state.force:
+			d.processIteration(ctx, true)
+		case ev := <-d.events:
+			d.handleContainerEvent(ctx, ev)
+		case <-reload:
+			d.reloadConfig(ticker)
 		}
 	}
 }
 
-func (d *Daemon) processIteration() {
+func (d *Daemon) processIteration(ctx context.Context, forced bool) {
 	log.Println("=== Nueva iteración ===")
 
-	// Leer información del sistema
-	systemInfo, err := d.readSystemInfo()
+	var systemInfo *SystemInfo
+	var containers []Container
+
+	d.iteration = d.state.beginIteration(forced)
+	defer func() {
+		// Las alertas se evalúan también cuando la muestra no se pudo leer
+		d.evaluateAlerts(ctx, systemInfo, containers)
+		d.state.finishIteration(d.iteration)
+		d.storeIterationSummary(d.iteration)
+		d.metrics.observeIteration(d.iteration)
+		d.iteration = nil
+	}()
+
+	// Los PIDs pueden reutilizarse entre iteraciones
+	d.cgroups.Reset()
+
+	// Leer la muestra de la fuente de métricas disponible
+	systemInfo, containerInfo, err := d.readSample()
 	if err != nil {
-		log.Printf("Error leyendo información del sistema: %v", err)
-		log.Printf("Verificando si el archivo existe: %s", d.config.SystemInfoPath)
-		if _, statErr := os.Stat(d.config.SystemInfoPath); os.IsNotExist(statErr) {
-			log.Printf("ADVERTENCIA: Archivo de sistema no existe. ¿Están cargados los módulos de kernel?")
-		}
+		d.recordError("Error leyendo métricas: %v", err)
+		d.alerts.noteReadFailure(time.Now())
 		return
 	}
 
-	// Leer información de contenedores
-	containerInfo, err := d.readContainerInfo()
-	if err != nil {
-		log.Printf("Error leyendo información de contenedores: %v", err)
-		log.Printf("Verificando si el archivo existe: %s", d.config.ContainerInfoPath)
-		if _, statErr := os.Stat(d.config.ContainerInfoPath); os.IsNotExist(statErr) {
-			log.Printf("ADVERTENCIA: Archivo de contenedores no existe. ¿Están cargados los módulos de kernel?")
+	// Asociar cada proceso con su contenedor
+	for i := range containerInfo.Containers {
+		if id, err := d.cgroups.ContainerID(containerInfo.Containers[i].PID); err == nil {
+			containerInfo.Containers[i].ContainerID = id
 		}
-		return
 	}
+	d.attributeProcessTrees(systemInfo.Processes, containerInfo.Containers)
+	d.annotateCgroupStats(containerInfo.Containers)
+	d.iteration.Containers = len(containerInfo.Containers)
+	containers = containerInfo.Containers
+
+	d.metrics.observeSystem(systemInfo)
 
 	// Almacenar métricas en la base de datos
-	d.storeSystemMetrics(systemInfo)
-	d.storeContainerMetrics(containerInfo)
+	if err := d.storeMetrics(ctx, systemInfo, containerInfo); err != nil {
+		d.recordError("Error guardando métricas: %v", err)
+	}
 
 	// Analizar y gestionar contenedores
-	d.analyzeAndManageContainers(containerInfo)
+	d.analyzeAndManageContainers(ctx, containerInfo)
 
 	log.Printf("Memoria total: %d KB, Libre: %d KB, Contenedores activos: %d",
 		containerInfo.Memory.TotalKB, containerInfo.Memory.FreeKB, len(containerInfo.Containers))
 }
 
-func (d *Daemon) readSystemInfo() (*SystemInfo, error) {
-	data, err := ioutil.ReadFile(d.config.SystemInfoPath)
+// storeMetrics guarda la iteración y todas sus métricas en una sola transacción.
+func (d *Daemon) storeMetrics(ctx context.Context, system *SystemInfo, containers *ContainerInfo) error {
+	tx, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer tx.Rollback()
 
-	var info SystemInfo
-	if err := json.Unmarshal(data, &info); err != nil {
-		return nil, err
+	_, err = tx.Exec(`INSERT INTO iterations (id, started_at, forced, boot_id) VALUES (?, ?, ?, ?)`,
+		d.iteration.ID, d.iteration.StartedAt.UTC().Format(sqliteTimeFormat), d.iteration.Forced, nullString(d.bootID))
+	if err != nil {
+		return fmt.Errorf("error registrando iteración: %w", err)
 	}
 
-	return &info, nil
-}
-
-func (d *Daemon) readContainerInfo() (*ContainerInfo, error) {
-	data, err := ioutil.ReadFile(d.config.ContainerInfoPath)
-	if err != nil {
-		return nil, err
+	if !d.hostRecorded && d.bootID != "" {
+		if err := d.storeHostDetails(tx, system.System); err != nil {
+			return fmt.Errorf("error guardando datos del host: %w", err)
+		}
+	}
+	if err := d.storeSystemMetrics(tx, system); err != nil {
+		return fmt.Errorf("error guardando métricas del sistema: %w", err)
+	}
+	if err := d.storeProcessMetrics(tx, system.Processes); err != nil {
+		return fmt.Errorf("error guardando procesos: %w", err)
+	}
+	if err := d.storeContainerMetrics(tx, containers); err != nil {
+		return fmt.Errorf("error guardando métricas de contenedores: %w", err)
 	}
 
-	var info ContainerInfo
-	if err := json.Unmarshal(data, &info); err != nil {
-		return nil, err
+	if err := tx.Commit(); err != nil {
+		return err
 	}
+	if d.bootID != "" {
+		d.hostRecorded = true
+	}
+	return nil
+}
 
-	return &info, nil
+// storeHostDetails guarda kernel, arquitectura y hostname una sola vez por arranque.
+func (d *Daemon) storeHostDetails(tx *sql.Tx, details SystemDetails) error {
+	_, err := tx.Exec(`INSERT OR IGNORE INTO host_boots (boot_id, hostname, kernel, architecture)
+		VALUES (?, ?, ?, ?)`,
+		d.bootID, details.Hostname, details.Kernel, details.Architecture)
+	return err
 }
 
-func (d *Daemon) storeSystemMetrics(info *SystemInfo) {
+func (d *Daemon) storeSystemMetrics(tx *sql.Tx, info *SystemInfo) error {
 	query := `INSERT INTO system_metrics 
-		(total_memory_kb, free_memory_kb, used_memory_kb, total_processes, running_processes, sleeping_processes)
-		VALUES (?, ?, ?, ?, ?, ?)`
+		(total_memory_kb, free_memory_kb, used_memory_kb, total_processes, running_processes, sleeping_processes, iteration_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`
 
-	_, err := d.db.Exec(query,
+	_, err := tx.Exec(query,
 		info.Memory.TotalKB,
 		info.Memory.FreeKB,
 		info.Memory.UsedKB,
 		info.ProcessSummary.Total,
 		info.ProcessSummary.Running,
-		info.ProcessSummary.Sleeping)
+		info.ProcessSummary.Sleeping,
+		d.iteration.ID)
+
+	return err
+}
 
+func (d *Daemon) storeContainerMetrics(tx *sql.Tx, info *ContainerInfo) error {
+	stmt, err := tx.Prepare(`INSERT INTO container_metrics 
+		(pid, name, cmdline, vsz_kb, rss_kb, memory_percent, cpu_percent, container_id, iteration_id,
+		 tree_processes, tree_rss_kb, tree_vsz_kb, tree_cpu_percent,
+		 cgroup_memory_kb, cgroup_anon_kb, cgroup_file_kb, cgroup_cpu_usage_usec, cgroup_cpu_percent,
+		 cgroup_io_read_bytes, cgroup_io_write_bytes, cgroup_pids)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
-		log.Printf("Error guardando métricas del sistema: %v", err)
+		return err
 	}
-}
+	defer stmt.Close()
 
-func (d *Daemon) storeContainerMetrics(info *ContainerInfo) {
 	for _, container := range info.Containers {
-		query := `INSERT INTO container_metrics 
-			(pid, name, cmdline, vsz_kb, rss_kb, memory_percent, cpu_percent)
-			VALUES (?, ?, ?, ?, ?, ?, ?)`
+		// Sin cgroup las columnas quedan en NULL
+		cgroup := make([]interface{}, 8)
+		if cg := container.Cgroup; cg != nil {
+			cgroup = []interface{}{cg.MemoryCurrentKB, cg.MemoryAnonKB, cg.MemoryFileKB, cg.CPUUsageUsec,
+				cg.CPUPercent, cg.IOReadBytes, cg.IOWriteBytes, cg.Pids}
+		}
 
-		_, err := d.db.Exec(query,
+		tree := container.treeStats()
+		_, err := stmt.Exec(append([]interface{}{
 			container.PID,
 			container.Name,
 			container.Cmdline,
 			container.VSZKB,
 			container.RSSKB,
 			container.MemoryPercent,
-			container.CPUPercent)
+			container.CPUPercent,
+			nullString(container.ContainerID),
+			d.iteration.ID,
+			tree.Processes,
+			tree.RSSKB,
+			tree.VSZKB,
+			tree.CPUPercent}, cgroup...)...)
+
+		if err != nil {
+			return fmt.Errorf("contenedor %s: %w", container.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// storeProcessMetrics guarda la lista de procesos; con process-top-n solo los de mayor RSS.
+func (d *Daemon) storeProcessMetrics(tx *sql.Tx, processes []Process) error {
+	processes = topProcessesByRSS(processes, d.config.ProcessTopN)
+	if len(processes) == 0 {
+		return nil
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO process_metrics 
+		(iteration_id, pid, ppid, name, cmdline, vsz_kb, rss_kb, memory_percent, cpu_percent, state)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, process := range processes {
+		_, err := stmt.Exec(
+			d.iteration.ID,
+			process.PID,
+			process.PPID,
+			process.Name,
+			process.Cmdline,
+			process.VSZKB,
+			process.RSSKB,
+			process.MemoryPercent,
+			process.CPUPercent,
+			process.State)
 
 		if err != nil {
-			log.Printf("Error guardando métricas del contenedor %s: %v", container.Name, err)
+			return fmt.Errorf("proceso %d: %w", process.PID, err)
 		}
 	}
+
+	return nil
 }
 
-func (d *Daemon) analyzeAndManageContainers(info *ContainerInfo) {
+// topProcessesByRSS devuelve los n procesos de mayor RSS sin modificar el slice original.
+func topProcessesByRSS(processes []Process, n int) []Process {
+	if n <= 0 || len(processes) <= n {
+		return processes
+	}
+
+	sorted := make([]Process, len(processes))
+	copy(sorted, processes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].RSSKB > sorted[j].RSSKB
+	})
+	return sorted[:n]
+}
+
+// storeIterationSummary completa la fila de la iteración con su resultado.
+func (d *Daemon) storeIterationSummary(record *IterationRecord) {
+	var errorsJSON sql.NullString
+	if len(record.Errors) > 0 {
+		if data, err := json.Marshal(record.Errors); err == nil {
+			errorsJSON = sql.NullString{String: string(data), Valid: true}
+		}
+	}
+
+	_, err := d.db.Exec(`INSERT INTO iterations
+			(id, started_at, finished_at, duration_ms, forced, containers, kills, creates, errors)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			finished_at = excluded.finished_at,
+			duration_ms = excluded.duration_ms,
+			containers = excluded.containers,
+			kills = excluded.kills,
+			creates = excluded.creates,
+			errors = excluded.errors`,
+		record.ID,
+		record.StartedAt.UTC().Format(sqliteTimeFormat),
+		time.Now().UTC().Format(sqliteTimeFormat),
+		record.DurationMS,
+		record.Forced,
+		record.Containers,
+		record.Kills,
+		record.Creates,
+		errorsJSON)
+
+	if err != nil {
+		log.Printf("Error guardando resumen de iteración %d: %v", record.ID, err)
+	}
+}
+
+func (d *Daemon) analyzeAndManageContainers(ctx context.Context, info *ContainerInfo) {
 	// Filtrar contenedores (excluir Grafana)
 	containers := d.filterContainers(info.Containers)
+	d.detectAnomalies(ctx, containers)
 
-	// Clasificar contenedores
-	lowConsumption, highConsumption := d.classifyContainers(containers)
+	// Clasificar contenedores; la histéresis evita reclasificar por una sola muestra
+	classification := d.applyLifecycles(ctx, d.classifyContainers(containers))
 
-	log.Printf("Contenedores de bajo consumo: %d, alto consumo: %d", len(lowConsumption), len(highConsumption))
+	counts := make([]string, 0, len(classification.Classes))
+	for _, class := range classification.Classes {
+		counts = append(counts, fmt.Sprintf("%s: %d", class.Policy.Name, len(class.Containers)))
+	}
+	log.Printf("Contenedores por clase: %s (sin clase: %d)", strings.Join(counts, ", "), len(classification.Unclassified))
+	d.state.setClassification(classification)
+	d.metrics.observeClassification(classification)
+
+	if d.state.isPaused() {
+		log.Println("Enforcement pausado: no se eliminan ni crean contenedores")
+		return
+	}
 
 	// Verificar y ajustar según restricciones
-	d.enforceContainerLimits(lowConsumption, highConsumption)
+	kills := d.enforceContainerLimits(ctx, classification, info.Containers)
+
+	// Si alguna clase está bajo su mínimo, crear exactamente el déficit
+	creates := 0
+	for _, class := range classification.Classes {
+		deficit := class.Deficit()
+		if deficit == 0 {
+			continue
+		}
 
-	// Si necesitamos más contenedores, crear algunos
-	totalNeeded := d.config.MinLowConsumption + d.config.MinHighConsumption
-	totalCurrent := len(lowConsumption) + len(highConsumption)
+		log.Printf("Se necesitan más contenedores de clase %s. Actual: %d, Mínimo: %d",
+			class.Policy.Name, len(class.Containers), class.Policy.Min)
+		creates += deficit
 
-	if totalCurrent < totalNeeded {
-		log.Printf("Se necesitan más contenedores. Actual: %d, Necesario: %d", totalCurrent, totalNeeded)
-		if err := d.executeCreateContainers(); err != nil {
-			log.Printf("Error creando contenedores adicionales: %v", err)
+		if d.config.DryRun {
+			d.logContainerAction("WOULD_CREATE", Container{},
+				fmt.Sprintf("Clase %s: crearía %d (actual %d, target %d)", class.Policy.Name, deficit, len(class.Containers), class.Policy.Target))
+			continue
+		}
+
+		if _, err := d.createWorkload(ctx, class.Policy.Name, deficit); err != nil {
+			d.recordError("Error creando contenedores de clase %s: %v", class.Policy.Name, err)
 		}
 	}
+
+	d.iteration.Kills = kills
+	d.iteration.Creates = creates
+
+	if d.config.DryRun {
+		summary := fmt.Sprintf("Eliminaría %d, crearía %d (%s)", kills, creates, strings.Join(counts, ", "))
+		log.Printf("Resumen dry-run: %s", summary)
+		d.logContainerAction("DRY_RUN_SUMMARY", Container{}, summary)
+	}
 }
 
 func (d *Daemon) filterContainers(containers []Container) []Container {
 	var filtered []Container
 	for _, container := range containers {
-		// Excluir Grafana y otros servicios del sistema
-		if !strings.Contains(strings.ToLower(container.Name), "grafana") &&
-			!strings.Contains(strings.ToLower(container.Name), "containerd") &&
-			!strings.Contains(strings.ToLower(container.Name), "dockerd") &&
-			!strings.Contains(strings.ToLower(container.Cmdline), "grafana") {
+		// Excluir Grafana, otros servicios del sistema y contenedores protegidos
+		if !d.policy.Excluded(container) && !d.state.isProtected(container) {
 			filtered = append(filtered, container)
 		}
 	}
 	return filtered
 }
 
-func (d *Daemon) classifyContainers(containers []Container) ([]Container, []Container) {
-	var low, high []Container
+func (d *Daemon) classifyContainers(containers []Container) *Classification {
+	return d.policy.Classify(containers)
+}
 
-	for _, container := range containers {
-		// Clasificar basado en consumo de memoria y CPU
-		if container.RSSKB > d.config.MemoryThreshold || container.CPUPercent > d.config.CPUThreshold {
-			high = append(high, container)
-		} else {
-			low = append(low, container)
-		}
+// enforceContainerLimits actúa sobre el exceso de cada clase y devuelve cuántas
+// eliminaciones decidió. En modo graduado las víctimas primero se limitan,
+// luego se pausan y solo al final se eliminan. sampled es la muestra sin
+// filtrar, para seguir a los limitados que pasaron a protegidos o excluidos.
+func (d *Daemon) enforceContainerLimits(ctx context.Context, classification *Classification, sampled []Container) int {
+	type victim struct {
+		container Container
+		reason    string
 	}
+	var excess []victim
+	victims := make(map[string]bool)
+
+	// Los contenedores en periodo de gracia no cuentan ni pueden ser víctimas
+	for _, class := range classification.Classes {
+		mature := d.preferAnomalous(d.matureContainers(class))
+		if young := len(class.Containers) - len(mature.Containers); young > 0 {
+			log.Printf("Clase %s: %d contenedores en periodo de gracia", class.Policy.Name, young)
+		}
 
-	// Ordenar por consumo de recursos
-	sort.Slice(low, func(i, j int) bool {
-		return low[i].RSSKB > low[j].RSSKB
-	})
-
-	sort.Slice(high, func(i, j int) bool {
-		return high[i].RSSKB > high[j].RSSKB
-	})
-
-	return low, high
-}
-
-func (d *Daemon) enforceContainerLimits(low, high []Container) {
-	// Eliminar exceso de contenedores de bajo consumo
-	if len(low) > d.config.MinLowConsumption {
-		excess := low[d.config.MinLowConsumption:]
-		for _, container := range excess {
-			d.killContainer(container, "Exceso de contenedores de bajo consumo")
+		reason := fmt.Sprintf("Exceso de contenedores de clase %s", class.Policy.Name)
+		for _, container := range mature.Excess() {
+			excess = append(excess, victim{container, reason})
+			victims[lifecycleKey(container)] = true
 		}
 	}
 
-	// Eliminar exceso de contenedores de alto consumo
-	if len(high) > d.config.MinHighConsumption {
-		excess := high[d.config.MinHighConsumption:]
-		for _, container := range excess {
-			d.killContainer(container, "Exceso de contenedores de alto consumo")
+	// El efecto de las etapas anteriores se mide antes de aplicar las nuevas
+	current := make(map[string]Container, len(sampled))
+	for _, c := range sampled {
+		current[lifecycleKey(c)] = c
+	}
+	d.reviewThrottles(ctx, current, classification.byKey(), victims)
+
+	kills := 0
+	for _, v := range excess {
+		if d.config.EnforcementMode == enforcementKill {
+			d.killContainer(ctx, v.container, v.reason)
+			kills++
+		} else if d.enforceGraduated(ctx, v.container, v.reason) {
+			kills++
 		}
 	}
+	return kills
 }
 
-func (d *Daemon) killContainer(container Container, reason string) {
+func (d *Daemon) killContainer(ctx context.Context, container Container, reason string) {
 	log.Printf("Eliminando contenedor: PID %d, Nombre: %s, Razón: %s", container.PID, container.Name, reason)
 
-	// Buscar ID del contenedor por PID
-	containerID, err := d.getContainerIDByPID(container.PID)
+	ctx, cancel := context.WithTimeout(ctx, dockerStopTimeout+10*time.Second)
+	defer cancel()
+
+	containerID, err := d.containerIDFor(ctx, &container)
 	if err != nil {
-		log.Printf("Error obteniendo ID del contenedor: %v", err)
+		d.recordError("Error obteniendo ID del contenedor: %v", err)
 		return
 	}
 
-	if containerID != "" {
-		// Detener y eliminar contenedor
-		exec.Command("docker", "stop", containerID).Run()
-		exec.Command("docker", "rm", containerID).Run()
+	if d.config.DryRun {
+		log.Printf("Dry-run: se eliminaría el contenedor %.12s", containerID)
+		d.logContainerAction("WOULD_KILL", container, reason)
+		return
+	}
 
-		// Registrar acción
-		d.logContainerAction("KILLED", container.PID, container.Name, reason)
+	// Detener y eliminar contenedor
+	if err := d.docker.StopContainer(ctx, containerID, dockerStopTimeout); err != nil && !errors.Is(err, ErrNotFound) {
+		d.recordError("Error deteniendo contenedor %.12s: %v", containerID, err)
 	}
+	if err := d.docker.RemoveContainer(ctx, containerID, true); err != nil && !errors.Is(err, ErrNotFound) {
+		d.recordError("Error eliminando contenedor %.12s: %v", containerID, err)
+		d.logContainerAction("KILL_FAILED", container, fmt.Sprintf("%s: %v", reason, err))
+		return
+	}
+
+	// Registrar acción
+	d.lifecycle.markEnding(container, endReasonKilled)
+	d.logContainerAction("KILLED", container, reason)
 }
 
-func (d *Daemon) getContainerIDByPID(pid int) (string, error) {
-	cmd := exec.Command("docker", "ps", "-q", "--no-trunc")
-	output, err := cmd.Output()
+// containerIDFor devuelve el ID del contenedor, resolviéndolo por PID si el
+// cgroup no lo dio, y lo guarda en c.
+func (d *Daemon) containerIDFor(ctx context.Context, c *Container) (string, error) {
+	if c.ContainerID != "" {
+		return c.ContainerID, nil
+	}
+
+	id, err := d.getContainerIDByPID(ctx, c.PID)
 	if err != nil {
 		return "", err
 	}
+	c.ContainerID = id
+	return id, nil
+}
 
-	containerIDs := strings.Split(strings.TrimSpace(string(output)), "\n")
-	for _, id := range containerIDs {
-		if id == "" {
-			continue
-		}
+func (d *Daemon) getContainerIDByPID(ctx context.Context, pid int) (string, error) {
+	// Resolver por cgroup; cubre también procesos hijos del contenedor
+	id, err := d.cgroups.ContainerID(pid)
+	if err == nil {
+		return id, nil
+	}
+	if errors.Is(err, ErrNotInContainer) {
+		return "", err
+	}
 
-		// Obtener PID del contenedor
-		inspectCmd := exec.Command("docker", "inspect", "-f", "{{.State.Pid}}", id)
-		pidOutput, err := inspectCmd.Output()
-		if err != nil {
-			continue
-		}
+	// Sin acceso al cgroup (p. ej. /proc de otro namespace): comparar con el PID init
+	log.Printf("No se pudo resolver el cgroup del PID %d, consultando el engine: %v", pid, err)
+	containers, err := d.docker.ListContainers(ctx, false, nil)
+	if err != nil {
+		return "", err
+	}
 
-		containerPID, err := strconv.Atoi(strings.TrimSpace(string(pidOutput)))
+	for _, summary := range containers {
+		details, err := d.docker.InspectContainer(ctx, summary.ID)
 		if err != nil {
 			continue
 		}
 
-		if containerPID == pid {
-			return id, nil
+		if details.State.Pid == pid {
+			return summary.ID, nil
 		}
 	}
 
 	return "", fmt.Errorf("container not found for PID %d", pid)
 }
 
-func (d *Daemon) logContainerAction(action string, pid int, name, reason string) {
-	query := `INSERT INTO container_actions (action, container_pid, container_name, reason)
-		VALUES (?, ?, ?, ?)`
+func (d *Daemon) logContainerAction(action string, container Container, reason string) {
+	d.recordAction(action, container, "", reason)
+}
+
+// recordAction registra la acción en container_actions; image puede ir vacío.
+func (d *Daemon) recordAction(action string, container Container, image, reason string) {
+	query := `INSERT INTO container_actions (action, container_pid, container_name, container_id, image, reason, iteration_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`
 
-	_, err := d.db.Exec(query, action, pid, name, reason)
+	var iterationID sql.NullInt64
+	if d.iteration != nil {
+		iterationID = sql.NullInt64{Int64: d.iteration.ID, Valid: true}
+	}
+
+	_, err := d.db.Exec(query, action, container.PID, container.Name, nullString(container.ContainerID),
+		nullString(image), reason, iterationID)
 	if err != nil {
 		log.Printf("Error registrando acción del contenedor: %v", err)
 	}
-}
 
-func (d *Daemon) setupSignalHandlers() {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-
-	go func() {
-		<-c
-		log.Println("Recibida señal de terminación, limpiando...")
-		d.cleanup()
-		os.Exit(0)
-	}()
+	d.metrics.observeAction(action)
 }
 
-func (d *Daemon) buildDockerImages() error {
+func (d *Daemon) buildDockerImages(ctx context.Context) error {
 	log.Println("Verificando y construyendo imágenes Docker...")
 
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
 	images := map[string]string{
 		"high-cpu-image":        "high-cpu",
 		"high-ram-image":        "high-ram",
 		"low-consumption-image": "low-consumption",
 	}
 
+	var failed []string
 	for imageName, dirName := range images {
 		// Verificar si la imagen ya existe
-		cmd := exec.Command("docker", "images", "-q", imageName)
-		if output, err := cmd.Output(); err == nil && len(strings.TrimSpace(string(output))) > 0 {
+		found, err := d.docker.ImageExists(ctx, imageName)
+		if err != nil {
+			return fmt.Errorf("error verificando imagen %s: %w", imageName, err)
+		}
+		if found {
 			log.Printf("Imagen %s ya existe", imageName)
 			continue
 		}
@@ -675,37 +1137,17 @@ func (d *Daemon) buildDockerImages() error {
 
 		// Construir la imagen
 		dockerDir := filepath.Join(d.config.BashDir, "docker-images", dirName)
-		cmd = exec.Command("docker", "build", "-t", imageName, ".")
-		cmd.Dir = dockerDir
-
-		output, err := cmd.CombinedOutput()
-		if err != nil {
+		if err := d.docker.BuildImage(ctx, dockerDir, imageName); err != nil {
 			log.Printf("Error construyendo %s: %v", imageName, err)
-			log.Printf("Output: %s", string(output))
+			failed = append(failed, imageName)
 			continue
 		}
 
 		log.Printf("Imagen %s construida exitosamente", imageName)
 	}
 
-	return nil
-}
-func (d *Daemon) cleanup() {
-	// Ejecutar script de limpieza de contenedores
-	log.Println("Ejecutando limpieza de contenedores...")
-	d.executeCleanContainers()
-
-	// Eliminar cronjob
-	if d.cronJobActive {
-		cmd := exec.Command("bash", "-c", fmt.Sprintf("crontab -l | grep -v '%s' | crontab -", d.config.CreateContainersScript))
-		cmd.Run()
-		log.Println("Cronjob eliminado")
+	if len(failed) > 0 {
+		return fmt.Errorf("no se pudieron construir: %s", strings.Join(failed, ", "))
 	}
-
-	// Cerrar base de datos
-	if d.db != nil {
-		d.db.Close()
-	}
-
-	log.Println("Limpieza completada")
+	return nil
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestDaemon crea un daemon con la base migrada en un directorio temporal,
+// con /proc y la raíz de cgroup falsos en proc/ y cgroup/ del mismo directorio.
+// Los pasos de apagado registrados (la base, las alertas) corren al terminar el test.
+func newTestDaemon(t *testing.T) *Daemon {
+	t.Helper()
+
+	dir := t.TempDir()
+	config := defaultConfig()
+	config.DBPath = filepath.Join(dir, "daemon.db")
+	config.CgroupRoot = filepath.Join(dir, "cgroup")
+	d := &Daemon{
+		config:    config,
+		cgroups:   NewCgroupResolver(filepath.Join(dir, "proc")),
+		throttles: make(map[string]*throttleState),
+		anomalies: newAnomalyDetector(),
+		alerts:    newAlertManager(),
+		state:     newRuntimeState(),
+		metrics:   newDaemonMetrics(),
+	}
+	if err := d.initDB(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.shutdown(5 * time.Second) })
+	return d
+}
+
+func TestReloadConfigKeepsEverythingWhenAFileFails(t *testing.T) {
+	dir := t.TempDir()
+	args := []string{"-db-path", filepath.Join(dir, "daemon.db"), "-cpu-threshold", "50"}
+	config, err := LoadConfig(args)
+	if err != nil {
+		t.Fatalf("configuración inicial: %v", err)
+	}
+	policy, err := loadPolicy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Daemon{config: config, args: args, policy: policy, alerts: newAlertManager(), state: newRuntimeState()}
+	d.workload = DefaultWorkloadProfile()
+	d.alerts.rules = DefaultAlertRules()
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	// La política nueva existe (pasa validate) pero no es JSON válido
+	badPolicy := filepath.Join(dir, "policy.json")
+	if err := os.WriteFile(badPolicy, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d.args = []string{"-db-path", filepath.Join(dir, "daemon.db"), "-cpu-threshold", "90", "-policy", badPolicy}
+	d.reloadConfig(ticker)
+
+	if d.config.CPUThreshold != 50 || d.config.PolicyPath != "" {
+		t.Errorf("la recarga fallida aplicó la configuración nueva: cpu-threshold=%d policy=%q",
+			d.config.CPUThreshold, d.config.PolicyPath)
+	}
+	if d.policy != policy {
+		t.Error("la recarga fallida cambió la política")
+	}
+
+	// Sin el archivo roto la recarga se aplica completa
+	d.args = []string{"-db-path", filepath.Join(dir, "daemon.db"), "-cpu-threshold", "90"}
+	d.reloadConfig(ticker)
+
+	if d.config.CPUThreshold != 90 {
+		t.Errorf("cpu-threshold=%d, se esperaba 90", d.config.CPUThreshold)
+	}
+	if d.policy == policy {
+		t.Error("la política no se regeneró con los umbrales nuevos")
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// daemonMetrics guarda el último estado observado y los contadores acumulados
+// que se exponen en /metrics con el formato de texto de Prometheus.
+type daemonMetrics struct {
+	mu sync.Mutex
+
+	system     *SystemInfo
+	containers []containerSample
+
+	kills              int64
+	creations          int64
+	iterationFailures  int64
+	procReadErrors     map[string]int64 // por archivo /proc
+	actionsByType      map[string]int64
+	iterationsTotal    int64
+	lastIterationMS    int64
+	lastIterationStart int64 // segundos Unix
+}
+
+// containerSample es un contenedor con la clase asignada en la última iteración.
+type containerSample struct {
+	Container
+	Class string
+}
+
+func newDaemonMetrics() *daemonMetrics {
+	return &daemonMetrics{
+		procReadErrors: make(map[string]int64),
+		actionsByType:  make(map[string]int64),
+	}
+}
+
+func (m *daemonMetrics) observeSystem(info *SystemInfo) {
+	m.mu.Lock()
+	m.system = info
+	m.mu.Unlock()
+}
+
+// observeClassification reemplaza la lista de contenedores con la última clasificación.
+func (m *daemonMetrics) observeClassification(classification *Classification) {
+	var samples []containerSample
+	for _, class := range classification.Classes {
+		for _, container := range class.Containers {
+			samples = append(samples, containerSample{Container: container, Class: class.Policy.Name})
+		}
+	}
+	for _, container := range classification.Unclassified {
+		samples = append(samples, containerSample{Container: container, Class: "none"})
+	}
+
+	m.mu.Lock()
+	m.containers = samples
+	m.mu.Unlock()
+}
+
+func (m *daemonMetrics) observeAction(action string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.actionsByType[action]++
+	switch action {
+	case "KILLED":
+		m.kills++
+	case "CREATED":
+		m.creations++
+	}
+}
+
+func (m *daemonMetrics) observeProcReadError(path string) {
+	m.mu.Lock()
+	m.procReadErrors[path]++
+	m.mu.Unlock()
+}
+
+func (m *daemonMetrics) observeIteration(record *IterationRecord) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.iterationsTotal++
+	m.lastIterationMS = record.DurationMS
+	m.lastIterationStart = record.StartedAt.Unix()
+	if len(record.Errors) > 0 {
+		m.iterationFailures++
+	}
+}
+
+// ServeHTTP escribe todas las métricas en formato de texto de Prometheus 0.0.4.
+func (m *daemonMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w)
+}
+
+func (m *daemonMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := &promWriter{w: w}
+
+	if m.system != nil {
+		p.family("monitor_memory_total_kb", "gauge", "Memoria total del sistema en KB.")
+		p.sample("monitor_memory_total_kb", nil, float64(m.system.Memory.TotalKB))
+		p.family("monitor_memory_free_kb", "gauge", "Memoria libre del sistema en KB.")
+		p.sample("monitor_memory_free_kb", nil, float64(m.system.Memory.FreeKB))
+		p.family("monitor_memory_used_kb", "gauge", "Memoria usada del sistema en KB.")
+		p.sample("monitor_memory_used_kb", nil, float64(m.system.Memory.UsedKB))
+
+		p.family("monitor_processes", "gauge", "Procesos del sistema por estado.")
+		p.sample("monitor_processes", []string{"state", "total"}, float64(m.system.ProcessSummary.Total))
+		p.sample("monitor_processes", []string{"state", "running"}, float64(m.system.ProcessSummary.Running))
+		p.sample("monitor_processes", []string{"state", "sleeping"}, float64(m.system.ProcessSummary.Sleeping))
+		p.sample("monitor_processes", []string{"state", "other"}, float64(m.system.ProcessSummary.Other))
+	}
+
+	containerGauges := []struct {
+		name, help string
+		value      func(c Container) float64
+	}{
+		{"monitor_container_rss_kb", "RSS del contenedor en KB (proceso y descendientes).", func(c Container) float64 { return float64(c.treeStats().RSSKB) }},
+		{"monitor_container_vsz_kb", "Memoria virtual del contenedor en KB (proceso y descendientes).", func(c Container) float64 { return float64(c.treeStats().VSZKB) }},
+		{"monitor_container_cpu_percent", "Uso de CPU del contenedor en porcentaje (proceso y descendientes).", func(c Container) float64 { return float64(c.treeStats().CPUPercent) }},
+		{"monitor_container_tree_processes", "Procesos en el árbol del contenedor.", func(c Container) float64 { return float64(c.treeStats().Processes) }},
+		{"monitor_container_memory_percent", "Uso de memoria del contenedor en porcentaje.", func(c Container) float64 { return float64(c.MemoryPercent) }},
+	}
+	for _, gauge := range containerGauges {
+		p.family(gauge.name, "gauge", gauge.help)
+		for _, sample := range m.containers {
+			p.sample(gauge.name, containerLabels(sample), gauge.value(sample.Container))
+		}
+	}
+
+	p.family("monitor_container_class", "gauge", "Clase asignada al contenedor (siempre 1).")
+	for _, sample := range m.containers {
+		p.sample("monitor_container_class", containerLabels(sample), 1)
+	}
+
+	p.family("monitor_container_kills_total", "counter", "Contenedores eliminados por el daemon.")
+	p.sample("monitor_container_kills_total", nil, float64(m.kills))
+	p.family("monitor_container_creations_total", "counter", "Contenedores creados por el daemon.")
+	p.sample("monitor_container_creations_total", nil, float64(m.creations))
+
+	p.family("monitor_container_actions_total", "counter", "Acciones registradas en container_actions por tipo.")
+	for _, action := range sortedKeys(m.actionsByType) {
+		p.sample("monitor_container_actions_total", []string{"action", action}, float64(m.actionsByType[action]))
+	}
+
+	p.family("monitor_iterations_total", "counter", "Iteraciones del loop principal.")
+	p.sample("monitor_iterations_total", nil, float64(m.iterationsTotal))
+	p.family("monitor_iteration_failures_total", "counter", "Iteraciones que terminaron con al menos un error.")
+	p.sample("monitor_iteration_failures_total", nil, float64(m.iterationFailures))
+	p.family("monitor_last_iteration_duration_seconds", "gauge", "Duración de la última iteración.")
+	p.sample("monitor_last_iteration_duration_seconds", nil, float64(m.lastIterationMS)/1000)
+	p.family("monitor_last_iteration_timestamp_seconds", "gauge", "Inicio de la última iteración (Unix).")
+	p.sample("monitor_last_iteration_timestamp_seconds", nil, float64(m.lastIterationStart))
+
+	p.family("monitor_proc_read_errors_total", "counter", "Errores leyendo los archivos /proc de los módulos.")
+	for _, path := range sortedKeys(m.procReadErrors) {
+		p.sample("monitor_proc_read_errors_total", []string{"path", path}, float64(m.procReadErrors[path]))
+	}
+}
+
+func containerLabels(sample containerSample) []string {
+	id := sample.ContainerID
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return []string{
+		"pid", strconv.Itoa(sample.PID),
+		"name", sample.Name,
+		"container_id", id,
+		"class", sample.Class,
+	}
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// promWriter escribe líneas HELP/TYPE y muestras con etiquetas escapadas.
+type promWriter struct {
+	w io.Writer
+}
+
+func (p *promWriter) family(name, kind, help string) {
+	fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, promHelpEscaper.Replace(help), name, kind)
+}
+
+// sample escribe una muestra; labels alterna nombre y valor.
+func (p *promWriter) sample(name string, labels []string, value float64) {
+	if len(labels) == 0 {
+		fmt.Fprintf(p.w, "%s %s\n", name, formatPromValue(value))
+		return
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], promLabelEscaper.Replace(labels[i+1])))
+	}
+	fmt.Fprintf(p.w, "%s{%s} %s\n", name, strings.Join(pairs, ","), formatPromValue(value))
+}
+
+// Escapes del formato de texto en valores de etiqueta y en HELP, donde las
+// comillas van sin escapar.
+var (
+	promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	promHelpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func formatPromValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
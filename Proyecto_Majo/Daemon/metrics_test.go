@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPromWriterEscapesLabelsAndHelp(t *testing.T) {
+	var out strings.Builder
+	p := &promWriter{w: &out}
+
+	p.family("monitor_test", "gauge", "Ruta C:\\tmp\ncon \"comillas\".")
+	p.sample("monitor_test", []string{"name", "we\"b\\1\nx", "class", "web"}, 1.5)
+	p.sample("monitor_test", nil, 2)
+
+	want := "# HELP monitor_test Ruta C:\\\\tmp\\ncon \"comillas\".\n" +
+		"# TYPE monitor_test gauge\n" +
+		"monitor_test{name=\"we\\\"b\\\\1\\nx\",class=\"web\"} 1.5\n" +
+		"monitor_test 2\n"
+	if out.String() != want {
+		t.Errorf("salida:\n%s\nse esperaba:\n%s", out.String(), want)
+	}
+}
+
+func TestMetricsExposition(t *testing.T) {
+	m := newDaemonMetrics()
+	m.observeSystem(&SystemInfo{
+		Memory:         MemoryInfo{TotalKB: 1000, FreeKB: 250, UsedKB: 750},
+		ProcessSummary: ProcessSummary{Total: 10, Running: 2, Sleeping: 7, Other: 1},
+	})
+	m.observeClassification(&Classification{
+		Classes: []ClassResult{{
+			Policy:     &ClassPolicy{Name: "web"},
+			Containers: []Container{{PID: 7, Name: "we\"b", RSSKB: 512, ContainerID: strings.Repeat("a", 64)}},
+		}},
+		Unclassified: []Container{{PID: 8, Name: "otro"}},
+	})
+	m.observeAction("KILLED")
+	m.observeAction("THROTTLED")
+	m.observeProcReadError("/proc/sysinfo")
+
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := recorder.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type %q", ct)
+	}
+	body := recorder.Body.String()
+
+	// Cada familia lleva HELP y TYPE una sola vez, antes de sus muestras
+	types := make(map[string]string)
+	helped := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "# HELP "):
+			name := strings.Fields(line)[2]
+			if helped[name] {
+				t.Errorf("HELP repetido para %s", name)
+			}
+			helped[name] = true
+		case strings.HasPrefix(line, "# TYPE "):
+			fields := strings.Fields(line)
+			if len(fields) != 4 || (fields[3] != "gauge" && fields[3] != "counter") {
+				t.Errorf("TYPE inválido: %q", line)
+				continue
+			}
+			if !helped[fields[2]] {
+				t.Errorf("TYPE sin HELP previo para %s", fields[2])
+			}
+			if _, dup := types[fields[2]]; dup {
+				t.Errorf("TYPE repetido para %s", fields[2])
+			}
+			types[fields[2]] = fields[3]
+		default:
+			name := line
+			if i := strings.IndexAny(line, "{ "); i >= 0 {
+				name = line[:i]
+			}
+			if _, ok := types[name]; !ok {
+				t.Errorf("muestra sin TYPE previo: %q", line)
+			}
+		}
+	}
+
+	for name, kind := range map[string]string{
+		"monitor_memory_used_kb":          "gauge",
+		"monitor_container_rss_kb":        "gauge",
+		"monitor_container_kills_total":   "counter",
+		"monitor_container_actions_total": "counter",
+		"monitor_proc_read_errors_total":  "counter",
+	} {
+		if types[name] != kind {
+			t.Errorf("%s con TYPE %q, se esperaba %s", name, types[name], kind)
+		}
+	}
+
+	for _, line := range []string{
+		"monitor_memory_used_kb 750",
+		`monitor_processes{state="other"} 1`,
+		`monitor_container_rss_kb{pid="7",name="we\"b",container_id="aaaaaaaaaaaa",class="web"} 512`,
+		`monitor_container_class{pid="8",name="otro",container_id="",class="none"} 1`,
+		"monitor_container_kills_total 1",
+		`monitor_container_actions_total{action="KILLED"} 1`,
+		`monitor_container_actions_total{action="THROTTLED"} 1`,
+		`monitor_proc_read_errors_total{path="/proc/sysinfo"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("falta la línea %q", line)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Las migraciones viven en migrations/NNNN_descripcion.sql y se aplican en orden.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// migrationState describe una migración conocida y si ya se aplicó.
+type migrationState struct {
+	migration
+	appliedAt string // vacío si está pendiente
+}
+
+// loadMigrations lee las migraciones embebidas en el binario.
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		file := entry.Name()
+		prefix, name, ok := strings.Cut(strings.TrimSuffix(file, ".sql"), "_")
+		if !ok {
+			return nil, fmt.Errorf("nombre de migración inválido: %s", file)
+		}
+
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("nombre de migración inválido: %s", file)
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("versión %d duplicada: %s y %s", version, other, file)
+		}
+		seen[version] = file
+
+		data, err := migrationFiles.ReadFile(path.Join("migrations", file))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: name, sql: string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+func ensureMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	return err
+}
+
+func appliedMigrations(db *sql.DB) (map[int]string, error) {
+	rows, err := db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// migrateUp aplica las migraciones pendientes, cada una en su propia transacción.
+func migrateUp(db *sql.DB) (int, error) {
+	if err := ensureMigrationsTable(db); err != nil {
+		return 0, fmt.Errorf("error creando schema_migrations: %w", err)
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+
+		if err := applyMigration(db, m); err != nil {
+			return count, fmt.Errorf("migración %04d_%s: %w", m.version, m.name, err)
+		}
+		log.Printf("Migración aplicada: %04d_%s", m.version, m.name)
+		count++
+	}
+
+	return count, nil
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.sql); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.version, m.name); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// migrationStatus devuelve todas las migraciones conocidas con su estado.
+func migrationStatus(db *sql.DB) ([]migrationState, error) {
+	if err := ensureMigrationsTable(db); err != nil {
+		return nil, err
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]migrationState, 0, len(migrations))
+	for _, m := range migrations {
+		states = append(states, migrationState{migration: m, appliedAt: applied[m.version]})
+	}
+	return states, nil
+}
+
+// runMigrateCommand implementa "monitor-daemon migrate status|up [flags]".
+func runMigrateCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("uso: migrate status|up [flags]")
+	}
+
+	config, err := LoadConfig(args[1:])
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(config.DBPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		count, err := migrateUp(db)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d migraciones aplicadas en %s\n", count, config.DBPath)
+		return nil
+
+	case "status":
+		states, err := migrationStatus(db)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSIÓN\tNOMBRE\tESTADO\tAPLICADA")
+		for _, state := range states {
+			status := "aplicada"
+			if state.appliedAt == "" {
+				status = "pendiente"
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", state.version, state.name, status, state.appliedAt)
+		}
+		return w.Flush()
+	}
+
+	return fmt.Errorf("subcomando de migrate desconocido: %s", args[0])
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// schema devuelve el SQL de todas las tablas e índices de la base.
+func schema(t *testing.T, db *sql.DB) map[string]string {
+	t.Helper()
+	rows, err := db.Query(`SELECT name, COALESCE(sql, '') FROM sqlite_master`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	objects := make(map[string]string)
+	for rows.Next() {
+		var name, ddl string
+		if err := rows.Scan(&name, &ddl); err != nil {
+			t.Fatal(err)
+		}
+		objects[name] = ddl
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return objects
+}
+
+func TestMigrateUpFromBaselineDatabase(t *testing.T) {
+	// monitoring.db es una base creada por el initDB original, sin schema_migrations
+	data, err := os.ReadFile("monitoring.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "monitoring.db")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := openDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var baselineRows int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM container_metrics`).Scan(&baselineRows); err != nil {
+		t.Fatal(err)
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := migrateUp(db)
+	if err != nil {
+		t.Fatalf("migrateUp: %v", err)
+	}
+	if count != len(migrations) {
+		t.Errorf("%d migraciones aplicadas, se esperaban %d", count, len(migrations))
+	}
+
+	states, err := migrationStatus(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range states {
+		if state.appliedAt == "" {
+			t.Errorf("migración %04d_%s pendiente", state.version, state.name)
+		}
+	}
+
+	// Los datos anteriores se conservan y el esquema nuevo funciona con ellos
+	var rows int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM container_metrics WHERE container_id IS NULL AND iteration_id IS NULL`).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != baselineRows {
+		t.Errorf("%d filas de container_metrics tras migrar, había %d", rows, baselineRows)
+	}
+
+	before := schema(t, db)
+	count, err = migrateUp(db)
+	if err != nil {
+		t.Fatalf("segundo migrateUp: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("el segundo migrateUp aplicó %d migraciones", count)
+	}
+	after := schema(t, db)
+	if len(after) != len(before) {
+		t.Errorf("el segundo migrateUp cambió el esquema: %d objetos, antes %d", len(after), len(before))
+	}
+	for name, ddl := range before {
+		if after[name] != ddl {
+			t.Errorf("el segundo migrateUp cambió %s", name)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+-- Esquema original creado por initDB. IF NOT EXISTS permite adoptar bases
+-- de datos anteriores al sistema de migraciones.
+CREATE TABLE IF NOT EXISTS system_metrics (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	total_memory_kb INTEGER,
+	free_memory_kb INTEGER,
+	used_memory_kb INTEGER,
+	total_processes INTEGER,
+	running_processes INTEGER,
+	sleeping_processes INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS container_metrics (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	pid INTEGER,
+	name TEXT,
+	cmdline TEXT,
+	vsz_kb INTEGER,
+	rss_kb INTEGER,
+	memory_percent INTEGER,
+	cpu_percent INTEGER,
+	status TEXT DEFAULT 'active'
+);
+
+CREATE TABLE IF NOT EXISTS container_actions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	action TEXT,
+	container_pid INTEGER,
+	container_name TEXT,
+	reason TEXT
+);
@@ -0,0 +1,8 @@
+-- ID de contenedor resuelto por cgroup e imagen, para no depender del PID.
+ALTER TABLE container_metrics ADD COLUMN container_id TEXT;
+ALTER TABLE container_actions ADD COLUMN container_id TEXT;
+ALTER TABLE container_actions ADD COLUMN image TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_container_metrics_timestamp ON container_metrics (timestamp);
+CREATE INDEX IF NOT EXISTS idx_container_metrics_container_id ON container_metrics (container_id);
+CREATE INDEX IF NOT EXISTS idx_container_actions_timestamp ON container_actions (timestamp);
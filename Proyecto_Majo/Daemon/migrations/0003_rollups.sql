@@ -0,0 +1,69 @@
+-- Agregados por minuto y por hora de container_metrics y system_metrics.
+-- container_key es el ID del contenedor o, si no se resolvió, nombre:pid.
+CREATE TABLE container_metrics_1m (
+	bucket DATETIME NOT NULL,
+	container_key TEXT NOT NULL,
+	container_id TEXT,
+	name TEXT,
+	samples INTEGER NOT NULL,
+	rss_min_kb INTEGER,
+	rss_avg_kb REAL,
+	rss_max_kb INTEGER,
+	rss_p95_kb INTEGER,
+	cpu_min INTEGER,
+	cpu_avg REAL,
+	cpu_max INTEGER,
+	cpu_p95 INTEGER,
+	PRIMARY KEY (bucket, container_key)
+);
+
+CREATE TABLE container_metrics_1h (
+	bucket DATETIME NOT NULL,
+	container_key TEXT NOT NULL,
+	container_id TEXT,
+	name TEXT,
+	samples INTEGER NOT NULL,
+	rss_min_kb INTEGER,
+	rss_avg_kb REAL,
+	rss_max_kb INTEGER,
+	rss_p95_kb INTEGER,
+	cpu_min INTEGER,
+	cpu_avg REAL,
+	cpu_max INTEGER,
+	cpu_p95 INTEGER,
+	PRIMARY KEY (bucket, container_key)
+);
+
+CREATE TABLE system_metrics_1m (
+	bucket DATETIME PRIMARY KEY,
+	samples INTEGER NOT NULL,
+	total_memory_kb INTEGER,
+	used_memory_min_kb INTEGER,
+	used_memory_avg_kb REAL,
+	used_memory_max_kb INTEGER,
+	used_memory_p95_kb INTEGER,
+	free_memory_avg_kb REAL,
+	total_processes_avg REAL,
+	running_processes_avg REAL
+);
+
+CREATE TABLE system_metrics_1h (
+	bucket DATETIME PRIMARY KEY,
+	samples INTEGER NOT NULL,
+	total_memory_kb INTEGER,
+	used_memory_min_kb INTEGER,
+	used_memory_avg_kb REAL,
+	used_memory_max_kb INTEGER,
+	used_memory_p95_kb INTEGER,
+	free_memory_avg_kb REAL,
+	total_processes_avg REAL,
+	running_processes_avg REAL
+);
+
+-- Último bucket (exclusivo) ya agregado por cada tabla de rollup.
+CREATE TABLE rollup_state (
+	rollup TEXT PRIMARY KEY,
+	processed_until TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_system_metrics_timestamp ON system_metrics (timestamp);
@@ -0,0 +1,20 @@
+-- Una fila por iteración del loop principal; las métricas y acciones de una
+-- misma iteración comparten iteration_id.
+CREATE TABLE iterations (
+	id INTEGER PRIMARY KEY,
+	started_at DATETIME NOT NULL,
+	finished_at DATETIME,
+	duration_ms INTEGER,
+	forced INTEGER NOT NULL DEFAULT 0,
+	containers INTEGER,
+	kills INTEGER,
+	creates INTEGER,
+	errors TEXT
+);
+
+ALTER TABLE system_metrics ADD COLUMN iteration_id INTEGER;
+ALTER TABLE container_metrics ADD COLUMN iteration_id INTEGER;
+ALTER TABLE container_actions ADD COLUMN iteration_id INTEGER;
+
+CREATE INDEX IF NOT EXISTS idx_iterations_started_at ON iterations (started_at);
+CREATE INDEX IF NOT EXISTS idx_container_metrics_iteration ON container_metrics (iteration_id);
@@ -0,0 +1,29 @@
+-- Lista de procesos del host por iteración (todos o solo los N de mayor RSS).
+CREATE TABLE process_metrics (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	iteration_id INTEGER,
+	pid INTEGER,
+	ppid INTEGER,
+	name TEXT,
+	cmdline TEXT,
+	vsz_kb INTEGER,
+	rss_kb INTEGER,
+	memory_percent INTEGER,
+	cpu_percent INTEGER,
+	state TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_process_metrics_timestamp ON process_metrics (timestamp);
+CREATE INDEX IF NOT EXISTS idx_process_metrics_iteration ON process_metrics (iteration_id);
+
+-- Datos del host, una fila por arranque del sistema.
+CREATE TABLE host_boots (
+	boot_id TEXT PRIMARY KEY,
+	hostname TEXT,
+	kernel TEXT,
+	architecture TEXT,
+	first_seen DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE iterations ADD COLUMN boot_id TEXT;
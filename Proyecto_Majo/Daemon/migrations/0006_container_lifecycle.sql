@@ -0,0 +1,21 @@
+-- Un ciclo de vida por contenedor observado: desde que aparece en el módulo
+-- hasta que desaparece o el daemon lo elimina.
+CREATE TABLE container_lifecycle (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	container_key TEXT NOT NULL,
+	container_id TEXT,
+	name TEXT,
+	pid INTEGER,
+	first_seen DATETIME NOT NULL,
+	last_seen DATETIME NOT NULL,
+	samples INTEGER NOT NULL DEFAULT 0,
+	peak_rss_kb INTEGER,
+	peak_cpu_percent INTEGER,
+	class TEXT,
+	class_history TEXT,
+	ended_at DATETIME,
+	end_reason TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_container_lifecycle_open ON container_lifecycle (ended_at);
+CREATE INDEX IF NOT EXISTS idx_container_lifecycle_key ON container_lifecycle (container_key);
@@ -0,0 +1,29 @@
+-- Estado de las tareas programadas del daemon (reemplazan al crontab).
+CREATE TABLE scheduled_jobs (
+	name TEXT PRIMARY KEY,
+	schedule TEXT NOT NULL,
+	next_run_at DATETIME,
+	last_started_at DATETIME,
+	last_finished_at DATETIME,
+	last_status TEXT,
+	last_message TEXT,
+	last_error TEXT,
+	last_duration_ms INTEGER,
+	runs INTEGER NOT NULL DEFAULT 0,
+	failures INTEGER NOT NULL DEFAULT 0,
+	missed INTEGER NOT NULL DEFAULT 0
+);
+
+-- Historial de ejecuciones; status es ok, error o missed.
+CREATE TABLE job_runs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	job TEXT NOT NULL,
+	started_at DATETIME NOT NULL,
+	finished_at DATETIME,
+	duration_ms INTEGER,
+	status TEXT NOT NULL,
+	message TEXT,
+	error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_runs_job ON job_runs (job, started_at);
@@ -0,0 +1,9 @@
+-- Contabilidad cgroup v2 del contenedor completo; NULL si el cgroup no era legible.
+ALTER TABLE container_metrics ADD COLUMN cgroup_memory_kb INTEGER;
+ALTER TABLE container_metrics ADD COLUMN cgroup_anon_kb INTEGER;
+ALTER TABLE container_metrics ADD COLUMN cgroup_file_kb INTEGER;
+ALTER TABLE container_metrics ADD COLUMN cgroup_cpu_usage_usec INTEGER;
+ALTER TABLE container_metrics ADD COLUMN cgroup_cpu_percent REAL;
+ALTER TABLE container_metrics ADD COLUMN cgroup_io_read_bytes INTEGER;
+ALTER TABLE container_metrics ADD COLUMN cgroup_io_write_bytes INTEGER;
+ALTER TABLE container_metrics ADD COLUMN cgroup_pids INTEGER;
@@ -0,0 +1,18 @@
+-- Fugas de memoria y outliers detectados sobre las series de cada contenedor.
+CREATE TABLE anomalies (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	iteration_id INTEGER,
+	container_key TEXT NOT NULL,
+	container_id TEXT,
+	name TEXT,
+	pid INTEGER,
+	kind TEXT NOT NULL,
+	value REAL,
+	baseline REAL,
+	score REAL,
+	detail TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_anomalies_timestamp ON anomalies (timestamp);
+CREATE INDEX IF NOT EXISTS idx_anomalies_container_key ON anomalies (container_key);
@@ -0,0 +1,33 @@
+-- Historial de alertas: una fila por alerta desde que dispara hasta que se resuelve.
+CREATE TABLE alerts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	rule TEXT NOT NULL,
+	series TEXT NOT NULL,
+	labels TEXT,
+	severity TEXT,
+	metric TEXT,
+	value REAL,
+	threshold REAL,
+	started_at DATETIME NOT NULL,
+	resolved_at DATETIME,
+	resolved_value REAL,
+	summary TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_alerts_open ON alerts (resolved_at);
+CREATE INDEX IF NOT EXISTS idx_alerts_started_at ON alerts (started_at);
+
+-- Cada envío al webhook, con los intentos que necesitó.
+CREATE TABLE alert_notifications (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	alert_id INTEGER REFERENCES alerts (id) ON DELETE SET NULL,
+	status TEXT NOT NULL,
+	url TEXT,
+	attempts INTEGER,
+	response_code INTEGER,
+	error TEXT,
+	delivered BOOLEAN
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_notifications_alert ON alert_notifications (alert_id);
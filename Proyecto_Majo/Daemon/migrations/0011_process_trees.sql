@@ -0,0 +1,6 @@
+-- Agregados del árbol de procesos de cada contenedor (proceso reportado y
+-- descendientes); rss_kb, vsz_kb y cpu_percent siguen siendo los del proceso solo.
+ALTER TABLE container_metrics ADD COLUMN tree_processes INTEGER;
+ALTER TABLE container_metrics ADD COLUMN tree_rss_kb INTEGER;
+ALTER TABLE container_metrics ADD COLUMN tree_vsz_kb INTEGER;
+ALTER TABLE container_metrics ADD COLUMN tree_cpu_percent INTEGER;
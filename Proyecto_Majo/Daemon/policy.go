@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Policy define cómo se clasifican los contenedores y cuántos se mantienen por clase.
+// Se carga desde un archivo JSON; ver policy.example.json.
+type Policy struct {
+	// Exclude lista condiciones de contenedores que nunca se gestionan (p. ej. Grafana).
+	Exclude []MatchCondition `json:"exclude"`
+	// Classes se evalúan en orden; un contenedor pertenece a la primera clase que coincide.
+	Classes []ClassPolicy `json:"classes"`
+}
+
+// ClassPolicy es una clase con nombre, sus condiciones y sus límites.
+type ClassPolicy struct {
+	Name string `json:"name"`
+	// Match coincide si cualquiera de las condiciones se cumple. Vacío coincide con todo.
+	Match []MatchCondition `json:"match"`
+	// Target es la cantidad deseada: se crea hasta llegar a ella y se elimina hasta bajar a ella.
+	Target int `json:"target"`
+	// Min es el mínimo antes de crear contenedores nuevos.
+	Min int `json:"min"`
+	// Max es el máximo antes de eliminar contenedores; nil significa sin límite.
+	Max *int `json:"max,omitempty"`
+	// VictimOrder indica qué contenedores se eliminan primero: <campo>_<asc|desc>
+	// con campo rss, vsz, cpu, pid, cgroup_memory, cgroup_cpu o pids.
+	VictimOrder string `json:"victim_order"`
+	// PreferAnomalous elimina primero los contenedores con una fuga o un
+	// outlier reciente; el resto sigue VictimOrder.
+	PreferAnomalous bool `json:"prefer_anomalous,omitempty"`
+}
+
+// MatchCondition coincide si todos sus campos definidos se cumplen. Los límites son inclusivos.
+// RSS, VSZ y CPU se comparan contra el árbol de procesos del contenedor.
+type MatchCondition struct {
+	Name          string `json:"name,omitempty"`    // expresión regular sobre el nombre
+	Cmdline       string `json:"cmdline,omitempty"` // expresión regular sobre la línea de comandos
+	MinRSSKB      *int64 `json:"min_rss_kb,omitempty"`
+	MaxRSSKB      *int64 `json:"max_rss_kb,omitempty"`
+	MinVSZKB      *int64 `json:"min_vsz_kb,omitempty"`
+	MaxVSZKB      *int64 `json:"max_vsz_kb,omitempty"`
+	MinCPUPercent *int   `json:"min_cpu_percent,omitempty"`
+	MaxCPUPercent *int   `json:"max_cpu_percent,omitempty"`
+
+	// Condiciones sobre la contabilidad cgroup v2; no se cumplen si el cgroup no es legible.
+	MinCgroupMemoryKB   *int64   `json:"min_cgroup_memory_kb,omitempty"`
+	MaxCgroupMemoryKB   *int64   `json:"max_cgroup_memory_kb,omitempty"`
+	MinCgroupCPUPercent *float64 `json:"min_cgroup_cpu_percent,omitempty"`
+	MaxCgroupCPUPercent *float64 `json:"max_cgroup_cpu_percent,omitempty"`
+	MinPids             *int64   `json:"min_pids,omitempty"`
+	MaxPids             *int64   `json:"max_pids,omitempty"`
+
+	nameRe    *regexp.Regexp
+	cmdlineRe *regexp.Regexp
+}
+
+// ClassResult agrupa los contenedores de una clase, ordenados de primera a última víctima.
+type ClassResult struct {
+	Policy     *ClassPolicy
+	Containers []Container
+}
+
+// Classification es el resultado de evaluar la política sobre una muestra.
+type Classification struct {
+	Classes      []ClassResult
+	Unclassified []Container
+}
+
+// victimOrders mapea cada campo ordenable a su valor.
+var victimOrders = map[string]func(Container) int64{
+	"rss": func(c Container) int64 { return c.treeStats().RSSKB },
+	"vsz": func(c Container) int64 { return c.treeStats().VSZKB },
+	"cpu": func(c Container) int64 { return int64(c.treeStats().CPUPercent) },
+	"pid": func(c Container) int64 { return int64(c.PID) },
+	// Sin cgroup legible valen cero
+	"cgroup_memory": func(c Container) int64 { return c.cgroupStats().MemoryCurrentKB },
+	"cgroup_cpu":    func(c Container) int64 { return int64(c.cgroupStats().CPUPercent * 100) },
+	"pids":          func(c Container) int64 { return c.cgroupStats().Pids },
+}
+
+// LoadPolicy lee y valida una política desde un archivo JSON.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy Policy
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("error parseando política %s: %w", path, err)
+	}
+
+	if err := policy.compile(); err != nil {
+		return nil, fmt.Errorf("política inválida %s: %w", path, err)
+	}
+	return &policy, nil
+}
+
+// DefaultPolicy reproduce la clasificación fija original: alto consumo si
+// RSS > MemoryThreshold o CPU > CPUThreshold, bajo consumo en otro caso.
+func DefaultPolicy(config *DaemonConfig) *Policy {
+	minRSS := config.MemoryThreshold + 1
+	minCPU := config.CPUThreshold + 1
+	maxHigh := config.MinHighConsumption
+	maxLow := config.MinLowConsumption
+
+	policy := &Policy{
+		Exclude: []MatchCondition{
+			{Name: "(?i)grafana|containerd|dockerd"},
+			{Cmdline: "(?i)grafana"},
+		},
+		Classes: []ClassPolicy{
+			{
+				Name:        "high",
+				Match:       []MatchCondition{{MinRSSKB: &minRSS}, {MinCPUPercent: &minCPU}},
+				Target:      config.MinHighConsumption,
+				Min:         config.MinHighConsumption,
+				Max:         &maxHigh,
+				VictimOrder: "rss_asc",
+			},
+			{
+				Name:        "low",
+				Target:      config.MinLowConsumption,
+				Min:         config.MinLowConsumption,
+				Max:         &maxLow,
+				VictimOrder: "rss_asc",
+			},
+		},
+	}
+
+	if err := policy.compile(); err != nil {
+		// Solo puede fallar con valores de configuración negativos
+		panic(err)
+	}
+	return policy
+}
+
+// compile valida la política y compila sus expresiones regulares.
+func (p *Policy) compile() error {
+	if len(p.Classes) == 0 {
+		return fmt.Errorf("se requiere al menos una clase")
+	}
+
+	for i := range p.Exclude {
+		if err := p.Exclude[i].compile(); err != nil {
+			return fmt.Errorf("exclude[%d]: %w", i, err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i := range p.Classes {
+		class := &p.Classes[i]
+		if class.Name == "" {
+			return fmt.Errorf("classes[%d]: falta el nombre", i)
+		}
+		if seen[class.Name] {
+			return fmt.Errorf("clase %q duplicada", class.Name)
+		}
+		seen[class.Name] = true
+
+		if class.Min < 0 || class.Target < class.Min {
+			return fmt.Errorf("clase %q: se requiere 0 <= min <= target", class.Name)
+		}
+		if class.Max != nil && *class.Max < class.Target {
+			return fmt.Errorf("clase %q: se requiere target <= max", class.Name)
+		}
+
+		if class.VictimOrder == "" {
+			class.VictimOrder = "rss_asc"
+		}
+		if _, _, err := parseVictimOrder(class.VictimOrder); err != nil {
+			return fmt.Errorf("clase %q: %w", class.Name, err)
+		}
+
+		for j := range class.Match {
+			if err := class.Match[j].compile(); err != nil {
+				return fmt.Errorf("clase %q, match[%d]: %w", class.Name, j, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *MatchCondition) compile() error {
+	var err error
+	if m.Name != "" {
+		if m.nameRe, err = regexp.Compile(m.Name); err != nil {
+			return fmt.Errorf("name: %w", err)
+		}
+	}
+	if m.Cmdline != "" {
+		if m.cmdlineRe, err = regexp.Compile(m.Cmdline); err != nil {
+			return fmt.Errorf("cmdline: %w", err)
+		}
+	}
+	return nil
+}
+
+// onlyName indica si la condición solo mira el nombre, así que se decide sin
+// una muestra.
+func (m *MatchCondition) onlyName() bool {
+	return m.nameRe != nil && m.Cmdline == "" &&
+		m.MinRSSKB == nil && m.MaxRSSKB == nil && m.MinVSZKB == nil && m.MaxVSZKB == nil &&
+		m.MinCPUPercent == nil && m.MaxCPUPercent == nil &&
+		m.MinCgroupMemoryKB == nil && m.MaxCgroupMemoryKB == nil &&
+		m.MinCgroupCPUPercent == nil && m.MaxCgroupCPUPercent == nil &&
+		m.MinPids == nil && m.MaxPids == nil
+}
+
+// Matches indica si el contenedor cumple todos los campos definidos de la condición.
+func (m *MatchCondition) Matches(c Container) bool {
+	if m.nameRe != nil && !m.nameRe.MatchString(c.Name) {
+		return false
+	}
+	if m.cmdlineRe != nil && !m.cmdlineRe.MatchString(c.Cmdline) {
+		return false
+	}
+	tree := c.treeStats()
+	if m.MinRSSKB != nil && tree.RSSKB < *m.MinRSSKB {
+		return false
+	}
+	if m.MaxRSSKB != nil && tree.RSSKB > *m.MaxRSSKB {
+		return false
+	}
+	if m.MinVSZKB != nil && tree.VSZKB < *m.MinVSZKB {
+		return false
+	}
+	if m.MaxVSZKB != nil && tree.VSZKB > *m.MaxVSZKB {
+		return false
+	}
+	if m.MinCPUPercent != nil && tree.CPUPercent < *m.MinCPUPercent {
+		return false
+	}
+	if m.MaxCPUPercent != nil && tree.CPUPercent > *m.MaxCPUPercent {
+		return false
+	}
+	return m.matchesCgroup(c.Cgroup)
+}
+
+func (m *MatchCondition) matchesCgroup(cg *CgroupStats) bool {
+	if m.MinCgroupMemoryKB == nil && m.MaxCgroupMemoryKB == nil && m.MinCgroupCPUPercent == nil &&
+		m.MaxCgroupCPUPercent == nil && m.MinPids == nil && m.MaxPids == nil {
+		return true
+	}
+	if cg == nil {
+		return false
+	}
+
+	if m.MinCgroupMemoryKB != nil && cg.MemoryCurrentKB < *m.MinCgroupMemoryKB {
+		return false
+	}
+	if m.MaxCgroupMemoryKB != nil && cg.MemoryCurrentKB > *m.MaxCgroupMemoryKB {
+		return false
+	}
+	if m.MinCgroupCPUPercent != nil && cg.CPUPercent < *m.MinCgroupCPUPercent {
+		return false
+	}
+	if m.MaxCgroupCPUPercent != nil && cg.CPUPercent > *m.MaxCgroupCPUPercent {
+		return false
+	}
+	if m.MinPids != nil && cg.Pids < *m.MinPids {
+		return false
+	}
+	if m.MaxPids != nil && cg.Pids > *m.MaxPids {
+		return false
+	}
+	return true
+}
+
+// Excluded indica si el contenedor debe quedar fuera de la gestión.
+func (p *Policy) Excluded(c Container) bool {
+	for i := range p.Exclude {
+		if p.Exclude[i].Matches(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// Classify asigna cada contenedor a la primera clase que coincide y ordena
+// cada clase según su VictimOrder.
+func (p *Policy) Classify(containers []Container) *Classification {
+	result := &Classification{Classes: make([]ClassResult, len(p.Classes))}
+	for i := range p.Classes {
+		result.Classes[i].Policy = &p.Classes[i]
+	}
+
+	for _, container := range containers {
+		index := p.classIndex(container)
+		if index < 0 {
+			result.Unclassified = append(result.Unclassified, container)
+			continue
+		}
+		result.Classes[index].Containers = append(result.Classes[index].Containers, container)
+	}
+
+	for i := range result.Classes {
+		sortVictims(result.Classes[i].Containers, result.Classes[i].Policy.VictimOrder)
+	}
+
+	return result
+}
+
+func (p *Policy) classIndex(c Container) int {
+	for i := range p.Classes {
+		class := &p.Classes[i]
+		if len(class.Match) == 0 {
+			return i
+		}
+		for j := range class.Match {
+			if class.Match[j].Matches(c) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func (p *Policy) classByName(name string) *ClassPolicy {
+	for i := range p.Classes {
+		if p.Classes[i].Name == name {
+			return &p.Classes[i]
+		}
+	}
+	return nil
+}
+
+// unreachable explica por qué un contenedor llamado name nunca quedaría en la
+// clase index, o devuelve vacío si puede quedar. Solo se usa el nombre: las
+// condiciones con otros campos dependen de la muestra y se asume que pueden
+// cumplirse o no.
+func (p *Policy) unreachable(index int, name string) string {
+	for i := range p.Exclude {
+		if p.Exclude[i].onlyName() && p.Exclude[i].nameRe.MatchString(name) {
+			return fmt.Sprintf("quedan excluidos por exclude[%d]", i)
+		}
+	}
+
+	// Las clases anteriores que coinciden solo por nombre se los quedan siempre
+	for i := 0; i < index; i++ {
+		class := &p.Classes[i]
+		if len(class.Match) == 0 {
+			return fmt.Sprintf("quedan en la clase %s, que acepta cualquier contenedor", class.Name)
+		}
+		for j := range class.Match {
+			if class.Match[j].onlyName() && class.Match[j].nameRe.MatchString(name) {
+				return fmt.Sprintf("quedan en la clase %s por su match[%d]", class.Name, j)
+			}
+		}
+	}
+
+	class := &p.Classes[index]
+	if len(class.Match) == 0 {
+		return ""
+	}
+	for j := range class.Match {
+		if class.Match[j].nameRe == nil || class.Match[j].nameRe.MatchString(name) {
+			return ""
+		}
+	}
+	return "no coinciden con el name de ninguna condición de la clase"
+}
+
+// byKey indexa todos los contenedores de la clasificación por lifecycleKey.
+func (c *Classification) byKey() map[string]Container {
+	containers := make(map[string]Container)
+	for _, class := range c.Classes {
+		for _, container := range class.Containers {
+			containers[lifecycleKey(container)] = container
+		}
+	}
+	for _, container := range c.Unclassified {
+		containers[lifecycleKey(container)] = container
+	}
+	return containers
+}
+
+// Excess devuelve los contenedores a eliminar: si la clase supera Max, los
+// primeros en orden de víctima hasta bajar a Target.
+func (r *ClassResult) Excess() []Container {
+	if r.Policy.Max == nil || len(r.Containers) <= *r.Policy.Max {
+		return nil
+	}
+	return r.Containers[:len(r.Containers)-r.Policy.Target]
+}
+
+// Deficit devuelve cuántos contenedores faltan para llegar a Target si la clase está bajo Min.
+func (r *ClassResult) Deficit() int {
+	if len(r.Containers) >= r.Policy.Min {
+		return 0
+	}
+	return r.Policy.Target - len(r.Containers)
+}
+
+func parseVictimOrder(order string) (string, bool, error) {
+	i := strings.LastIndex(order, "_")
+	if i < 0 {
+		return "", false, fmt.Errorf("victim_order inválido %q", order)
+	}
+
+	field, direction := order[:i], order[i+1:]
+	if _, ok := victimOrders[field]; !ok {
+		return "", false, fmt.Errorf("victim_order: campo desconocido %q", field)
+	}
+	if direction != "asc" && direction != "desc" {
+		return "", false, fmt.Errorf("victim_order: dirección desconocida %q", direction)
+	}
+	return field, direction == "desc", nil
+}
+
+func sortVictims(containers []Container, order string) {
+	field, desc, err := parseVictimOrder(order)
+	if err != nil {
+		return
+	}
+
+	value := victimOrders[field]
+	sort.SliceStable(containers, func(i, j int) bool {
+		if desc {
+			return value(containers[i]) > value(containers[j])
+		}
+		return value(containers[i]) < value(containers[j])
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+// ProcessTreeStats suma el proceso que reporta el módulo y sus descendientes
+// del mismo contenedor.
+type ProcessTreeStats struct {
+	Processes  int   `json:"processes"`
+	RSSKB      int64 `json:"rss_kb"`
+	VSZKB      int64 `json:"vsz_kb"`
+	CPUPercent int   `json:"cpu_percent"`
+}
+
+// treeStats devuelve los valores agregados del árbol, o los del proceso solo
+// si no se pudo reconstruir.
+func (c Container) treeStats() ProcessTreeStats {
+	if c.Tree == nil {
+		return ProcessTreeStats{Processes: 1, RSSKB: c.RSSKB, VSZKB: c.VSZKB, CPUPercent: c.CPUPercent}
+	}
+	return *c.Tree
+}
+
+// attributeProcessTrees reconstruye el árbol de procesos con PID/PPID y suma
+// a cada contenedor los descendientes de su proceso. El recorrido no entra en
+// procesos de otro contenedor (el ID de cgroup cambia) ni en los que el
+// módulo ya reporta como contenedores, que agregan su propio subárbol: así
+// ningún proceso se cuenta dos veces.
+func (d *Daemon) attributeProcessTrees(processes []Process, containers []Container) {
+	if len(processes) == 0 {
+		return
+	}
+
+	children := make(map[int][]int, len(processes))
+	byPID := make(map[int]*Process, len(processes))
+	for i := range processes {
+		p := &processes[i]
+		byPID[p.PID] = p
+		if p.PPID != p.PID {
+			children[p.PPID] = append(children[p.PPID], p.PID)
+		}
+	}
+
+	roots := make(map[int]bool, len(containers))
+	for _, c := range containers {
+		roots[c.PID] = true
+	}
+
+	for i := range containers {
+		c := &containers[i]
+		tree := ProcessTreeStats{Processes: 1, RSSKB: c.RSSKB, VSZKB: c.VSZKB, CPUPercent: c.CPUPercent}
+
+		visited := map[int]bool{c.PID: true}
+		pending := append([]int(nil), children[c.PID]...)
+		for len(pending) > 0 {
+			pid := pending[len(pending)-1]
+			pending = pending[:len(pending)-1]
+			if visited[pid] || roots[pid] {
+				continue
+			}
+			visited[pid] = true
+
+			p, ok := byPID[pid]
+			if !ok || d.processContainerID(pid) != c.ContainerID {
+				continue
+			}
+			tree.Processes++
+			tree.RSSKB += p.RSSKB
+			tree.VSZKB += p.VSZKB
+			tree.CPUPercent += p.CPUPercent
+			pending = append(pending, children[pid]...)
+		}
+		c.Tree = &tree
+	}
+}
+
+// processContainerID devuelve el contenedor del proceso, o vacío si es del host.
+func (d *Daemon) processContainerID(pid int) string {
+	id, err := d.cgroups.ContainerID(pid)
+	if err != nil {
+		return ""
+	}
+	return id
+}
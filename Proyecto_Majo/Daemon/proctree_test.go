@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAttributeProcessTrees(t *testing.T) {
+	idA, idB, idC, idE := strings.Repeat("a", 64), strings.Repeat("b", 64), strings.Repeat("c", 64), strings.Repeat("e", 64)
+
+	procRoot := t.TempDir()
+	cgroups := map[int]string{
+		100: idA, 101: idA, 102: idA, 104: idA,
+		103: idB,
+		106: "",
+		110: idC, 111: idC,
+		120: idA,
+		150: idE, 151: idE, 152: idE,
+	}
+	for pid, id := range cgroups {
+		content := "0::/user.slice/session-1.scope\n"
+		if id != "" {
+			content = "0::/system.slice/docker-" + id + ".scope\n"
+		}
+		dir := filepath.Join(procRoot, strconv.Itoa(pid))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "cgroup"), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := &Daemon{cgroups: NewCgroupResolver(procRoot)}
+
+	process := func(pid, ppid int, rssKB int64) Process {
+		return Process{PID: pid, PPID: ppid, RSSKB: rssKB, VSZKB: 2 * rssKB, CPUPercent: 1}
+	}
+	processes := []Process{
+		process(1, 1, 1<<20),   // se declara su propio padre
+		process(100, 1, 1<<20), // raíz de A, ya reportada como contenedor
+		process(101, 100, 1),   // hijo de A
+		process(102, 101, 2),   // nieto de A
+		process(103, 100, 4),   // hijo de A en otro cgroup (B): el recorrido se corta
+		process(104, 103, 8),   // de A, pero bajo un proceso de B: no se alcanza
+		process(105, 100, 16),  // sin /proc/<pid>/cgroup (terminó): no se cuenta
+		process(106, 100, 32),  // proceso del host bajo la raíz de A
+		process(110, 100, 1<<20),
+		process(111, 110, 64),  // de C, no de A
+		process(120, 999, 128), // su padre no está en la lista
+		process(150, 152, 1<<20),
+		process(151, 150, 256), // ciclo 150 -> 151 -> 152 -> 150
+		process(152, 151, 512),
+	}
+	containers := []Container{
+		{PID: 100, Name: "a", ContainerID: idA, RSSKB: 1000, VSZKB: 2000, CPUPercent: 10},
+		{PID: 110, Name: "c", ContainerID: idC, RSSKB: 3000, VSZKB: 6000, CPUPercent: 30},
+		{PID: 150, Name: "e", ContainerID: idE, RSSKB: 5000, VSZKB: 10000, CPUPercent: 50},
+		{PID: 160, Name: "sin-procesos", ContainerID: idB, RSSKB: 7000},
+	}
+
+	d.attributeProcessTrees(processes, containers)
+
+	want := map[string]ProcessTreeStats{
+		"a":            {Processes: 3, RSSKB: 1000 + 1 + 2, VSZKB: 2000 + 2*(1+2), CPUPercent: 12},
+		"c":            {Processes: 2, RSSKB: 3000 + 64, VSZKB: 6000 + 2*64, CPUPercent: 31},
+		"e":            {Processes: 3, RSSKB: 5000 + 256 + 512, VSZKB: 10000 + 2*(256+512), CPUPercent: 52},
+		"sin-procesos": {Processes: 1, RSSKB: 7000},
+	}
+	for _, c := range containers {
+		if c.Tree == nil {
+			t.Errorf("%s sin árbol", c.Name)
+			continue
+		}
+		if *c.Tree != want[c.Name] {
+			t.Errorf("%s: árbol %+v, se esperaba %+v", c.Name, *c.Tree, want[c.Name])
+		}
+	}
+}
+
+func TestAttributeProcessTreesWithoutProcessList(t *testing.T) {
+	d := &Daemon{cgroups: NewCgroupResolver(t.TempDir())}
+	containers := []Container{{PID: 100, RSSKB: 1000, CPUPercent: 10}}
+
+	d.attributeProcessTrees(nil, containers)
+
+	if containers[0].Tree != nil {
+		t.Fatalf("árbol %+v sin lista de procesos", *containers[0].Tree)
+	}
+	if stats := containers[0].treeStats(); stats.Processes != 1 || stats.RSSKB != 1000 || stats.CPUPercent != 10 {
+		t.Errorf("treeStats sin árbol = %+v, se esperaban los valores del proceso", stats)
+	}
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+// sqliteTimeFormat es el formato de CURRENT_TIMESTAMP; las comparaciones de
+// fechas en SQL son lexicográficas, así que todos los límites usan este formato.
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+// rollupChunk limita cuántas horas de datos crudos se cargan en memoria a la vez.
+const rollupChunk = 6 * time.Hour
+
+// rollupSpec describe una tabla de agregados y el tamaño de su bucket.
+type rollupSpec struct {
+	table  string
+	source string
+	bucket time.Duration
+}
+
+var rollups = []rollupSpec{
+	{table: "container_metrics_1m", source: "container_metrics", bucket: time.Minute},
+	{table: "container_metrics_1h", source: "container_metrics", bucket: time.Hour},
+	{table: "system_metrics_1m", source: "system_metrics", bucket: time.Minute},
+	{table: "system_metrics_1h", source: "system_metrics", bucket: time.Hour},
+}
+
+// seriesStats son los agregados de una serie de valores enteros.
+type seriesStats struct {
+	min, max, p95 int64
+	avg           float64
+}
+
+func computeStats(values []int64) seriesStats {
+	sorted := append([]int64(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum int64
+	for _, v := range sorted {
+		sum += v
+	}
+
+	// Percentil 95 por rango más cercano
+	rank := int(math.Ceil(0.95*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+
+	return seriesStats{
+		min: sorted[0],
+		max: sorted[len(sorted)-1],
+		p95: sorted[rank],
+		avg: float64(sum) / float64(len(sorted)),
+	}
+}
+
+// runRetention agrega los buckets completos, purga lo vencido y libera páginas.
+func (d *Daemon) runRetention(ctx context.Context, now time.Time) error {
+	for _, spec := range rollups {
+		if err := d.rollup(ctx, spec, now); err != nil {
+			return fmt.Errorf("rollup %s: %w", spec.table, err)
+		}
+	}
+
+	deleted, err := d.pruneMetrics(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		if _, err := d.db.ExecContext(ctx, `PRAGMA incremental_vacuum`); err != nil {
+			return fmt.Errorf("incremental_vacuum: %w", err)
+		}
+		log.Printf("Retención: %d filas purgadas", deleted)
+	}
+	return nil
+}
+
+// rollup agrega los buckets completos pendientes de una tabla.
+func (d *Daemon) rollup(ctx context.Context, spec rollupSpec, now time.Time) error {
+	committed, ok, err := d.committedUntil(ctx, now)
+	if err != nil || !ok {
+		return err
+	}
+	upTo := committed.Truncate(spec.bucket)
+
+	from, ok, err := d.rollupStart(ctx, spec)
+	if err != nil || !ok {
+		return err
+	}
+
+	for from.Before(upTo) {
+		to := from.Add(rollupChunk)
+		if to.After(upTo) {
+			to = upTo
+		}
+
+		if spec.source == "container_metrics" {
+			err = d.rollupContainerWindow(ctx, spec, from, to)
+		} else {
+			err = d.rollupSystemWindow(ctx, spec, from, to)
+		}
+		if err != nil {
+			return err
+		}
+		from = to
+	}
+	return nil
+}
+
+// rollupStart devuelve desde dónde continuar: el último límite guardado o el
+// inicio del bucket de la fila cruda más antigua.
+func (d *Daemon) rollupStart(ctx context.Context, spec rollupSpec) (time.Time, bool, error) {
+	processed, ok, err := d.rollupProcessedUntil(ctx, spec.table)
+	if err != nil || ok {
+		return processed, ok, err
+	}
+
+	var oldest sql.NullString
+	if err := d.db.QueryRowContext(ctx, `SELECT MIN(strftime('%Y-%m-%d %H:%M:%S', timestamp)) FROM `+spec.source).Scan(&oldest); err != nil {
+		return time.Time{}, false, err
+	}
+	if !oldest.Valid {
+		return time.Time{}, false, nil
+	}
+
+	t, err := parseSQLiteTime(oldest.String)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return t.Truncate(spec.bucket), true, nil
+}
+
+type containerBucket struct {
+	bucket      time.Time
+	key         string
+	containerID sql.NullString
+	name        string
+	rss, cpu    []int64 // una entrada por muestra
+	lastSample  string  // timestamp de la última muestra agregada
+}
+
+// rollupContainerWindow agrega por contenedor. Las filas de un mismo
+// contenedor en una muestra (varios procesos con el mismo ID) se suman antes
+// de calcular los estadísticos, igual que la serie cruda de /grafana.
+func (d *Daemon) rollupContainerWindow(ctx context.Context, spec rollupSpec, from, to time.Time) error {
+	rows, err := d.db.QueryContext(ctx, `SELECT strftime('%Y-%m-%d %H:%M:%S', timestamp),
+			COALESCE(container_id, name || ':' || pid), container_id, name,
+			COALESCE(tree_rss_kb, rss_kb), COALESCE(tree_cpu_percent, cpu_percent)
+		FROM container_metrics
+		WHERE timestamp >= ? AND timestamp < ?
+		ORDER BY timestamp`,
+		from.Format(sqliteTimeFormat), to.Format(sqliteTimeFormat))
+	if err != nil {
+		return err
+	}
+
+	buckets := make(map[string]*containerBucket)
+	var order []string
+	for rows.Next() {
+		var ts, key, name string
+		var containerID sql.NullString
+		var rss, cpu int64
+		if err := rows.Scan(&ts, &key, &containerID, &name, &rss, &cpu); err != nil {
+			rows.Close()
+			return err
+		}
+
+		t, err := parseSQLiteTime(ts)
+		if err != nil {
+			continue
+		}
+		bucket := t.Truncate(spec.bucket)
+
+		id := bucket.Format(sqliteTimeFormat) + "|" + key
+		b, ok := buckets[id]
+		if !ok {
+			b = &containerBucket{bucket: bucket, key: key, containerID: containerID, name: name}
+			buckets[id] = b
+			order = append(order, id)
+		}
+		if b.lastSample == ts {
+			b.rss[len(b.rss)-1] += rss
+			b.cpu[len(b.cpu)-1] += cpu
+			continue
+		}
+		b.lastSample = ts
+		b.rss = append(b.rss, rss)
+		b.cpu = append(b.cpu, cpu)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO ` + spec.table + `
+		(bucket, container_key, container_id, name, samples,
+		 rss_min_kb, rss_avg_kb, rss_max_kb, rss_p95_kb, cpu_min, cpu_avg, cpu_max, cpu_p95)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, id := range order {
+		b := buckets[id]
+		rss, cpu := computeStats(b.rss), computeStats(b.cpu)
+		if _, err := stmt.Exec(b.bucket.Format(sqliteTimeFormat), b.key, b.containerID, b.name, len(b.rss),
+			rss.min, rss.avg, rss.max, rss.p95, cpu.min, cpu.avg, cpu.max, cpu.p95); err != nil {
+			return err
+		}
+	}
+
+	if err := saveRollupState(tx, spec.table, to); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type systemBucket struct {
+	bucket                 time.Time
+	totalMemory            int64
+	used, free, total, run []int64
+}
+
+func (d *Daemon) rollupSystemWindow(ctx context.Context, spec rollupSpec, from, to time.Time) error {
+	rows, err := d.db.QueryContext(ctx, `SELECT strftime('%Y-%m-%d %H:%M:%S', timestamp),
+			total_memory_kb, used_memory_kb, free_memory_kb, total_processes, running_processes
+		FROM system_metrics
+		WHERE timestamp >= ? AND timestamp < ?`,
+		from.Format(sqliteTimeFormat), to.Format(sqliteTimeFormat))
+	if err != nil {
+		return err
+	}
+
+	buckets := make(map[time.Time]*systemBucket)
+	var order []time.Time
+	for rows.Next() {
+		var ts string
+		var totalMemory, used, free, total, running int64
+		if err := rows.Scan(&ts, &totalMemory, &used, &free, &total, &running); err != nil {
+			rows.Close()
+			return err
+		}
+
+		t, err := parseSQLiteTime(ts)
+		if err != nil {
+			continue
+		}
+		bucket := t.Truncate(spec.bucket)
+
+		b, ok := buckets[bucket]
+		if !ok {
+			b = &systemBucket{bucket: bucket}
+			buckets[bucket] = b
+			order = append(order, bucket)
+		}
+		if totalMemory > b.totalMemory {
+			b.totalMemory = totalMemory
+		}
+		b.used = append(b.used, used)
+		b.free = append(b.free, free)
+		b.total = append(b.total, total)
+		b.run = append(b.run, running)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO ` + spec.table + `
+		(bucket, samples, total_memory_kb, used_memory_min_kb, used_memory_avg_kb, used_memory_max_kb,
+		 used_memory_p95_kb, free_memory_avg_kb, total_processes_avg, running_processes_avg)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, bucket := range order {
+		b := buckets[bucket]
+		used := computeStats(b.used)
+		if _, err := stmt.Exec(b.bucket.Format(sqliteTimeFormat), len(b.used), b.totalMemory,
+			used.min, used.avg, used.max, used.p95,
+			computeStats(b.free).avg, computeStats(b.total).avg, computeStats(b.run).avg); err != nil {
+			return err
+		}
+	}
+
+	if err := saveRollupState(tx, spec.table, to); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// committedUntil devuelve hasta dónde las métricas crudas están completas: el
+// inicio de la última iteración confirmada. Las filas toman su timestamp al
+// insertarse, dentro de la transacción de la iteración, así que una iteración
+// en curso puede tener filas con timestamp anterior a now que todavía no son
+// visibles; las de iteraciones anteriores a la última confirmada ya lo son.
+// Sin iteraciones confirmadas no hay nada que agregar.
+func (d *Daemon) committedUntil(ctx context.Context, now time.Time) (time.Time, bool, error) {
+	var started sql.NullString
+	if err := d.db.QueryRowContext(ctx, `SELECT MAX(started_at) FROM iterations`).Scan(&started); err != nil {
+		return time.Time{}, false, err
+	}
+	if !started.Valid {
+		return time.Time{}, false, nil
+	}
+	t, err := parseSQLiteTime(started.String)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if t.After(now) {
+		t = now
+	}
+	return t, true, nil
+}
+
+// rollupProcessedUntil devuelve el límite (exclusivo) hasta el que la tabla
+// ya tiene buckets completos; false si todavía no se agregó nada.
+func (d *Daemon) rollupProcessedUntil(ctx context.Context, table string) (time.Time, bool, error) {
+	var processed sql.NullString
+	err := d.db.QueryRowContext(ctx, `SELECT processed_until FROM rollup_state WHERE rollup = ?`, table).Scan(&processed)
+	if err != nil && err != sql.ErrNoRows {
+		return time.Time{}, false, err
+	}
+	if !processed.Valid {
+		return time.Time{}, false, nil
+	}
+	t, err := parseSQLiteTime(processed.String)
+	return t, err == nil, err
+}
+
+func saveRollupState(tx *sql.Tx, table string, until time.Time) error {
+	_, err := tx.Exec(`INSERT OR REPLACE INTO rollup_state (rollup, processed_until) VALUES (?, ?)`,
+		table, until.Format(sqliteTimeFormat))
+	return err
+}
+
+// pruneMetrics borra filas vencidas. Los datos crudos nunca se borran antes de
+// haber sido agregados por todos sus rollups.
+func (d *Daemon) pruneMetrics(ctx context.Context, now time.Time) (int64, error) {
+	var total int64
+
+	for _, source := range []string{"container_metrics", "system_metrics"} {
+		cutoff := now.Add(-d.config.RetentionRaw)
+		for _, spec := range rollups {
+			if spec.source != source {
+				continue
+			}
+			var processed sql.NullString
+			err := d.db.QueryRowContext(ctx, `SELECT processed_until FROM rollup_state WHERE rollup = ?`, spec.table).Scan(&processed)
+			if err != nil && err != sql.ErrNoRows {
+				return total, err
+			}
+			if !processed.Valid {
+				// Todavía no se agregó nada: no se purga
+				cutoff = time.Time{}
+				break
+			}
+			if t, err := parseSQLiteTime(processed.String); err == nil && t.Before(cutoff) {
+				cutoff = t
+			}
+		}
+		if cutoff.IsZero() {
+			continue
+		}
+
+		n, err := execCount(ctx, d.db, `DELETE FROM `+source+` WHERE timestamp < ?`, cutoff.Format(sqliteTimeFormat))
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+
+	// Los procesos, los resúmenes de iteración y el historial de tareas siguen la retención de los datos crudos
+	rawCutoff := now.Add(-d.config.RetentionRaw).Format(sqliteTimeFormat)
+	for _, query := range []string{
+		`DELETE FROM process_metrics WHERE timestamp < ?`,
+		`DELETE FROM iterations WHERE started_at < ?`,
+		`DELETE FROM job_runs WHERE started_at < ?`,
+	} {
+		n, err := execCount(ctx, d.db, query, rawCutoff)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+
+	// Los ciclos de vida terminados, las anomalías y las alertas resueltas se
+	// conservan tanto como los agregados por hora
+	hourCutoff := now.Add(-d.config.Retention1h).Format(sqliteTimeFormat)
+	for _, query := range []string{
+		`DELETE FROM container_lifecycle WHERE ended_at < ?`,
+		`DELETE FROM anomalies WHERE timestamp < ?`,
+		`DELETE FROM alert_notifications WHERE timestamp < ?`,
+		`DELETE FROM alerts WHERE resolved_at < ?`,
+	} {
+		n, err := execCount(ctx, d.db, query, hourCutoff)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+
+	for _, spec := range rollups {
+		retention := d.config.Retention1m
+		if spec.bucket == time.Hour {
+			retention = d.config.Retention1h
+		}
+
+		n, err := execCount(ctx, d.db, `DELETE FROM `+spec.table+` WHERE bucket < ?`, now.Add(-retention).Format(sqliteTimeFormat))
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+
+	return total, nil
+}
+
+func execCount(ctx context.Context, db *sql.DB, query string, args ...interface{}) (int64, error) {
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ensureIncrementalVacuum activa auto_vacuum=INCREMENTAL. En bases de datos
+// existentes el cambio solo tiene efecto tras un VACUUM completo, que se hace una vez.
+func ensureIncrementalVacuum(ctx context.Context, db *sql.DB) error {
+	// PRAGMA y VACUUM deben ejecutarse sobre la misma conexión
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var mode int
+	if err := conn.QueryRowContext(ctx, `PRAGMA auto_vacuum`).Scan(&mode); err != nil {
+		return err
+	}
+	if mode == 2 {
+		return nil
+	}
+
+	log.Println("Activando auto_vacuum incremental (VACUUM completo, solo una vez)...")
+	if _, err := conn.ExecContext(ctx, `PRAGMA auto_vacuum = INCREMENTAL`); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, `VACUUM`)
+	return err
+}
+
+// parseSQLiteTime acepta el formato de CURRENT_TIMESTAMP y el RFC 3339 con el
+// que el driver devuelve las columnas DATETIME.
+func parseSQLiteTime(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation(sqliteTimeFormat, value, time.UTC); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// recordIteration registra una iteración confirmada que empezó en startedAt.
+func recordIteration(t *testing.T, d *Daemon, startedAt time.Time) {
+	t.Helper()
+	_, err := d.db.Exec(`INSERT INTO iterations (id, started_at) VALUES ((SELECT COALESCE(MAX(id), 0) + 1 FROM iterations), ?)`,
+		startedAt.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRollupStopsAtLastCommittedIteration(t *testing.T) {
+	d := newTestDaemon(t)
+	ctx := context.Background()
+
+	base := time.Now().UTC().Truncate(time.Minute).Add(-10 * time.Minute)
+	for _, offset := range []time.Duration{0, 2 * time.Minute, 4 * time.Minute} {
+		_, err := d.db.Exec(`INSERT INTO container_metrics (timestamp, pid, name, rss_kb, cpu_percent)
+			VALUES (?, 10, 'web', 100, 5)`, base.Add(offset).Format(sqliteTimeFormat))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Sin iteraciones confirmadas no se agrega nada
+	if err := d.rollup(ctx, rollups[0], time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := d.rollupProcessedUntil(ctx, rollups[0].table); err != nil || ok {
+		t.Fatalf("se agregó sin iteraciones confirmadas (ok=%v, err=%v)", ok, err)
+	}
+
+	// La última iteración confirmada empezó a mitad del minuto base+3: lo
+	// posterior puede pertenecer a una iteración que todavía no confirmó
+	recordIteration(t, d, base.Add(3*time.Minute+30*time.Second))
+	if err := d.rollup(ctx, rollups[0], time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	processed, ok, err := d.rollupProcessedUntil(ctx, rollups[0].table)
+	if err != nil || !ok {
+		t.Fatalf("sin estado del rollup (ok=%v, err=%v)", ok, err)
+	}
+	if want := base.Add(3 * time.Minute); !processed.Equal(want) {
+		t.Errorf("processed_until = %v, se esperaba %v", processed, want)
+	}
+	var buckets int
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM container_metrics_1m`).Scan(&buckets); err != nil {
+		t.Fatal(err)
+	}
+	if buckets != 2 {
+		t.Errorf("%d buckets agregados, se esperaban 2", buckets)
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxMissedCount acota cuántas activaciones perdidas se cuentan tras una caída larga.
+const maxMissedCount = 10000
+
+// Schedule calcula la próxima activación estrictamente posterior a t.
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// everySchedule implementa "@every <duración>".
+type everySchedule struct {
+	interval time.Duration
+}
+
+func (s everySchedule) Next(t time.Time) time.Time {
+	return t.Add(s.interval)
+}
+
+// cronSchedule es una expresión cron estándar de 5 campos; cada campo es un
+// conjunto de bits con los valores permitidos.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// Como en cron, si día del mes y día de la semana están restringidos basta
+	// con que coincida uno de los dos
+	domAny, dowAny bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	monthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	weekdayNames = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+
+	cronFields = []cronField{
+		{name: "minuto", min: 0, max: 59},
+		{name: "hora", min: 0, max: 23},
+		{name: "día del mes", min: 1, max: 31},
+		{name: "mes", min: 1, max: 12, names: monthNames},
+		{name: "día de la semana", min: 0, max: 7, names: weekdayNames}, // 0 y 7 son domingo
+	}
+
+	cronMacros = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// ParseSchedule acepta expresiones cron de 5 campos, las macros @hourly,
+// @daily, etc. y "@every <duración>".
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+
+	if strings.HasPrefix(spec, "@every ") {
+		interval, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("@every inválido: %w", err)
+		}
+		if interval < time.Second {
+			return nil, fmt.Errorf("@every debe ser al menos 1s")
+		}
+		return everySchedule{interval: interval}, nil
+	}
+
+	if expanded, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = expanded
+	}
+
+	parts := strings.Fields(spec)
+	if len(parts) != len(cronFields) {
+		return nil, fmt.Errorf("se esperaban 5 campos en %q", spec)
+	}
+
+	bits := make([]uint64, len(cronFields))
+	for i, part := range parts {
+		value, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return nil, err
+		}
+		bits[i] = value
+	}
+
+	// 7 es un alias de domingo
+	if bits[4]&(1<<7) != 0 {
+		bits[4] = bits[4]&^(1<<7) | 1
+	}
+
+	return &cronSchedule{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		domAny: strings.HasPrefix(parts[2], "*"),
+		dowAny: strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+// parseCronField interpreta listas, rangos y pasos: "*", "*/5", "1-5", "1,15", "mon-fri".
+func parseCronField(expr string, field cronField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(expr, ",") {
+		rangeExpr, stepExpr, hasStep := strings.Cut(item, "/")
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepExpr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("paso inválido en %s: %q", field.name, item)
+			}
+			step = n
+		}
+
+		low, high := field.min, field.max
+		switch {
+		case rangeExpr == "*":
+		case strings.Contains(rangeExpr, "-"):
+			from, to, _ := strings.Cut(rangeExpr, "-")
+			var err error
+			if low, err = cronValue(from, field); err != nil {
+				return 0, err
+			}
+			if high, err = cronValue(to, field); err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, fmt.Errorf("rango inválido en %s: %q", field.name, item)
+			}
+		default:
+			value, err := cronValue(rangeExpr, field)
+			if err != nil {
+				return 0, err
+			}
+			low = value
+			if !hasStep {
+				high = value
+			}
+		}
+
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func cronValue(s string, field cronField) (int, error) {
+	if v, ok := field.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < field.min || v > field.max {
+		return 0, fmt.Errorf("valor inválido en %s: %q (%d-%d)", field.name, s, field.min, field.max)
+	}
+	return v, nil
+}
+
+// Next busca minuto a minuto saltando meses, días y horas que no coinciden.
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// countMissed cuenta las activaciones en el intervalo (from, to).
+func countMissed(schedule Schedule, from, to time.Time) int {
+	count := 0
+	for t := schedule.Next(from); !t.IsZero() && t.Before(to) && count < maxMissedCount; t = schedule.Next(t) {
+		count++
+	}
+	return count
+}
+
+// scheduledJob es una tarea periódica del daemon. run recibe el inicio de la
+// ejecución anterior (cero si nunca corrió) y devuelve un resumen para el log.
+type scheduledJob struct {
+	name     string
+	spec     string
+	schedule Schedule
+	catchUp  bool // si se perdieron activaciones, ejecutar una vez al detectarlo
+	run      func(ctx context.Context, last time.Time) (string, error)
+}
+
+// Scheduler ejecuta cada tarea en su propia goroutine y guarda su estado en
+// scheduled_jobs y el historial en job_runs.
+type Scheduler struct {
+	db     *sql.DB
+	jobs   []*scheduledJob
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewScheduler(db *sql.DB) *Scheduler {
+	return &Scheduler{db: db}
+}
+
+// Add registra una tarea; spec vacío la deshabilita.
+func (s *Scheduler) Add(name, spec string, catchUp bool, run func(ctx context.Context, last time.Time) (string, error)) error {
+	if spec == "" {
+		log.Printf("Tarea %s deshabilitada", name)
+		return nil
+	}
+
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return fmt.Errorf("tarea %s: %w", name, err)
+	}
+	s.jobs = append(s.jobs, &scheduledJob{name: name, spec: spec, schedule: schedule, catchUp: catchUp, run: run})
+	return nil
+}
+
+func (s *Scheduler) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go func(job *scheduledJob) {
+			defer s.wg.Done()
+			s.loop(ctx, job)
+		}(job)
+	}
+}
+
+// Stop cancela las tareas y espera a las que están corriendo hasta que venza ctx.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	if s.cancel == nil {
+		return nil
+	}
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("tareas sin terminar: %w", ctx.Err())
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, job *scheduledJob) {
+	last := s.lastRun(job)
+	reference := last
+	if reference.IsZero() {
+		reference = time.Now()
+	}
+
+	for {
+		now := time.Now()
+		next := job.schedule.Next(reference)
+		if next.IsZero() {
+			log.Printf("Tarea %s: la expresión %q no vuelve a activarse", job.name, job.spec)
+			return
+		}
+
+		// Activaciones perdidas por una caída, suspensión o ejecución larga
+		if next.Before(now) {
+			missed := countMissed(job.schedule, reference, now)
+			s.recordMissed(job, missed, next)
+			if job.catchUp {
+				next = now
+			} else {
+				next = job.schedule.Next(now)
+			}
+		}
+
+		s.saveNext(job, next)
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		started := time.Now()
+		s.execute(ctx, job, started, last)
+		last = started
+		reference = started
+	}
+}
+
+func (s *Scheduler) execute(ctx context.Context, job *scheduledJob, started, last time.Time) {
+	s.markRunning(job, started)
+
+	message, err := job.run(ctx, last)
+	finished := time.Now()
+	duration := finished.Sub(started)
+
+	status := "ok"
+	if err != nil {
+		status = "error"
+		log.Printf("Tarea %s falló tras %v: %v", job.name, duration.Round(time.Millisecond), err)
+	} else if message != "" {
+		log.Printf("Tarea %s completada en %v: %s", job.name, duration.Round(time.Millisecond), message)
+	}
+
+	s.saveRun(job, started, finished, status, message, err)
+}
+
+// lastRun lee el inicio de la última ejecución guardada, para detectar
+// activaciones perdidas mientras el daemon no corría.
+func (s *Scheduler) lastRun(job *scheduledJob) time.Time {
+	var started sql.NullString
+	err := s.db.QueryRow(`SELECT last_started_at FROM scheduled_jobs WHERE name = ?`, job.name).Scan(&started)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error leyendo estado de la tarea %s: %v", job.name, err)
+	}
+	if !started.Valid {
+		return time.Time{}
+	}
+
+	t, err := parseSQLiteTime(started.String)
+	if err != nil {
+		return time.Time{}
+	}
+	return t.Local()
+}
+
+func (s *Scheduler) recordMissed(job *scheduledJob, missed int, since time.Time) {
+	if missed == 0 {
+		return
+	}
+
+	action := "se omiten"
+	if job.catchUp {
+		action = "se ejecuta una vez ahora"
+	}
+	message := fmt.Sprintf("%d ejecuciones perdidas desde %s, %s", missed, since.Format(time.RFC3339), action)
+	log.Printf("Tarea %s: %s", job.name, message)
+
+	now := time.Now().UTC().Format(sqliteTimeFormat)
+	s.exec(job, `INSERT INTO job_runs (job, started_at, finished_at, status, message) VALUES (?, ?, ?, 'missed', ?)`,
+		job.name, now, now, message)
+	s.exec(job, `INSERT INTO scheduled_jobs (name, schedule, missed) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET schedule = excluded.schedule, missed = missed + excluded.missed`,
+		job.name, job.spec, missed)
+}
+
+func (s *Scheduler) saveNext(job *scheduledJob, next time.Time) {
+	s.exec(job, `INSERT INTO scheduled_jobs (name, schedule, next_run_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET schedule = excluded.schedule, next_run_at = excluded.next_run_at`,
+		job.name, job.spec, next.UTC().Format(sqliteTimeFormat))
+}
+
+func (s *Scheduler) markRunning(job *scheduledJob, started time.Time) {
+	s.exec(job, `UPDATE scheduled_jobs SET last_status = 'running', last_started_at = ? WHERE name = ?`,
+		started.UTC().Format(sqliteTimeFormat), job.name)
+}
+
+func (s *Scheduler) saveRun(job *scheduledJob, started, finished time.Time, status, message string, runErr error) {
+	var errText sql.NullString
+	failed := 0
+	if runErr != nil {
+		errText = sql.NullString{String: runErr.Error(), Valid: true}
+		failed = 1
+	}
+	durationMS := finished.Sub(started).Milliseconds()
+
+	s.exec(job, `INSERT INTO job_runs (job, started_at, finished_at, duration_ms, status, message, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		job.name, started.UTC().Format(sqliteTimeFormat), finished.UTC().Format(sqliteTimeFormat),
+		durationMS, status, nullString(message), errText)
+	s.exec(job, `UPDATE scheduled_jobs SET
+			last_finished_at = ?, last_status = ?, last_message = ?, last_error = ?, last_duration_ms = ?,
+			runs = runs + 1, failures = failures + ?
+		WHERE name = ?`,
+		finished.UTC().Format(sqliteTimeFormat), status, nullString(message), errText, durationMS, failed, job.name)
+}
+
+func (s *Scheduler) exec(job *scheduledJob, query string, args ...interface{}) {
+	if _, err := s.db.Exec(query, args...); err != nil {
+		log.Printf("Error guardando estado de la tarea %s: %v", job.name, err)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScheduleRejectsInvalidSpecs(t *testing.T) {
+	cases := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"1- * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"@every",
+		"@every ",
+		"@every 500ms",
+		"@every -1m",
+		"@every diez",
+		"@fortnightly",
+	}
+	for _, spec := range cases {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) aceptada", spec)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	at := func(value string) time.Time {
+		t.Helper()
+		parsed, err := time.Parse("2006-01-02 15:04:05", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	cases := []struct {
+		spec, from, want string
+	}{
+		// Pasos, listas y rangos
+		{"*/15 * * * *", "2026-02-01 10:07:30", "2026-02-01 10:15:00"},
+		{"5/20 * * * *", "2026-02-01 10:26:00", "2026-02-01 10:45:00"},
+		{"0,30 8-9 * * *", "2026-02-01 09:30:00", "2026-02-02 08:00:00"},
+		{"0 1-10/3 * * *", "2026-02-01 04:00:00", "2026-02-01 07:00:00"},
+		{"30 9 * jan,jul mon-fri", "2026-02-01 00:00:00", "2026-07-01 09:30:00"},
+
+		// Siempre estrictamente posterior
+		{"@hourly", "2026-02-01 10:00:00", "2026-02-01 11:00:00"},
+		{"@daily", "2026-12-31 23:59:59", "2027-01-01 00:00:00"},
+		{"@weekly", "2026-02-01 00:00:00", "2026-02-08 00:00:00"},
+		{"@yearly", "2026-02-01 00:00:00", "2027-01-01 00:00:00"},
+
+		// Día del mes y de la semana: con los dos restringidos basta uno
+		{"0 12 13 * *", "2026-02-01 00:00:00", "2026-02-13 12:00:00"},
+		{"0 12 * * 5", "2026-02-01 00:00:00", "2026-02-06 12:00:00"},
+		{"0 12 13 * 5", "2026-02-07 00:00:00", "2026-02-13 12:00:00"},
+		{"0 12 20 * 1", "2026-02-01 00:00:00", "2026-02-02 12:00:00"},
+		{"0 12 20 * 1", "2026-02-17 00:00:00", "2026-02-20 12:00:00"},
+		// Un campo con * (aunque tenga paso) no está restringido: deben coincidir los dos
+		{"0 12 */2 * 1", "2026-02-01 00:00:00", "2026-02-09 12:00:00"},
+		{"0 12 1 * */2", "2026-02-02 00:00:00", "2026-03-01 12:00:00"},
+
+		// 7 es domingo, también dentro de un rango
+		{"0 0 * * 7", "2026-02-02 00:00:00", "2026-02-08 00:00:00"},
+		{"0 0 * * 6-7", "2026-02-02 00:00:00", "2026-02-07 00:00:00"},
+
+		// Fechas que no existen todos los años o nunca
+		{"0 0 29 2 *", "2026-03-01 00:00:00", "2028-02-29 00:00:00"},
+		{"0 0 31 4 *", "2026-01-01 00:00:00", ""},
+
+		// @every suma el intervalo sin alinear
+		{"@every 90s", "2026-02-01 10:00:10", "2026-02-01 10:01:40"},
+		{"@every 1h30m", "2026-02-01 23:00:00", "2026-02-02 00:30:00"},
+		{"@every 1s", "2026-02-01 10:00:00", "2026-02-01 10:00:01"},
+	}
+
+	for _, tc := range cases {
+		schedule, err := ParseSchedule(tc.spec)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tc.spec, err)
+			continue
+		}
+
+		got := schedule.Next(at(tc.from))
+		if tc.want == "" {
+			if !got.IsZero() {
+				t.Errorf("%q desde %s = %s, no debía activarse", tc.spec, tc.from, got)
+			}
+			continue
+		}
+		if want := at(tc.want); !got.Equal(want) {
+			t.Errorf("%q desde %s = %s, se esperaba %s", tc.spec, tc.from, got, want)
+		}
+	}
+}
+
+func TestCountMissed(t *testing.T) {
+	schedule, err := ParseSchedule("*/10 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2026, 2, 1, 10, 0, 0, 0, time.UTC)
+
+	// (from, to) abierto: 10:10 ... 10:50, sin contar 11:00
+	if got := countMissed(schedule, from, from.Add(time.Hour)); got != 5 {
+		t.Errorf("%d activaciones perdidas, se esperaban 5", got)
+	}
+
+	every, err := ParseSchedule("@every 1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := countMissed(every, from, from.Add(24*time.Hour)); got != maxMissedCount {
+		t.Errorf("%d activaciones perdidas, se esperaba el tope %d", got, maxMissedCount)
+	}
+}
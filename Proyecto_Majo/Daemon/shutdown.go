@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Códigos de salida del daemon.
+const (
+	exitOK              = 0
+	exitError           = 1 // falló algún paso de limpieza al apagar
+	exitConfig          = 2 // configuración, política o perfil de carga inválidos
+	exitInit            = 3 // no se pudo abrir o migrar la base de datos
+	exitShutdownTimeout = 4 // la iteración en curso no terminó dentro de shutdown-timeout
+	exitLocked          = 5 // otra instancia tiene el lock de instancia única
+)
+
+// cleanupStep es un paso de apagado registrado durante el arranque.
+type cleanupStep struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// onShutdown registra un paso de limpieza; se ejecutan en orden inverso al registro.
+func (d *Daemon) onShutdown(name string, run func(ctx context.Context) error) {
+	d.cleanups = append(d.cleanups, cleanupStep{name: name, run: run})
+}
+
+// shutdown ejecuta los pasos registrados del último al primero y devuelve
+// false si alguno falló. Cada paso recibe ctx con el plazo total de limpieza.
+func (d *Daemon) shutdown(timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ok := true
+	for i := len(d.cleanups) - 1; i >= 0; i-- {
+		step := d.cleanups[i]
+		log.Printf("Apagando: %s", step.name)
+		if err := step.run(ctx); err != nil {
+			log.Printf("Error apagando %s: %v", step.name, err)
+			ok = false
+		}
+	}
+	d.cleanups = nil
+
+	log.Println("Limpieza completada")
+	return ok
+}
+
+// waitLoop espera a que termine el loop principal; si no termina dentro del
+// plazo cancela las operaciones en curso y espera un poco más.
+func waitLoop(done <-chan struct{}, timeout time.Duration, abort context.CancelFunc) bool {
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+	}
+
+	log.Printf("La iteración en curso no terminó en %v, cancelando operaciones", timeout)
+	abort()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		log.Println("El loop principal no respondió a la cancelación")
+	}
+	return false
+}
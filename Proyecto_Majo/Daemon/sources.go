@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fuentes de métricas seleccionables con -metrics-source.
+const (
+	sourceAuto    = "auto" // módulo de kernel si está cargado, si no /proc
+	sourceKernel  = "kernel"
+	sourceProc    = "proc"
+	sourceFixture = "fixture"
+)
+
+// MetricsSource entrega las muestras que consume cada iteración, con las
+// mismas estructuras que producen los módulos de kernel.
+type MetricsSource interface {
+	Name() string
+	// Available indica si la fuente puede leerse ahora (p. ej. módulo cargado).
+	Available() error
+	ReadSystemInfo() (*SystemInfo, error)
+	ReadContainerInfo() (*ContainerInfo, error)
+}
+
+// sourceReadError identifica el archivo que falló, para la métrica de errores de lectura.
+type sourceReadError struct {
+	Path string
+	Err  error
+}
+
+func (e *sourceReadError) Error() string { return fmt.Sprintf("%s: %v", e.Path, e.Err) }
+func (e *sourceReadError) Unwrap() error { return e.Err }
+
+// readJSONFile decodifica path en out, envolviendo cualquier error con la ruta.
+func readJSONFile(path string, out interface{}) error {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, out)
+	}
+	if err != nil {
+		return &sourceReadError{Path: path, Err: err}
+	}
+	return nil
+}
+
+// kernelSource lee los archivos /proc de los módulos sysinfo y continfo.
+type kernelSource struct {
+	systemPath    string
+	containerPath string
+}
+
+func (s *kernelSource) Name() string { return sourceKernel }
+
+func (s *kernelSource) Available() error {
+	for _, path := range []string{s.systemPath, s.containerPath} {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("%s no existe, ¿están cargados los módulos de kernel?", path)
+		}
+	}
+	return nil
+}
+
+func (s *kernelSource) ReadSystemInfo() (*SystemInfo, error) {
+	var info SystemInfo
+	if err := readJSONFile(s.systemPath, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (s *kernelSource) ReadContainerInfo() (*ContainerInfo, error) {
+	var info ContainerInfo
+	if err := readJSONFile(s.containerPath, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// fixtureSource lee sysinfo.json y continfo.json de un directorio, para
+// pruebas y para reproducir muestras guardadas.
+type fixtureSource struct {
+	dir string
+}
+
+func (s *fixtureSource) Name() string { return sourceFixture }
+
+func (s *fixtureSource) Available() error {
+	for _, name := range []string{"sysinfo.json", "continfo.json"} {
+		path := filepath.Join(s.dir, name)
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("%s no existe en el directorio de fixtures", path)
+		}
+	}
+	return nil
+}
+
+func (s *fixtureSource) ReadSystemInfo() (*SystemInfo, error) {
+	var info SystemInfo
+	if err := readJSONFile(filepath.Join(s.dir, "sysinfo.json"), &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (s *fixtureSource) ReadContainerInfo() (*ContainerInfo, error) {
+	var info ContainerInfo
+	if err := readJSONFile(filepath.Join(s.dir, "continfo.json"), &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// procSource arma las mismas estructuras que los módulos desde /proc en
+// espacio de usuario. La CPU sale de la diferencia de utime+stime entre
+// muestras; en la primera muestra de un proceso se usa la heurística por
+// estado de los módulos.
+type procSource struct {
+	root string
+
+	mu       sync.Mutex
+	clkTck   float64
+	prevCPU  map[int]procCPUSample
+	last     *procSnapshot
+	lastRead time.Time
+}
+
+type procCPUSample struct {
+	ticks int64
+	at    time.Time
+}
+
+// procSnapshot es una lectura completa de /proc, compartida por
+// ReadSystemInfo y ReadContainerInfo de la misma iteración.
+type procSnapshot struct {
+	timestamp string
+	memory    MemoryInfo
+	summary   ProcessSummary
+	processes []Process
+	parents   map[int]string // PID -> comm, para la heurística de contenedores
+}
+
+// procSnapshotTTL evita releer /proc entre las dos lecturas de una iteración.
+const procSnapshotTTL = time.Second
+
+// Palabras del comm que los módulos usan para reconocer procesos de contenedores.
+var (
+	containerComms = []string{"docker", "containerd", "runc", "pause", "container", "podman", "cri-o", "shim"}
+	containerPPIDs = []string{"containerd", "dockerd", "docker"}
+)
+
+func newProcSource(root string) *procSource {
+	return &procSource{
+		root:    root,
+		clkTck:  100, // USER_HZ en todas las arquitecturas que soporta Docker
+		prevCPU: make(map[int]procCPUSample),
+	}
+}
+
+func (s *procSource) Name() string { return sourceProc }
+
+func (s *procSource) Available() error {
+	_, err := os.Stat(filepath.Join(s.root, "meminfo"))
+	return err
+}
+
+func (s *procSource) ReadSystemInfo() (*SystemInfo, error) {
+	snap, err := s.snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SystemInfo{
+		Timestamp:      snap.timestamp,
+		System:         s.systemDetails(),
+		Memory:         snap.memory,
+		ProcessSummary: snap.summary,
+		Processes:      snap.processes,
+	}, nil
+}
+
+func (s *procSource) ReadContainerInfo() (*ContainerInfo, error) {
+	snap, err := s.snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ContainerInfo{Timestamp: snap.timestamp, Memory: snap.memory, Containers: []Container{}}
+	for _, p := range snap.processes {
+		if !isContainerProcess(p.Name, snap.parents[p.PPID]) {
+			continue
+		}
+		info.Containers = append(info.Containers, Container{
+			PID:           p.PID,
+			PPID:          p.PPID,
+			Name:          p.Name,
+			Cmdline:       p.Cmdline,
+			VSZKB:         p.VSZKB,
+			RSSKB:         p.RSSKB,
+			MemoryPercent: p.MemoryPercent,
+			CPUPercent:    p.CPUPercent,
+		})
+	}
+	return info, nil
+}
+
+// isContainerProcess replica is_container_process del módulo continfo.
+func isContainerProcess(comm, parentComm string) bool {
+	for _, word := range containerComms {
+		if strings.Contains(comm, word) {
+			return true
+		}
+	}
+	for _, word := range containerPPIDs {
+		if strings.Contains(parentComm, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *procSource) snapshot() (*procSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.last != nil && now.Sub(s.lastRead) < procSnapshotTTL {
+		return s.last, nil
+	}
+
+	memory, err := s.readMeminfo()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(s.root)
+	if err != nil {
+		return nil, &sourceReadError{Path: s.root, Err: err}
+	}
+
+	snap := &procSnapshot{
+		timestamp: now.UTC().Format(sqliteTimeFormat),
+		memory:    memory,
+		parents:   make(map[int]string),
+	}
+	seen := make(map[int]procCPUSample)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+
+		// El proceso puede terminar mientras se lee; simplemente se omite
+		p, ticks, err := s.readProcess(pid, memory.TotalKB)
+		if err != nil {
+			continue
+		}
+
+		sample := procCPUSample{ticks: ticks, at: now}
+		if prev, ok := s.prevCPU[pid]; ok && ticks >= prev.ticks {
+			if elapsed := now.Sub(prev.at).Seconds(); elapsed > 0 {
+				p.CPUPercent = int(float64(ticks-prev.ticks) / s.clkTck / elapsed * 100)
+			}
+		} else {
+			p.CPUPercent = stateCPUPercent(p.State)
+		}
+		seen[pid] = sample
+
+		snap.parents[pid] = p.Name
+		snap.processes = append(snap.processes, p)
+		snap.summary.Total++
+		switch p.State {
+		case "RUNNING":
+			snap.summary.Running++
+		case "INTERRUPTIBLE", "UNINTERRUPTIBLE":
+			snap.summary.Sleeping++
+		default:
+			snap.summary.Other++
+		}
+	}
+	sort.Slice(snap.processes, func(i, j int) bool { return snap.processes[i].PID < snap.processes[j].PID })
+
+	// Los PIDs que ya no existen se olvidan para no confundirlos si se reutilizan
+	s.prevCPU = seen
+	s.last, s.lastRead = snap, now
+	return snap, nil
+}
+
+// readMeminfo calcula la memoria como el módulo: usada = total - libre.
+func (s *procSource) readMeminfo() (MemoryInfo, error) {
+	path := filepath.Join(s.root, "meminfo")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return MemoryInfo{}, &sourceReadError{Path: path, Err: err}
+	}
+
+	var info MemoryInfo
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, _ := strconv.ParseInt(fields[1], 10, 64)
+		switch fields[0] {
+		case "MemTotal:":
+			info.TotalKB = value
+		case "MemFree:":
+			info.FreeKB = value
+		}
+	}
+	if info.TotalKB == 0 {
+		return MemoryInfo{}, &sourceReadError{Path: path, Err: errors.New("sin MemTotal")}
+	}
+	info.UsedKB = info.TotalKB - info.FreeKB
+	return info, nil
+}
+
+// readProcess lee /proc/[pid]/stat y /proc/[pid]/status y devuelve el proceso
+// con los ticks de CPU acumulados (utime+stime).
+func (s *procSource) readProcess(pid int, totalKB int64) (Process, int64, error) {
+	dir := filepath.Join(s.root, strconv.Itoa(pid))
+
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return Process{}, 0, err
+	}
+
+	// El comm va entre paréntesis y puede contener espacios o paréntesis
+	lparen, rparen := bytes.IndexByte(stat, '('), bytes.LastIndexByte(stat, ')')
+	if lparen < 0 || rparen < lparen {
+		return Process{}, 0, fmt.Errorf("stat inválido para %d", pid)
+	}
+	fields := strings.Fields(string(stat[rparen+1:]))
+	if len(fields) < 13 {
+		return Process{}, 0, fmt.Errorf("stat incompleto para %d", pid)
+	}
+
+	p := Process{PID: pid, Name: string(stat[lparen+1 : rparen]), State: procState(fields[0])}
+	p.PPID, _ = strconv.Atoi(fields[1])
+	utime, _ := strconv.ParseInt(fields[11], 10, 64)
+	stime, _ := strconv.ParseInt(fields[12], 10, 64)
+
+	// VmSize y VmRSS no aparecen en los hilos de kernel, que quedan en cero
+	if status, err := os.ReadFile(filepath.Join(dir, "status")); err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(status))
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "VmSize:"):
+				p.VSZKB = statusKB(line)
+			case strings.HasPrefix(line, "VmRSS:"):
+				p.RSSKB = statusKB(line)
+			}
+		}
+	}
+	if totalKB > 0 {
+		p.MemoryPercent = int(p.RSSKB * 100 / totalKB)
+	}
+
+	// Igual que el módulo: comm, o [comm] si no tiene espacio de usuario
+	p.Cmdline = p.Name
+	if p.VSZKB == 0 {
+		p.Cmdline = "[" + p.Name + "]"
+	}
+
+	return p, utime + stime, nil
+}
+
+func statusKB(line string) int64 {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return 0
+	}
+	value, _ := strconv.ParseInt(fields[1], 10, 64)
+	return value
+}
+
+// procState traduce la letra de estado de /proc a los nombres del módulo sysinfo.
+func procState(letter string) string {
+	switch letter {
+	case "R":
+		return "RUNNING"
+	case "S":
+		return "INTERRUPTIBLE"
+	case "D":
+		return "UNINTERRUPTIBLE"
+	case "T":
+		return "STOPPED"
+	case "t":
+		return "TRACED"
+	case "X", "Z":
+		return "DEAD"
+	default:
+		return "OTHER"
+	}
+}
+
+// stateCPUPercent es la heurística de get_cpu_percent de los módulos.
+func stateCPUPercent(state string) int {
+	switch state {
+	case "RUNNING":
+		return 3
+	case "INTERRUPTIBLE", "UNINTERRUPTIBLE":
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (s *procSource) systemDetails() SystemDetails {
+	details := SystemDetails{
+		Kernel:       readTrimmed(filepath.Join(s.root, "sys/kernel/osrelease")),
+		Architecture: readTrimmed(filepath.Join(s.root, "sys/kernel/arch")),
+		Hostname:     readTrimmed(filepath.Join(s.root, "sys/kernel/hostname")),
+	}
+	if details.Architecture == "" {
+		details.Architecture = runtime.GOARCH
+	}
+	return details
+}
+
+func readTrimmed(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// metricsSources devuelve las fuentes candidatas en orden de preferencia.
+func (d *Daemon) metricsSources() []MetricsSource {
+	kernel := &kernelSource{systemPath: d.config.SystemInfoPath, containerPath: d.config.ContainerInfoPath}
+	switch d.config.MetricsSource {
+	case sourceKernel:
+		return []MetricsSource{kernel}
+	case sourceProc:
+		return []MetricsSource{d.procSource}
+	case sourceFixture:
+		return []MetricsSource{&fixtureSource{dir: d.config.FixtureDir}}
+	default:
+		return []MetricsSource{kernel, d.procSource}
+	}
+}
+
+// readSample lee la muestra de la primera fuente disponible que responda;
+// en modo auto una fuente que falla cede su lugar a la siguiente.
+func (d *Daemon) readSample() (*SystemInfo, *ContainerInfo, error) {
+	var failures []string
+	for _, source := range d.metricsSources() {
+		if err := source.Available(); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", source.Name(), err))
+			continue
+		}
+
+		system, err := source.ReadSystemInfo()
+		var containers *ContainerInfo
+		if err == nil {
+			containers, err = source.ReadContainerInfo()
+		}
+		if err != nil {
+			d.observeSourceError(source, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", source.Name(), err))
+			continue
+		}
+
+		if d.activeSource != source.Name() {
+			if d.activeSource == "" {
+				log.Printf("Fuente de métricas: %s", source.Name())
+			} else {
+				log.Printf("Fuente de métricas cambió de %s a %s", d.activeSource, source.Name())
+			}
+			d.activeSource = source.Name()
+		}
+		return system, containers, nil
+	}
+	return nil, nil, fmt.Errorf("ninguna fuente de métricas disponible (%s)", strings.Join(failures, "; "))
+}
+
+func (d *Daemon) observeSourceError(source MetricsSource, err error) {
+	var readErr *sourceReadError
+	if errors.As(err, &readErr) {
+		d.metrics.observeProcReadError(readErr.Path)
+		return
+	}
+	d.metrics.observeProcReadError(source.Name())
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFixtureSourceParsesSample(t *testing.T) {
+	source := &fixtureSource{dir: filepath.Join("testdata", "fixture")}
+	if err := source.Available(); err != nil {
+		t.Fatalf("available: %v", err)
+	}
+
+	system, err := source.ReadSystemInfo()
+	if err != nil {
+		t.Fatalf("sysinfo: %v", err)
+	}
+	if system.System.Hostname != "sopes1-vm" || system.Memory.TotalKB != 8048576 || system.ProcessSummary.Total != 4 {
+		t.Errorf("sysinfo mal decodificado: %+v", system)
+	}
+	if len(system.Processes) != 4 || system.Processes[2].Name != "stress" || system.Processes[2].PPID != 812 {
+		t.Errorf("procesos mal decodificados: %+v", system.Processes)
+	}
+
+	containers, err := source.ReadContainerInfo()
+	if err != nil {
+		t.Fatalf("continfo: %v", err)
+	}
+	if len(containers.Containers) != 2 {
+		t.Fatalf("se esperaban 2 contenedores, hay %d", len(containers.Containers))
+	}
+	if c := containers.Containers[0]; c.PID != 2301 || c.RSSKB != 65536 || c.CPUPercent != 97 {
+		t.Errorf("contenedor mal decodificado: %+v", c)
+	}
+}
+
+func TestFixtureSourceRequiresContainerFile(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile(filepath.Join("testdata", "fixture", "sysinfo.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sysinfo.json"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&fixtureSource{dir: dir}).Available(); err == nil {
+		t.Error("un directorio sin continfo.json no debe estar disponible")
+	}
+}
+
+// fakeProc arma un /proc mínimo: meminfo y, por proceso, stat y status.
+type fakeProc struct {
+	t    *testing.T
+	root string
+}
+
+func newFakeProc(t *testing.T) *fakeProc {
+	t.Helper()
+	p := &fakeProc{t: t, root: t.TempDir()}
+	p.write("meminfo", "MemTotal:        8048576 kB\nMemFree:         2048576 kB\nMemAvailable:    4000000 kB\n")
+	return p
+}
+
+func (p *fakeProc) write(name, content string) {
+	p.t.Helper()
+	path := filepath.Join(p.root, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		p.t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+// process escribe el proceso con los ticks de CPU acumulados en utime y stime.
+func (p *fakeProc) process(pid, ppid int, comm, state string, utime, stime, rssKB int64) {
+	p.t.Helper()
+	p.write(fmt.Sprintf("%d/stat", pid), fmt.Sprintf("%d (%s) %s %d %d %d 0 -1 4194304 100 0 0 0 %d %d 0 0 20 0 1 0 1000 0 0\n",
+		pid, comm, state, ppid, pid, pid, utime, stime))
+	p.write(fmt.Sprintf("%d/status", pid), fmt.Sprintf("Name:\t%s\nVmSize:\t  %d kB\nVmRSS:\t  %d kB\n", comm, rssKB*2, rssKB))
+}
+
+func findProcess(processes []Process, pid int) (Process, bool) {
+	for _, p := range processes {
+		if p.PID == pid {
+			return p, true
+		}
+	}
+	return Process{}, false
+}
+
+func TestProcSourceCPUFromTickDelta(t *testing.T) {
+	proc := newFakeProc(t)
+	proc.process(812, 1, "containerd", "S", 10, 5, 45000)
+	proc.process(2301, 812, "stress ng (x)", "R", 150, 50, 65536)
+	source := newProcSource(proc.root)
+
+	// Primera muestra: sin lectura anterior se usa la heurística por estado
+	system, err := source.ReadSystemInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stress, ok := findProcess(system.Processes, 2301)
+	if !ok {
+		t.Fatalf("falta el PID 2301: %+v", system.Processes)
+	}
+	if stress.Name != "stress ng (x)" || stress.PPID != 812 || stress.RSSKB != 65536 || stress.State != "RUNNING" {
+		t.Errorf("proceso mal leído: %+v", stress)
+	}
+	if stress.CPUPercent != stateCPUPercent("RUNNING") {
+		t.Errorf("primera muestra: CPU %d%%, se esperaba la heurística", stress.CPUPercent)
+	}
+
+	// 100 ticks más (USER_HZ=100) en 2 segundos son el 50% de una CPU
+	source.mu.Lock()
+	source.prevCPU[2301] = procCPUSample{ticks: 200, at: time.Now().Add(-2 * time.Second)}
+	source.last = nil
+	source.mu.Unlock()
+	proc.process(2301, 812, "stress ng (x)", "R", 220, 80, 65536)
+
+	system, err = source.ReadSystemInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stress, _ = findProcess(system.Processes, 2301)
+	if stress.CPUPercent < 48 || stress.CPUPercent > 50 {
+		t.Errorf("CPU %d%%, se esperaba ~50%% por la diferencia de utime+stime", stress.CPUPercent)
+	}
+
+	// El hijo de containerd se reporta como contenedor, igual que en continfo
+	containers, err := source.ReadContainerInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers.Containers) != 2 {
+		t.Errorf("se esperaban containerd y su hijo como contenedores: %+v", containers.Containers)
+	}
+}
+
+func TestReadSampleFallsBackToProc(t *testing.T) {
+	proc := newFakeProc(t)
+	proc.process(2301, 1, "stress", "R", 150, 50, 65536)
+
+	config := defaultConfig()
+	config.MetricsSource = sourceAuto
+	config.SystemInfoPath = filepath.Join(t.TempDir(), "sysinfo_202010040")
+	config.ContainerInfoPath = filepath.Join(t.TempDir(), "continfo_202010040")
+	d := &Daemon{config: config, procSource: newProcSource(proc.root), metrics: newDaemonMetrics()}
+
+	system, _, err := d.readSample()
+	if err != nil {
+		t.Fatalf("readSample: %v", err)
+	}
+	if d.activeSource != sourceProc {
+		t.Errorf("fuente activa %q, se esperaba %q", d.activeSource, sourceProc)
+	}
+	if _, ok := findProcess(system.Processes, 2301); !ok {
+		t.Errorf("la muestra no viene de /proc: %+v", system.Processes)
+	}
+
+	// Con la fuente fija en kernel no hay respaldo
+	d.config.MetricsSource = sourceKernel
+	if _, _, err := d.readSample(); err == nil {
+		t.Error("metrics-source=kernel sin módulos debe fallar")
+	}
+}
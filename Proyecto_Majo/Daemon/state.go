@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxIterationHistory es la cantidad de iteraciones que se conservan en memoria.
+const maxIterationHistory = 100
+
+// IterationRecord resume una iteración del loop principal.
+type IterationRecord struct {
+	ID         int64     `json:"id"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS int64     `json:"duration_ms"`
+	Forced     bool      `json:"forced"`
+	Containers int       `json:"containers"`
+	Kills      int       `json:"kills"`
+	Creates    int       `json:"creates"`
+	Errors     []string  `json:"errors"`
+}
+
+// runtimeState es el estado compartido entre el loop principal y la API HTTP.
+type runtimeState struct {
+	mu sync.Mutex
+
+	iterations     []IterationRecord
+	nextIteration  int64
+	classification *Classification
+	classifiedAt   time.Time
+	paused         bool
+	protected      map[string]string // nombre o ID completo -> valor indicado por el usuario
+
+	// Copia de la configuración recargable, para leerla fuera del loop principal
+	dryRun       bool
+	loopInterval time.Duration
+
+	force chan struct{}
+}
+
+func newRuntimeState() *runtimeState {
+	return &runtimeState{
+		nextIteration: 1,
+		protected:     make(map[string]string),
+		force:         make(chan struct{}, 1),
+	}
+}
+
+// setNextIteration fija el próximo ID, continuando la numeración guardada en la base de datos.
+func (s *runtimeState) setNextIteration(id int64) {
+	s.mu.Lock()
+	s.nextIteration = id
+	s.mu.Unlock()
+}
+
+// beginIteration abre un registro nuevo; solo lo usa el loop principal.
+func (s *runtimeState) beginIteration(forced bool) *IterationRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := &IterationRecord{ID: s.nextIteration, StartedAt: time.Now(), Forced: forced}
+	s.nextIteration++
+	return record
+}
+
+// finishIteration guarda el registro en el historial acotado.
+func (s *runtimeState) finishIteration(record *IterationRecord) {
+	record.DurationMS = time.Since(record.StartedAt).Milliseconds()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.iterations = append(s.iterations, *record)
+	if len(s.iterations) > maxIterationHistory {
+		s.iterations = s.iterations[len(s.iterations)-maxIterationHistory:]
+	}
+}
+
+// recentIterations devuelve las últimas n iteraciones, la más reciente primero.
+func (s *runtimeState) recentIterations(n int) []IterationRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n <= 0 || n > len(s.iterations) {
+		n = len(s.iterations)
+	}
+
+	result := make([]IterationRecord, 0, n)
+	for i := len(s.iterations) - 1; i >= len(s.iterations)-n; i-- {
+		result = append(result, s.iterations[i])
+	}
+	return result
+}
+
+func (s *runtimeState) setClassification(classification *Classification) {
+	s.mu.Lock()
+	s.classification = classification
+	s.classifiedAt = time.Now()
+	s.mu.Unlock()
+}
+
+// forgetContainer quita de la última clasificación un contenedor que terminó,
+// sin esperar a la próxima iteración.
+func (s *runtimeState) forgetContainer(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.classification == nil {
+		return
+	}
+
+	keep := func(containers []Container) []Container {
+		var kept []Container
+		for _, c := range containers {
+			if c.ContainerID != id {
+				kept = append(kept, c)
+			}
+		}
+		return kept
+	}
+
+	// La clasificación anterior puede estar en uso por la API; se reemplaza por una copia
+	updated := &Classification{
+		Classes:      make([]ClassResult, len(s.classification.Classes)),
+		Unclassified: keep(s.classification.Unclassified),
+	}
+	for i, class := range s.classification.Classes {
+		updated.Classes[i] = ClassResult{Policy: class.Policy, Containers: keep(class.Containers)}
+	}
+	s.classification = updated
+}
+
+func (s *runtimeState) lastClassification() (*Classification, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.classification, s.classifiedAt
+}
+
+// setSettings se llama al iniciar y después de cada recarga de configuración.
+func (s *runtimeState) setSettings(config *DaemonConfig) {
+	s.mu.Lock()
+	s.dryRun = config.DryRun
+	s.loopInterval = config.LoopInterval
+	s.mu.Unlock()
+}
+
+func (s *runtimeState) settings() (dryRun bool, loopInterval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dryRun, s.loopInterval
+}
+
+func (s *runtimeState) setPaused(paused bool) {
+	s.mu.Lock()
+	s.paused = paused
+	s.mu.Unlock()
+}
+
+func (s *runtimeState) isPaused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// requestIteration pide una iteración inmediata; si ya hay una pendiente no hace nada.
+func (s *runtimeState) requestIteration() bool {
+	select {
+	case s.force <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *runtimeState) protect(key, label string) {
+	s.mu.Lock()
+	s.protected[key] = label
+	s.mu.Unlock()
+}
+
+// unprotect quita la protección por clave exacta o por el valor indicado al protegerla.
+func (s *runtimeState) unprotect(value string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := false
+	for key, label := range s.protected {
+		if key == value || label == value {
+			delete(s.protected, key)
+			removed = true
+		}
+	}
+	return removed
+}
+
+func (s *runtimeState) protectedList() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]string, 0, len(s.protected))
+	for key := range s.protected {
+		list = append(list, key)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// isProtected indica si el contenedor está protegido por nombre de proceso o por ID.
+func (s *runtimeState) isProtected(c Container) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.protected) == 0 {
+		return false
+	}
+	if _, ok := s.protected[c.Name]; ok {
+		return true
+	}
+	if c.ContainerID != "" {
+		if _, ok := s.protected[c.ContainerID]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// recordError registra un error en el log y en la iteración en curso.
+func (d *Daemon) recordError(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Print(message)
+
+	if d.iteration != nil {
+		d.iteration.Errors = append(d.iteration.Errors, message)
+	}
+}
+
+// protectContainer resuelve value como nombre o ID de Docker; si el engine no
+// lo conoce se protege como nombre de proceso del módulo de kernel.
+func (d *Daemon) protectContainer(ctx context.Context, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", fmt.Errorf("se requiere un nombre o ID de contenedor")
+	}
+
+	details, err := d.docker.InspectContainer(ctx, value)
+	if err == nil {
+		d.state.protect(details.ID, value)
+		log.Printf("Contenedor protegido: %s (%.12s)", value, details.ID)
+		return details.ID, nil
+	}
+
+	d.state.protect(value, value)
+	log.Printf("Contenedor protegido por nombre de proceso: %s (%v)", value, err)
+	return value, nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Modos de enforcement.
+const (
+	enforcementKill      = "kill"      // comportamiento original: detener y eliminar
+	enforcementGraduated = "graduated" // limitar, pausar y eliminar como último recurso
+)
+
+const (
+	cpuMaxPeriod      = 100000          // µs, periodo por defecto de cpu.max
+	minThrottleMemory = 6 * 1024 * 1024 // mínimo que acepta el engine para Memory
+)
+
+// Etapas del enforcement graduado.
+type throttleStage int
+
+const (
+	stageNone throttleStage = iota
+	stageThrottled
+	stagePaused
+)
+
+// throttleState sigue la escalada de un contenedor víctima.
+type throttleState struct {
+	stage      throttleStage
+	method     string // "cgroup" o "engine", cómo se aplicaron los límites; vacío si no hay ninguno puesto
+	paused     bool   // pausado de verdad; en dry-run la etapa avanza sin pausar
+	victimRuns int    // iteraciones seguidas como víctima en la etapa actual
+	idleRuns   int    // iteraciones seguidas sin ser víctima
+
+	// Muestra al aplicar la última etapa; su efecto se registra con la siguiente
+	before        Container
+	pendingEffect string
+}
+
+// enforceGraduated aplica la siguiente etapa a una víctima y devuelve true si la eliminó.
+func (d *Daemon) enforceGraduated(ctx context.Context, c Container, reason string) bool {
+	key := lifecycleKey(c)
+	st, ok := d.throttles[key]
+	if !ok {
+		st = &throttleState{}
+		d.throttles[key] = st
+	}
+	st.idleRuns = 0
+	st.victimRuns++
+
+	// Cada etapa tiene escalate-after iteraciones para surtir efecto
+	if st.stage != stageNone && st.victimRuns < d.config.EscalateAfter {
+		return false
+	}
+
+	switch st.stage {
+	case stageNone:
+		d.throttleContainer(ctx, c, st, reason)
+	case stageThrottled:
+		d.pauseContainer(ctx, c, st, reason)
+	default:
+		d.killContainer(ctx, c, reason+" (último recurso tras limitar y pausar)")
+		delete(d.throttles, key)
+		return true
+	}
+	return false
+}
+
+func (d *Daemon) throttleContainer(ctx context.Context, c Container, st *throttleState, reason string) {
+	memoryHigh := c.treeStats().RSSKB * 1024 * int64(d.config.ThrottleMemoryPercent) / 100
+	if memoryHigh < minThrottleMemory {
+		memoryHigh = minThrottleMemory
+	}
+	quota := int64(cpuMaxPeriod * d.config.ThrottleCPUPercent / 100)
+
+	if d.config.DryRun {
+		d.logContainerAction("WOULD_THROTTLE", c, fmt.Sprintf("%s: memory.high=%d cpu.max=%d %d", reason, memoryHigh, quota, cpuMaxPeriod))
+		st.advance(stageThrottled, c, "")
+		return
+	}
+
+	var detail string
+	if dir, ok := d.cgroupDir(c.PID); ok {
+		err := writeCgroupFile(dir, "memory.high", strconv.FormatInt(memoryHigh, 10))
+		if err == nil {
+			err = writeCgroupFile(dir, "cpu.max", fmt.Sprintf("%d %d", quota, cpuMaxPeriod))
+		}
+		if err != nil {
+			d.recordError("Error limitando contenedor %s por cgroup: %v", c.Name, err)
+			d.logContainerAction("THROTTLE_FAILED", c, fmt.Sprintf("%s: %v", reason, err))
+			return
+		}
+		st.method = "cgroup"
+		detail = fmt.Sprintf("cgroup %s: memory.high=%d cpu.max=%d %d", dir, memoryHigh, quota, cpuMaxPeriod)
+	} else {
+		id, err := d.containerIDFor(ctx, &c)
+		if err == nil {
+			// Sin acceso al cgroup el engine solo ofrece límite duro de memoria
+			err = d.docker.UpdateContainer(ctx, id, &ContainerUpdate{
+				Memory:     memoryHigh,
+				MemorySwap: -1,
+				CPUPeriod:  cpuMaxPeriod,
+				CPUQuota:   quota,
+			})
+		}
+		if err != nil {
+			d.recordError("Error limitando contenedor %s por el engine: %v", c.Name, err)
+			d.logContainerAction("THROTTLE_FAILED", c, fmt.Sprintf("%s: %v", reason, err))
+			return
+		}
+		st.method = "engine"
+		detail = fmt.Sprintf("engine update: Memory=%d CpuQuota=%d CpuPeriod=%d", memoryHigh, quota, cpuMaxPeriod)
+	}
+
+	log.Printf("Contenedor limitado: %s (%s)", c.Name, detail)
+	d.logContainerAction("THROTTLED", c, fmt.Sprintf("%s: %s", reason, detail))
+	st.advance(stageThrottled, c, "THROTTLED")
+}
+
+func (d *Daemon) pauseContainer(ctx context.Context, c Container, st *throttleState, reason string) {
+	if d.config.DryRun {
+		d.logContainerAction("WOULD_PAUSE", c, reason)
+		st.advance(stagePaused, c, "")
+		return
+	}
+
+	id, err := d.containerIDFor(ctx, &c)
+	if err == nil {
+		err = d.docker.PauseContainer(ctx, id)
+	}
+	if err != nil {
+		d.recordError("Error pausando contenedor %s: %v", c.Name, err)
+		d.logContainerAction("PAUSE_FAILED", c, fmt.Sprintf("%s: %v", reason, err))
+		return
+	}
+
+	st.paused = true
+	log.Printf("Contenedor pausado: %s", c.Name)
+	d.logContainerAction("PAUSED", c, fmt.Sprintf("%s: sigue en exceso tras %d iteraciones limitado", reason, st.victimRuns))
+	st.advance(stagePaused, c, "PAUSED")
+}
+
+// advance pasa a la etapa siguiente; action vacío no registra efecto (dry-run).
+func (st *throttleState) advance(stage throttleStage, sample Container, action string) {
+	st.stage = stage
+	st.victimRuns = 0
+	st.before = sample
+	st.pendingEffect = action
+}
+
+// reviewThrottles registra el efecto de las etapas aplicadas en la iteración
+// anterior, olvida los contenedores que desaparecieron y libera los que
+// dejaron de ser víctimas durante escalate-after iteraciones. current es la
+// muestra completa; managed, los contenedores que siguen sujetos a la
+// política: los que pasaron a protegidos o excluidos se liberan enseguida,
+// porque ya no pueden volver a ser víctimas. Un estado solo se olvida una vez
+// liberado, para no dejar límites o pausas puestos sin seguimiento.
+func (d *Daemon) reviewThrottles(ctx context.Context, current, managed map[string]Container, victims map[string]bool) {
+	for key, st := range d.throttles {
+		c, present := current[key]
+		if !present {
+			delete(d.throttles, key)
+			continue
+		}
+
+		if st.pendingEffect != "" {
+			d.logContainerAction("EFFECT", c, fmt.Sprintf("Tras %s: RSS %d -> %d KB, CPU %d -> %d%%",
+				st.pendingEffect, st.before.treeStats().RSSKB, c.treeStats().RSSKB,
+				st.before.treeStats().CPUPercent, c.treeStats().CPUPercent))
+			st.pendingEffect = ""
+		}
+
+		if _, ok := managed[key]; !ok {
+			if st.stage == stageNone || d.releaseContainer(ctx, c, st, "Protegido o excluido por la política") {
+				delete(d.throttles, key)
+			}
+			continue
+		}
+
+		if victims[key] || st.stage == stageNone {
+			continue
+		}
+		st.idleRuns++
+		if st.idleRuns >= d.config.EscalateAfter && d.releaseContainer(ctx, c, st, fmt.Sprintf("Sin exceso durante %d iteraciones", st.idleRuns)) {
+			delete(d.throttles, key)
+		}
+	}
+}
+
+// releaseContainer deshace la pausa y los límites de un contenedor que ya no
+// es víctima; devuelve false si quedó algo puesto. Lo aplicado de verdad se
+// deshace aunque dry-run esté activo (se pudo activar con SIGHUP después);
+// dry-run solo frena las acciones nuevas.
+func (d *Daemon) releaseContainer(ctx context.Context, c Container, st *throttleState, reason string) bool {
+	if st.method == "" && !st.paused {
+		// Las etapas solo se simularon: no hay nada que deshacer
+		if d.config.DryRun {
+			d.logContainerAction("WOULD_RELEASE", c, reason)
+		}
+		return true
+	}
+
+	var err error
+	if st.paused {
+		var id string
+		if id, err = d.containerIDFor(ctx, &c); err == nil {
+			err = d.docker.UnpauseContainer(ctx, id)
+		}
+		if err == nil {
+			st.paused = false
+		}
+	}
+
+	if err == nil {
+		switch st.method {
+		case "cgroup":
+			if dir, ok := d.cgroupDir(c.PID); ok {
+				err = writeCgroupFile(dir, "memory.high", "max")
+				if err == nil {
+					err = writeCgroupFile(dir, "cpu.max", fmt.Sprintf("max %d", cpuMaxPeriod))
+				}
+			} else {
+				// Sin el cgroup los límites siguen puestos: no se registra como liberado
+				err = fmt.Errorf("no se encontró el cgroup del PID %d para quitar memory.high y cpu.max", c.PID)
+			}
+		case "engine":
+			// El engine no permite quitar el límite de memoria; solo se libera la CPU
+			var id string
+			if id, err = d.containerIDFor(ctx, &c); err == nil {
+				err = d.docker.UpdateContainer(ctx, id, &ContainerUpdate{CPUQuota: -1})
+			}
+			reason += " (el límite de memoria del engine se mantiene)"
+		}
+		if err == nil {
+			st.method = ""
+		}
+	}
+
+	if err != nil {
+		d.recordError("Error liberando contenedor %s: %v", c.Name, err)
+		d.logContainerAction("RELEASE_FAILED", c, fmt.Sprintf("%s: %v", reason, err))
+		return false
+	}
+
+	log.Printf("Contenedor liberado: %s", c.Name)
+	d.logContainerAction("RELEASED", c, reason)
+	return true
+}
+
+// cgroupDir devuelve el directorio cgroup v2 del PID si es escribible desde aquí.
+func (d *Daemon) cgroupDir(pid int) (string, bool) {
+	path, err := d.cgroups.CgroupPath(pid)
+	if err != nil || path == "" {
+		return "", false
+	}
+
+	dir := filepath.Join(d.config.CgroupRoot, path)
+	if _, err := os.Stat(filepath.Join(dir, "memory.high")); err != nil {
+		return "", false
+	}
+	return dir, true
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	return os.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+}
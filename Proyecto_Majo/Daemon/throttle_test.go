@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func lastAction(t *testing.T, d *Daemon) string {
+	t.Helper()
+	var action string
+	if err := d.db.QueryRow(`SELECT action FROM container_actions ORDER BY id DESC LIMIT 1`).Scan(&action); err != nil {
+		t.Fatal(err)
+	}
+	return action
+}
+
+func TestReleaseWithoutCgroupIsRecordedAsFailed(t *testing.T) {
+	d := newTestDaemon(t)
+	c := Container{PID: 4242, Name: "stress"}
+
+	if d.releaseContainer(context.Background(), c, &throttleState{stage: stageThrottled, method: "cgroup"}, "prueba") {
+		t.Error("releaseContainer informó éxito sin cgroup")
+	}
+
+	if action := lastAction(t, d); action != "RELEASE_FAILED" {
+		t.Errorf("acción %s, se esperaba RELEASE_FAILED", action)
+	}
+}
+
+// fakeThrottledCgroup crea en el /proc y la raíz de cgroup falsos de d el
+// cgroup limitado del PID 4242 y devuelve su directorio.
+func fakeThrottledCgroup(t *testing.T, d *Daemon) string {
+	t.Helper()
+	procDir := filepath.Join(d.cgroups.procRoot, "4242")
+	group := filepath.Join(d.config.CgroupRoot, "system.slice", "docker-"+strings.Repeat("a", 64)+".scope")
+	for _, path := range []string{procDir, group} {
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(procDir, "cgroup"):    "0::/system.slice/" + filepath.Base(group) + "\n",
+		filepath.Join(group, "memory.high"): "1048576",
+		filepath.Join(group, "cpu.max"):     "20000 100000",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return group
+}
+
+func TestReleaseResetsCgroupLimits(t *testing.T) {
+	d := newTestDaemon(t)
+	group := fakeThrottledCgroup(t, d)
+
+	c := Container{PID: 4242, Name: "stress"}
+	d.releaseContainer(context.Background(), c, &throttleState{stage: stageThrottled, method: "cgroup"}, "prueba")
+
+	if action := lastAction(t, d); action != "RELEASED" {
+		t.Fatalf("acción %s, se esperaba RELEASED", action)
+	}
+	for name, want := range map[string]string{"memory.high": "max", "cpu.max": "max 100000"} {
+		got, _ := os.ReadFile(filepath.Join(group, name))
+		if string(got) != want {
+			t.Errorf("%s = %q, se esperaba %q", name, got, want)
+		}
+	}
+}
+
+func TestReviewThrottlesReleasesContainersLeavingThePolicy(t *testing.T) {
+	d := newTestDaemon(t)
+	group := fakeThrottledCgroup(t, d)
+
+	c := Container{PID: 4242, Name: "stress", ContainerID: strings.Repeat("a", 64)}
+	current := map[string]Container{lifecycleKey(c): c}
+	d.throttles[lifecycleKey(c)] = &throttleState{stage: stageThrottled, method: "cgroup"}
+
+	// Sigue en la muestra pero ya no en la clasificación (protegido o excluido)
+	d.reviewThrottles(context.Background(), current, map[string]Container{}, nil)
+
+	if action := lastAction(t, d); action != "RELEASED" {
+		t.Fatalf("acción %s, se esperaba RELEASED", action)
+	}
+	if _, ok := d.throttles[lifecycleKey(c)]; ok {
+		t.Error("el estado sigue registrado tras liberar")
+	}
+	if got, _ := os.ReadFile(filepath.Join(group, "memory.high")); string(got) != "max" {
+		t.Errorf("memory.high = %q, se esperaba max", got)
+	}
+}
+
+func TestReviewThrottlesKeepsStateWhenReleaseFails(t *testing.T) {
+	d := newTestDaemon(t)
+
+	c := Container{PID: 4242, Name: "stress"}
+	current := map[string]Container{lifecycleKey(c): c}
+	d.throttles[lifecycleKey(c)] = &throttleState{stage: stageThrottled, method: "cgroup"}
+
+	// Sin cgroup no se pueden quitar los límites: el estado se conserva para reintentar
+	d.reviewThrottles(context.Background(), current, map[string]Container{}, nil)
+
+	if action := lastAction(t, d); action != "RELEASE_FAILED" {
+		t.Fatalf("acción %s, se esperaba RELEASE_FAILED", action)
+	}
+	if _, ok := d.throttles[lifecycleKey(c)]; !ok {
+		t.Error("se olvidó el estado de un contenedor que sigue limitado")
+	}
+}
+
+func TestReleaseUndoesAppliedLimitsInDryRun(t *testing.T) {
+	d := newTestDaemon(t)
+	group := fakeThrottledCgroup(t, d)
+
+	// dry-run se activó (por SIGHUP) con el contenedor ya limitado de verdad
+	d.config.DryRun = true
+	c := Container{PID: 4242, Name: "stress"}
+	if !d.releaseContainer(context.Background(), c, &throttleState{stage: stageThrottled, method: "cgroup"}, "prueba") {
+		t.Fatal("no se liberó el contenedor")
+	}
+	if action := lastAction(t, d); action != "RELEASED" {
+		t.Fatalf("acción %s, se esperaba RELEASED", action)
+	}
+	if got, _ := os.ReadFile(filepath.Join(group, "cpu.max")); string(got) != "max 100000" {
+		t.Errorf("cpu.max = %q, se esperaba max 100000", got)
+	}
+
+	// Una etapa solo simulada no tiene nada que deshacer
+	d.releaseContainer(context.Background(), c, &throttleState{stage: stagePaused}, "prueba")
+	if action := lastAction(t, d); action != "WOULD_RELEASE" {
+		t.Errorf("acción %s, se esperaba WOULD_RELEASE", action)
+	}
+}
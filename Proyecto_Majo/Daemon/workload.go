@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Etiquetas que el daemon pone a los contenedores que crea.
+const (
+	labelManaged = "so1.monitor.managed"
+	labelClass   = "so1.monitor.class"
+)
+
+// WorkloadProfile indica qué imágenes se usan para cubrir el déficit de cada
+// clase de la política.
+type WorkloadProfile struct {
+	Classes map[string]ClassWorkload `json:"classes"`
+
+	rng *rand.Rand
+}
+
+// ClassWorkload son las imágenes de una clase con su peso relativo.
+type ClassWorkload struct {
+	NamePrefix string          `json:"name_prefix"` // por defecto "<clase>_consumption_"
+	Images     []WeightedImage `json:"images"`
+}
+
+type WeightedImage struct {
+	Image  string   `json:"image"`
+	Weight int      `json:"weight"`
+	Cmd    []string `json:"cmd,omitempty"`
+	Env    []string `json:"env,omitempty"`
+}
+
+// LoadWorkloadProfile lee y valida un perfil desde un archivo JSON.
+func LoadWorkloadProfile(path string) (*WorkloadProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var profile WorkloadProfile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&profile); err != nil {
+		return nil, fmt.Errorf("error parseando perfil %s: %w", path, err)
+	}
+
+	if err := profile.validate(); err != nil {
+		return nil, fmt.Errorf("perfil inválido %s: %w", path, err)
+	}
+	return &profile, nil
+}
+
+// DefaultWorkloadProfile reproduce las imágenes de create_containers.sh, con
+// los mismos prefijos que usa clean_containers.sh.
+func DefaultWorkloadProfile() *WorkloadProfile {
+	profile := &WorkloadProfile{Classes: map[string]ClassWorkload{
+		"high": {
+			NamePrefix: "high_consumption_",
+			Images: []WeightedImage{
+				{Image: "high-cpu-image", Weight: 1},
+				{Image: "high-ram-image", Weight: 1},
+			},
+		},
+		"low": {
+			NamePrefix: "low_consumption_",
+			Images:     []WeightedImage{{Image: "low-consumption-image", Weight: 1}},
+		},
+	}}
+	profile.validate()
+	return profile
+}
+
+func (p *WorkloadProfile) validate() error {
+	if len(p.Classes) == 0 {
+		return fmt.Errorf("no define ninguna clase")
+	}
+
+	for name, class := range p.Classes {
+		if len(class.Images) == 0 {
+			return fmt.Errorf("clase %s: no tiene imágenes", name)
+		}
+		for i, image := range class.Images {
+			if image.Image == "" {
+				return fmt.Errorf("clase %s: imagen %d sin nombre", name, i)
+			}
+			if image.Weight <= 0 {
+				return fmt.Errorf("clase %s: %s debe tener peso positivo", name, image.Image)
+			}
+		}
+		if class.NamePrefix == "" {
+			class.NamePrefix = name + "_consumption_"
+			p.Classes[name] = class
+		}
+	}
+
+	p.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	return nil
+}
+
+// pick elige n imágenes de la clase según sus pesos.
+func (p *WorkloadProfile) pick(class string, n int) ([]WeightedImage, error) {
+	workload, ok := p.Classes[class]
+	if !ok {
+		return nil, fmt.Errorf("el perfil no tiene imágenes para la clase %s", class)
+	}
+
+	total := 0
+	for _, image := range workload.Images {
+		total += image.Weight
+	}
+
+	picked := make([]WeightedImage, 0, n)
+	for i := 0; i < n; i++ {
+		r := p.rng.Intn(total)
+		for _, image := range workload.Images {
+			if r < image.Weight {
+				picked = append(picked, image)
+				break
+			}
+			r -= image.Weight
+		}
+	}
+	return picked, nil
+}
+
+// containerName genera un nombre único con el prefijo de la clase.
+func (p *WorkloadProfile) containerName(class string) string {
+	return workloadContainerName(p.Classes[class].NamePrefix, time.Now().Unix(), p.rng.Intn(100000))
+}
+
+func workloadContainerName(prefix string, unix int64, n int) string {
+	return fmt.Sprintf("%s%d_%05d", prefix, unix, n)
+}
+
+// checkPolicy verifica que el perfil sirva para la política: cada clase con
+// min > 0 necesita imágenes, y un contenedor creado con el prefijo de una
+// clase tiene que poder clasificarse en ella. Si la política lo excluye o lo
+// asigna a otra clase nunca cuenta para el déficit y se crearía otro en cada
+// iteración.
+func (p *WorkloadProfile) checkPolicy(policy *Policy) error {
+	var problems []string
+	for i := range policy.Classes {
+		class := &policy.Classes[i]
+		workload, ok := p.Classes[class.Name]
+		if !ok {
+			if class.Min > 0 {
+				problems = append(problems, fmt.Sprintf("la clase %s tiene min %d y el perfil no tiene imágenes para ella", class.Name, class.Min))
+			}
+			continue
+		}
+
+		name := workloadContainerName(workload.NamePrefix, time.Now().Unix(), 0)
+		if reason := policy.unreachable(i, name); reason != "" {
+			problems = append(problems, fmt.Sprintf("los contenedores de la clase %s (prefijo %q) %s", class.Name, workload.NamePrefix, reason))
+		}
+	}
+
+	for name := range p.Classes {
+		if policy.classByName(name) == nil {
+			log.Printf("El perfil de carga define la clase %s, que la política no tiene; se ignora", name)
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("el perfil de carga no coincide con la política: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// loadWorkloadProfile carga el perfil de la configuración y lo valida contra
+// la política con la que se va a usar.
+func loadWorkloadProfile(config *DaemonConfig, policy *Policy) (*WorkloadProfile, error) {
+	profile := DefaultWorkloadProfile()
+	if config.WorkloadProfilePath != "" {
+		var err error
+		if profile, err = LoadWorkloadProfile(config.WorkloadProfilePath); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := profile.checkPolicy(policy); err != nil {
+		return nil, err
+	}
+	if config.WorkloadProfilePath != "" {
+		log.Printf("Perfil de carga cargado desde %s", config.WorkloadProfilePath)
+	}
+	return profile, nil
+}
+
+// createWorkload crea n contenedores de la clase y devuelve cuántos arrancaron.
+func (d *Daemon) createWorkload(ctx context.Context, class string, n int) (int, error) {
+	images, err := d.workload.pick(class, n)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(n)*30*time.Second)
+	defer cancel()
+
+	created := 0
+	var failures []string
+	for _, image := range images {
+		name := d.workload.containerName(class)
+		reason := fmt.Sprintf("Déficit de clase %s (imagen %s)", class, image.Image)
+
+		id, err := d.runWorkloadContainer(ctx, name, class, image)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+			d.recordAction("CREATE_FAILED", Container{Name: name, ContainerID: id}, image.Image, fmt.Sprintf("%s: %v", reason, err))
+			if errors.Is(err, ErrDockerUnavailable) {
+				// Sin engine no tiene sentido intentar el resto
+				break
+			}
+			continue
+		}
+
+		log.Printf("Contenedor creado: %s (%s, clase %s)", name, image.Image, class)
+		d.recordAction("CREATED", Container{Name: name, ContainerID: id}, image.Image, reason)
+		created++
+	}
+
+	if len(failures) > 0 {
+		return created, fmt.Errorf("%d de %d fallaron: %s", len(failures), n, strings.Join(failures, "; "))
+	}
+	return created, nil
+}
+
+// runWorkloadContainer crea y arranca el contenedor; si no arranca lo elimina
+// para no dejar contenedores creados sin usar.
+func (d *Daemon) runWorkloadContainer(ctx context.Context, name, class string, image WeightedImage) (string, error) {
+	id, err := d.docker.CreateContainer(ctx, name, &ContainerConfig{
+		Image: image.Image,
+		Cmd:   image.Cmd,
+		Env:   image.Env,
+		Labels: map[string]string{
+			labelManaged: "true",
+			labelClass:   class,
+		},
+	})
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return "", fmt.Errorf("imagen %s no disponible: %w", image.Image, err)
+		}
+		return "", err
+	}
+
+	if err := d.docker.StartContainer(ctx, id); err != nil {
+		if rmErr := d.docker.RemoveContainer(ctx, id, true); rmErr != nil {
+			log.Printf("Error eliminando %s tras fallar el arranque: %v", name, rmErr)
+		}
+		return id, fmt.Errorf("error arrancando: %w", err)
+	}
+	return id, nil
+}
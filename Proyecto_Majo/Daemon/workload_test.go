@@ -0,0 +1,150 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorkloadProfileMatchesExamplePolicy(t *testing.T) {
+	policy, err := LoadPolicy("policy.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, err := LoadWorkloadProfile("workload.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := profile.checkPolicy(policy); err != nil {
+		t.Errorf("los ejemplos no coinciden: %v", err)
+	}
+
+	config := defaultConfig()
+	if err := DefaultWorkloadProfile().checkPolicy(DefaultPolicy(config)); err != nil {
+		t.Errorf("los valores por defecto no coinciden: %v", err)
+	}
+}
+
+func TestWorkloadProfileCheckPolicy(t *testing.T) {
+	profile := func(prefixes map[string]string) *WorkloadProfile {
+		p := &WorkloadProfile{Classes: make(map[string]ClassWorkload)}
+		for class, prefix := range prefixes {
+			p.Classes[class] = ClassWorkload{NamePrefix: prefix, Images: []WeightedImage{{Image: class + "-image", Weight: 1}}}
+		}
+		if err := p.validate(); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	policy := func(raw string) *Policy {
+		path := filepath.Join(t.TempDir(), "policy.json")
+		if err := os.WriteFile(path, []byte(raw), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		p, err := LoadPolicy(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	cases := []struct {
+		name    string
+		policy  string
+		profile map[string]string
+		problem string // vacío si debe aceptarse
+	}{
+		{
+			name:    "prefijos reconocidos por nombre",
+			policy:  `{"classes": [{"name": "web", "match": [{"name": "^web_"}], "target": 1, "min": 1}, {"name": "db", "match": [{"name": "^db_"}], "target": 1, "min": 1}]}`,
+			profile: map[string]string{"web": "web_", "db": "db_"},
+		},
+		{
+			name:    "clase sin min no necesita imágenes",
+			policy:  `{"classes": [{"name": "web", "target": 0, "min": 0}]}`,
+			profile: map[string]string{"batch": ""},
+		},
+		{
+			name:    "clase que crea sin imágenes",
+			policy:  `{"classes": [{"name": "web", "target": 2, "min": 2}]}`,
+			profile: map[string]string{"high": ""},
+			problem: "la clase web tiene min 2",
+		},
+		{
+			name:    "prefijo que la clase no reconoce",
+			policy:  `{"classes": [{"name": "web", "match": [{"name": "^web-"}], "target": 1, "min": 1}]}`,
+			profile: map[string]string{"web": "web_"},
+			problem: "no coinciden con el name",
+		},
+		{
+			name:    "prefijo excluido",
+			policy:  `{"exclude": [{"name": "^tmp_"}], "classes": [{"name": "web", "target": 1, "min": 1}]}`,
+			profile: map[string]string{"web": "tmp_web_"},
+			problem: "excluidos por exclude[0]",
+		},
+		{
+			name:    "una clase anterior se los queda por nombre",
+			policy:  `{"classes": [{"name": "all", "match": [{"name": "_"}], "target": 0, "min": 0}, {"name": "web", "match": [{"name": "^web_"}], "target": 1, "min": 1}]}`,
+			profile: map[string]string{"web": "web_"},
+			problem: "quedan en la clase all",
+		},
+		{
+			name:    "una clase anterior acepta todo",
+			policy:  `{"classes": [{"name": "any", "target": 0, "min": 0}, {"name": "web", "target": 1, "min": 1}]}`,
+			profile: map[string]string{"web": "web_"},
+			problem: "acepta cualquier contenedor",
+		},
+		{
+			// Las condiciones sobre la muestra pueden cumplirse o no: no se rechazan
+			name:    "condiciones que dependen de la muestra",
+			policy:  `{"exclude": [{"name": "^web_", "min_rss_kb": 1000000}], "classes": [{"name": "big", "match": [{"name": "^web_", "min_rss_kb": 1000}], "target": 0, "min": 0}, {"name": "web", "target": 1, "min": 1}]}`,
+			profile: map[string]string{"web": "web_"},
+		},
+	}
+
+	for _, tc := range cases {
+		err := profile(tc.profile).checkPolicy(policy(tc.policy))
+		switch {
+		case tc.problem == "" && err != nil:
+			t.Errorf("%s: rechazado: %v", tc.name, err)
+		case tc.problem != "" && (err == nil || !strings.Contains(err.Error(), tc.problem)):
+			t.Errorf("%s: error %v, se esperaba %q", tc.name, err, tc.problem)
+		}
+	}
+}
+
+func TestReloadRejectsProfileThatDoesNotMatchPolicy(t *testing.T) {
+	dir := t.TempDir()
+	args := []string{"-db-path", filepath.Join(dir, "daemon.db")}
+	config, err := LoadConfig(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := loadPolicy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workload, err := loadWorkloadProfile(config, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Daemon{config: config, args: args, policy: policy, workload: workload, alerts: newAlertManager(), state: newRuntimeState()}
+	d.alerts.rules = DefaultAlertRules()
+
+	// Un perfil válido por sí solo, pero sin la clase low que la política por defecto crea
+	profilePath := filepath.Join(dir, "workload.json")
+	if err := os.WriteFile(profilePath, []byte(`{"classes": {"high": {"images": [{"image": "high-cpu-image", "weight": 1}]}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d.args = append(args, "-workload-profile", profilePath)
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	d.reloadConfig(ticker)
+
+	if d.workload != workload || d.config.WorkloadProfilePath != "" {
+		t.Error("la recarga aplicó un perfil que no coincide con la política")
+	}
+}